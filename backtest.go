@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"crypto_trend_monitor/utils"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// runBacktest 处理 backtest 子命令：用历史K线回放趋势规则并输出收益统计
+//
+//	backtest -symbol BTCUSDT -interval 15m [-file data.csv] [-rule macd_dif] [-format json]
+func runBacktest(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	symbol := fs.String("symbol", "BTCUSDT", "交易对")
+	interval := fs.String("interval", "15m", "K线周期")
+	file := fs.String("file", "", "CSV K线文件，可包含 {symbol}、{interval}；留空读取 kline_cache.dir 中的缓存")
+	archive := fs.String("archive", "", "K线归档目录（download 命令下载）")
+	provider := fs.String("provider", "", "读取缓存时的K线提供方，默认为该币种配置的提供方")
+	rule := fs.String("rule", "", "使用的规则，默认按 rule_bindings 选择")
+	fee := fs.Float64("fee", 0.0004, "单边手续费率")
+	slippage := fs.Float64("slippage", 0.0002, "单边滑点比例")
+	short := fs.Bool("short", true, "看空状态下做空，false 时只做多")
+	horizon := fs.Int("horizon", 10, "统计状态命中率时观察之后的K线根数")
+	from := fs.String("from", "", "开始时间（含指标预热），如 2025-01-01")
+	to := fs.String("to", "", "结束时间")
+	format := fs.String("format", "text", "输出格式：text 或 json")
+	fs.Parse(args)
+
+	if !config.IsKnownInterval(*interval) {
+		return fmt.Errorf("不支持的周期: %s", *interval)
+	}
+	if *fee < 0 || *slippage < 0 {
+		return fmt.Errorf("手续费和滑点不能为负数")
+	}
+
+	// 回测只在本进程内分析，不读写存储和缓存
+	bt := *cfg
+	bt.KlineCache.Enabled = false
+	if err := utils.RegisterConfiguredExprRules(&bt); err != nil {
+		return err
+	}
+	if *rule != "" {
+		if _, ok := utils.GetTrendRule(*rule); !ok {
+			return fmt.Errorf("未注册的规则: %s", *rule)
+		}
+		bt.RuleBindings = map[string]string{*symbol + "_" + *interval: *rule}
+	}
+	config.Set(&bt)
+
+	klines, err := loadBacktestKlines(&bt, *file, *archive, *provider, *symbol, *interval)
+	if err != nil {
+		return err
+	}
+	if klines, err = filterKlineRange(klines, *from, *to); err != nil {
+		return err
+	}
+
+	report, err := utils.RunBacktest(context.Background(), klines, utils.BacktestOptions{
+		Symbol:     *symbol,
+		Interval:   *interval,
+		Fee:        *fee,
+		Slippage:   *slippage,
+		AllowShort: *short,
+		Horizon:    *horizon,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "text":
+		return report.WriteText(os.Stdout)
+	}
+	return fmt.Errorf("不支持的输出格式: %s", *format)
+}
+
+// loadBacktestKlines 从 CSV 文件、K线归档或K线缓存读取回测用的K线
+func loadBacktestKlines(cfg *config.Config, file, archive, provider, symbol, interval string) ([]utils.KlineData, error) {
+	if file != "" {
+		csv := &utils.CSVProvider{PathTemplate: file}
+		return csv.GetKlinesSince(context.Background(), symbol, interval, 0, math.MaxInt)
+	}
+	if archive != "" {
+		p := &utils.ArchiveProvider{Archive: utils.NewKlineArchive(archive)}
+		return p.GetKlinesSince(context.Background(), symbol, interval, 0, math.MaxInt)
+	}
+
+	if cfg.KlineCache.Dir == "" {
+		return nil, fmt.Errorf("请用 -file 指定K线文件、-archive 指定归档目录，或配置 kline_cache.dir 以读取缓存")
+	}
+	source := cfg.SourceFor(symbol)
+	if provider == "" {
+		provider = source.Provider
+	}
+	klines, err := utils.ReadCachedKlines(cfg.KlineCache.Dir, provider, source.Symbol, interval)
+	if err != nil {
+		return nil, fmt.Errorf("读取K线缓存失败: %v", err)
+	}
+	return klines, nil
+}
+
+// filterKlineRange 按开盘时间保留 [from, to] 内的K线，参数为空时不限制
+func filterKlineRange(klines []utils.KlineData, from, to string) ([]utils.KlineData, error) {
+	var fromMs, toMs int64 = 0, math.MaxInt64
+	if strings.TrimSpace(from) != "" {
+		t, err := utils.ParseTime(from)
+		if err != nil {
+			return nil, err
+		}
+		fromMs = t.UnixMilli()
+	}
+	if strings.TrimSpace(to) != "" {
+		t, err := utils.ParseTime(to)
+		if err != nil {
+			return nil, err
+		}
+		toMs = t.UnixMilli()
+	}
+
+	filtered := make([]utils.KlineData, 0, len(klines))
+	for _, k := range klines {
+		if k.OpenTime >= fromMs && k.OpenTime <= toMs {
+			filtered = append(filtered, k)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("%s ~ %s 内没有K线", from, to)
+	}
+	return filtered, nil
+}
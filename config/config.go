@@ -1,31 +1,314 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
 // Config 包含程序的配置参数
 type Config struct {
 	// API配置
-	APIBaseURL    string
-	KlineEndpoint string
+	APIBaseURL    string `json:"api_base_url" yaml:"api_base_url" toml:"api_base_url"`
+	KlineEndpoint string `json:"kline_endpoint" yaml:"kline_endpoint" toml:"kline_endpoint"`
 
 	// 监控的交易对
-	Symbols []string
+	Symbols []string `json:"symbols" yaml:"symbols" toml:"symbols"`
 
 	// 监控的时间周期
-	Intervals []string
+	Intervals []string `json:"intervals" yaml:"intervals" toml:"intervals"`
 
-	//代理
-	ProxyURL string
+	//代理，留空则使用环境变量中的代理设置；地址中的用户名和密码不会出现在输出和日志中
+	ProxyURL string `json:"proxy_url" yaml:"proxy_url" toml:"proxy_url" redact:"userinfo"`
 
 	// 技术指标参数
-	EMA25Period  int
-	EMA50Period  int
-	EMA120Period int
+	EMA25Period  int `json:"ema25_period" yaml:"ema25_period" toml:"ema25_period"`
+	EMA50Period  int `json:"ema50_period" yaml:"ema50_period" toml:"ema50_period"`
+	EMA120Period int `json:"ema120_period" yaml:"ema120_period" toml:"ema120_period"`
 
-	// 监控频率（分钟）
-	MonitorInterval int
+	// 监控频率（分钟），各周期按K线收盘时间调度，此项只作为兜底心跳
+	MonitorInterval int `json:"monitor_interval" yaml:"monitor_interval" toml:"monitor_interval"`
 
 	// API服务器配置
-	EnableAPIServer bool
-	APIServerPort   int
+	EnableAPIServer bool `json:"enable_api_server" yaml:"enable_api_server" toml:"enable_api_server"`
+	APIServerPort   int  `json:"api_server_port" yaml:"api_server_port" toml:"api_server_port"`
+
+	// 趋势规则绑定：key 为 "interval" 或 "SYMBOL_interval"，value 为规则名
+	RuleBindings map[string]string `json:"rule_bindings" yaml:"rule_bindings" toml:"rule_bindings"`
+	// 未绑定时使用的规则
+	DefaultRule string `json:"default_rule" yaml:"default_rule" toml:"default_rule"`
+	// 规则下标的写法：closed 以最后一根为最近收盘的K线，live 以最后一根为未收盘K线，
+	// 确认状态求值时下标后移一根。key 为规则名，未配置时内置规则为 live，其他规则为 closed
+	RuleCandleModes map[string]string `json:"rule_candle_modes" yaml:"rule_candle_modes" toml:"rule_candle_modes"`
+
+	// 表达式规则集，注册后可在 RuleBindings 中按名称绑定
+	ExprRules []ExprRuleSet `json:"expr_rules" yaml:"expr_rules" toml:"expr_rules"`
+	// 表达式规则文件（JSON），其中的规则集与 ExprRules 合并
+	ExprRuleFile string `json:"expr_rule_file" yaml:"expr_rule_file" toml:"expr_rule_file"`
+
+	// 存储配置
+	Storage StorageConfig `json:"storage" yaml:"storage" toml:"storage"`
+
+	// 数据库配置（storage.driver 为 mysql 时使用）
+	Database DatabaseConfig `json:"database" yaml:"database" toml:"database"`
+
+	// 实时K线推送配置
+	Stream StreamConfig `json:"stream" yaml:"stream" toml:"stream"`
+
+	// 本地K线缓存配置
+	KlineCache KlineCacheConfig `json:"kline_cache" yaml:"kline_cache" toml:"kline_cache"`
+
+	// 请求限速配置
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+
+	// 交易所时间同步配置
+	TimeSync TimeSyncConfig `json:"time_sync" yaml:"time_sync" toml:"time_sync"`
+
+	// 并发分析配置
+	Concurrency ConcurrencyConfig `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+
+	// 多周期共振评分配置
+	Confluence ConfluenceConfig `json:"confluence" yaml:"confluence" toml:"confluence"`
+
+	// 告警配置
+	Alerts AlertsConfig `json:"alerts" yaml:"alerts" toml:"alerts"`
+
+	// 未单独配置行情来源的币种使用的K线提供方
+	DefaultProvider string `json:"default_provider" yaml:"default_provider" toml:"default_provider"`
+	// 按币种配置行情来源，key 为 symbols 中的币种
+	KlineSources map[string]KlineSource `json:"kline_sources" yaml:"kline_sources" toml:"kline_sources"`
+}
+
+// ConcurrencyConfig 并发分析。实际并发数还受 rate_limit.weight_budget 限制，
+// 同时进行的请求权重之和不会超过每分钟的预算
+type ConcurrencyConfig struct {
+	// 同时分析的币种周期数
+	Workers int `json:"workers" yaml:"workers" toml:"workers"`
+	// 单个币种周期的超时时间（秒），包括获取K线和重试
+	JobTimeout int `json:"job_timeout" yaml:"job_timeout" toml:"job_timeout"`
+}
+
+// ConfluenceConfig 多周期共振评分：按权重汇总各周期趋势的方向，得出每个币种的综合得分和标签
+type ConfluenceConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// 各周期的权重，未配置的周期权重为 1，设为 0 时不参与评分
+	Weights map[string]float64 `json:"weights" yaml:"weights" toml:"weights"`
+	// 得分绝对值不低于该值时为 BULL / BEAR，否则为 MIXED
+	Threshold float64 `json:"threshold" yaml:"threshold" toml:"threshold"`
+	// 得分绝对值不低于该值时为 STRONG_BULL / STRONG_BEAR
+	StrongThreshold float64 `json:"strong_threshold" yaml:"strong_threshold" toml:"strong_threshold"`
+	// 高周期否决：这些周期的方向与得分相反时，标签降为 MIXED
+	VetoIntervals []string `json:"veto_intervals" yaml:"veto_intervals" toml:"veto_intervals"`
+}
+
+// Weight 返回周期的权重，未配置时为 1
+func (c ConfluenceConfig) Weight(interval string) float64 {
+	if w, ok := c.Weights[interval]; ok {
+		return w
+	}
+	return 1
+}
+
+// 告警的事件类型
+const (
+	AlertEventTrend      = "trend"
+	AlertEventConfluence = "confluence"
+)
+
+// AlertsConfig 告警：订阅状态变化和多周期评分标签变化，按规则通过通知渠道异步发送
+type AlertsConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// 通知渠道，key 为渠道名称，在规则中引用
+	Notifiers map[string]NotifierConfig `json:"notifiers" yaml:"notifiers" toml:"notifiers"`
+	// 告警规则，一个事件可以匹配多条规则
+	Rules []AlertRule `json:"rules" yaml:"rules" toml:"rules"`
+	// 发送失败后的最大重试次数
+	MaxRetries int `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+	// 首次重试前的等待时间（秒），之后每次加倍
+	RetryDelay int `json:"retry_delay" yaml:"retry_delay" toml:"retry_delay"`
+	// 同一事件在该时间（秒）内只向同一渠道发送一次
+	DedupeWindow int `json:"dedupe_window" yaml:"dedupe_window" toml:"dedupe_window"`
+	// 待发送队列的长度，队列满时丢弃新的告警
+	QueueSize int `json:"queue_size" yaml:"queue_size" toml:"queue_size"`
+	// 发送记录目录，按天写入 alerts_YYYYMMDD.log，留空不记录
+	LogDir string `json:"log_dir" yaml:"log_dir" toml:"log_dir"`
+}
+
+// AlertRule 告警规则，列表类条件为空时不限制
+type AlertRule struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// 事件类型：trend（状态变化，默认）或 confluence（多周期评分标签变化）
+	Event     string   `json:"event" yaml:"event" toml:"event"`
+	Symbols   []string `json:"symbols" yaml:"symbols" toml:"symbols"`
+	Intervals []string `json:"intervals" yaml:"intervals" toml:"intervals"`
+	// 旧状态和新状态；confluence 事件为标签，如 BULL、STRONG_BULL
+	From []string `json:"from" yaml:"from" toml:"from"`
+	To   []string `json:"to" yaml:"to" toml:"to"`
+	// 同一规则对同一币种周期两次告警的最短间隔（秒），0 不限制
+	Cooldown int `json:"cooldown" yaml:"cooldown" toml:"cooldown"`
+	// 免打扰时段（本地时间），如 "23:00-07:00"，期间匹配的告警不发送
+	QuietHours string `json:"quiet_hours" yaml:"quiet_hours" toml:"quiet_hours"`
+	// 发送的渠道名称
+	Notifiers []string `json:"notifiers" yaml:"notifiers" toml:"notifiers"`
+}
+
+// NotifierConfig 通知渠道
+type NotifierConfig struct {
+	// webhook / telegram / slack / discord / smtp / command
+	Type string `json:"type" yaml:"type" toml:"type"`
+	// webhook、slack、discord 的地址；telegram 的 Bot API 地址，留空为 https://api.telegram.org
+	URL string `json:"url" yaml:"url" toml:"url" redact:"true"`
+	// webhook 附加的请求头
+	Headers map[string]string `json:"headers" yaml:"headers" toml:"headers" redact:"true"`
+	// telegram
+	BotToken string `json:"bot_token" yaml:"bot_token" toml:"bot_token" redact:"true"`
+	ChatID   string `json:"chat_id" yaml:"chat_id" toml:"chat_id"`
+	// smtp，Username 为空时不认证
+	Host     string   `json:"host" yaml:"host" toml:"host"`
+	Port     int      `json:"port" yaml:"port" toml:"port"`
+	Username string   `json:"username" yaml:"username" toml:"username"`
+	Password string   `json:"password" yaml:"password" toml:"password" redact:"true"`
+	From     string   `json:"from" yaml:"from" toml:"from"`
+	To       []string `json:"to" yaml:"to" toml:"to"`
+	// command：告警的 JSON 从标准输入传入，摘要在环境变量 CTM_ALERT_* 中
+	Command string   `json:"command" yaml:"command" toml:"command"`
+	Args    []string `json:"args" yaml:"args" toml:"args"`
+	// 单次发送的超时时间（秒），默认 10
+	Timeout int `json:"timeout" yaml:"timeout" toml:"timeout"`
+	// HTTP 类渠道是否通过 proxy_url 发送，默认直连（仍遵循 HTTP_PROXY 等环境变量）
+	UseProxy bool `json:"use_proxy" yaml:"use_proxy" toml:"use_proxy"`
+}
+
+// ParseQuietHours 解析免打扰时段 "HH:MM-HH:MM"，返回起止时间距零点的分钟数，结束早于开始时表示跨过零点
+func ParseQuietHours(s string) (int, int, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("格式应为 HH:MM-HH:MM")
+	}
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return 0, 0, fmt.Errorf("时间 %q 无效", part)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("开始和结束时间不能相同")
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// TimeSyncConfig 与币安服务器时间同步，判断K线是否收盘和调度都以交易所时间为准
+type TimeSyncConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// 服务器时间接口，拼接在 api_base_url 之后
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+	// 同步间隔（秒）
+	Interval int `json:"interval" yaml:"interval" toml:"interval"`
+	// 本机时钟偏差超过该值（毫秒）时告警
+	MaxSkew int `json:"max_skew" yaml:"max_skew" toml:"max_skew"`
+}
+
+// KlineSource 币种的K线来源
+type KlineSource struct {
+	// 提供方：binance_futures / binance_spot / binance_coinm / okx / bybit / csv / archive，留空使用 default_provider
+	Provider string `json:"provider" yaml:"provider" toml:"provider"`
+	// 交易所中的交易对，如 BTC-USDT-SWAP，留空与币种相同
+	Symbol string `json:"symbol" yaml:"symbol" toml:"symbol"`
+	// 交易所 API 地址，留空使用默认地址
+	BaseURL string `json:"base_url" yaml:"base_url" toml:"base_url"`
+	// csv 文件路径，可包含 {symbol} 和 {interval}；archive 为归档目录
+	Path string `json:"path" yaml:"path" toml:"path"`
+}
+
+// RateLimitConfig 币安请求权重限速。权重按 IP 统计，多个实例共用代理时
+// 以响应头 X-MBX-USED-WEIGHT-1M 为准，预算应低于交易所的上限（合约为 2400/分钟）
+type RateLimitConfig struct {
+	// 每分钟最多使用的权重
+	WeightBudget int `json:"weight_budget" yaml:"weight_budget" toml:"weight_budget"`
+	// 网络错误、5xx 和 429 的最大重试次数
+	MaxRetries int `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+}
+
+// KlineCacheConfig 本地K线缓存。启用后每轮只增量获取上次之后的K线
+type KlineCacheConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// 持久化目录，留空只缓存在内存中
+	Dir string `json:"dir" yaml:"dir" toml:"dir"`
+}
+
+// StreamConfig WebSocket 实时K线配置。启用后在K线收盘推送到达时立即分析，
+// 取代按 monitor_interval 轮询
+type StreamConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// WebSocket 地址，订阅时拼接 /stream?streams=...
+	WSURL string `json:"ws_url" yaml:"ws_url" toml:"ws_url"`
+	// 每个币种周期在内存中保留的K线根数
+	BufferSize int `json:"buffer_size" yaml:"buffer_size" toml:"buffer_size"`
+	// 断线后的重连间隔（秒）
+	ReconnectDelay int `json:"reconnect_delay" yaml:"reconnect_delay" toml:"reconnect_delay"`
+}
+
+// StorageConfig 趋势结果存储配置
+type StorageConfig struct {
+	// mysql / sqlite / memory / none
+	Driver string `json:"driver" yaml:"driver" toml:"driver"`
+	// SQLite 数据库文件路径
+	SQLitePath string `json:"sqlite_path" yaml:"sqlite_path" toml:"sqlite_path"`
+	// 内存存储中每个币种周期保留的最大条数，<=0 不限制
+	MemoryLimit int `json:"memory_limit" yaml:"memory_limit" toml:"memory_limit"`
+}
+
+// DatabaseConfig 数据库连接配置。
+// DSN/DSNFile 优先；否则由 Host/Port/User/Password/Name 拼出 DSN。
+// 密码可放在 PasswordFile 指向的文件中（如 Docker/K8s secret）。
+type DatabaseConfig struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+	DSN          string `json:"dsn" yaml:"dsn" toml:"dsn" redact:"true"`
+	DSNFile      string `json:"dsn_file" yaml:"dsn_file" toml:"dsn_file"`
+	Host         string `json:"host" yaml:"host" toml:"host"`
+	Port         int    `json:"port" yaml:"port" toml:"port"`
+	User         string `json:"user" yaml:"user" toml:"user"`
+	Password     string `json:"password" yaml:"password" toml:"password" redact:"true"`
+	PasswordFile string `json:"password_file" yaml:"password_file" toml:"password_file"`
+	Name         string `json:"name" yaml:"name" toml:"name"`
+
+	// 连接池
+	MaxOpenConns    int `json:"max_open_conns" yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime int `json:"conn_max_lifetime" yaml:"conn_max_lifetime" toml:"conn_max_lifetime"` // 秒
+
+	// 连接失败或断开后的重试间隔（秒）
+	RetryInterval int `json:"retry_interval" yaml:"retry_interval" toml:"retry_interval"`
+}
+
+// ExprCase 表达式规则中的一条判断：When 成立时给出 Status
+type ExprCase struct {
+	Status string `json:"status" yaml:"status" toml:"status"`
+	When   string `json:"when" yaml:"when" toml:"when"`
+}
+
+// ExprRuleSet 表达式规则集，按顺序匹配 Cases，都不满足时返回 Default（默认 RANGE）
+type ExprRuleSet struct {
+	Name    string     `json:"name" yaml:"name" toml:"name"`
+	Default string     `json:"default" yaml:"default" toml:"default"`
+	Cases   []ExprCase `json:"cases" yaml:"cases" toml:"cases"`
+}
+
+// 规则使用的K线模式：closed 以最后一根为最近收盘的K线，live 以最后一根为未收盘K线
+const (
+	CandleModeClosed = "closed"
+	CandleModeLive   = "live"
+)
+
+// CandleMode 返回规则使用的K线模式，未配置时为 def
+func (c *Config) CandleMode(rule, def string) string {
+	if mode := c.RuleCandleModes[rule]; mode != "" {
+		return mode
+	}
+	return def
 }
 
 // DefaultConfig 返回默认配置
@@ -38,11 +321,139 @@ func DefaultConfig() *Config {
 		ProxyURL:        "http://127.0.0.1:10809",
 		EMA25Period:     25,
 		EMA50Period:     50,
-		MonitorInterval: 15, // 每15分钟
+		EMA120Period:    120,
+		MonitorInterval: 5, // 每5分钟
 		EnableAPIServer: true,
 		APIServerPort:   8080,
+		RuleBindings: map[string]string{
+			"15m": "macd_dif_xmid",
+			"1h":  "macd_dif",
+			"1d":  "macd_dif_xmid",
+			"3d":  "macd_dif",
+		},
+		DefaultRule: "macd_xstrong",
+		Storage: StorageConfig{
+			Driver:      "mysql",
+			SQLitePath:  "trend.db",
+			MemoryLimit: 10000,
+		},
+		Database: DatabaseConfig{
+			Enabled:         true,
+			Host:            "127.0.0.1",
+			Port:            3306,
+			User:            "root",
+			Name:            "trend_trade_mysql",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 300,
+			RetryInterval:   30,
+		},
+		Stream: StreamConfig{
+			WSURL:          "wss://fstream.binance.com",
+			BufferSize:     499,
+			ReconnectDelay: 5,
+		},
+		KlineCache: KlineCacheConfig{
+			Enabled: true,
+		},
+		RateLimit: RateLimitConfig{
+			WeightBudget: 1200,
+			MaxRetries:   5,
+		},
+		TimeSync: TimeSyncConfig{
+			Enabled:  true,
+			Endpoint: "/fapi/v1/time",
+			Interval: 300,
+			MaxSkew:  1000,
+		},
+		Concurrency: ConcurrencyConfig{
+			Workers:    8,
+			JobTimeout: 60,
+		},
+		Confluence: ConfluenceConfig{
+			Enabled: true,
+			Weights: map[string]float64{
+				"5m": 1, "15m": 1, "1h": 2, "4h": 3, "1d": 4, "3d": 5,
+			},
+			Threshold:       0.2,
+			StrongThreshold: 0.6,
+			VetoIntervals:   []string{"1d"},
+		},
+		Alerts: AlertsConfig{
+			MaxRetries:   3,
+			RetryDelay:   2,
+			DedupeWindow: 600,
+			QueueSize:    100,
+			LogDir:       "logs",
+		},
+		DefaultProvider: "binance_futures",
+	}
+}
+
+// SourceFor 返回币种的K线来源，未配置的字段使用默认值
+func (c *Config) SourceFor(symbol string) KlineSource {
+	source := c.KlineSources[symbol]
+	if source.Provider == "" {
+		source.Provider = c.DefaultProvider
+	}
+	if source.Symbol == "" {
+		source.Symbol = symbol
+	}
+	return source
+}
+
+// Redacted 返回隐藏了密码等敏感信息的副本，用于输出和日志
+func (c *Config) Redacted() *Config {
+	cp := *c
+	if cp.ProxyURL != "" {
+		cp.ProxyURL = RedactURL(cp.ProxyURL)
 	}
+	if cp.Database.Password != "" {
+		cp.Database.Password = "******"
+	}
+	if cp.Database.DSN != "" {
+		cp.Database.DSN = "******"
+	}
+	if len(cp.Alerts.Notifiers) > 0 {
+		notifiers := make(map[string]NotifierConfig, len(cp.Alerts.Notifiers))
+		for name, n := range cp.Alerts.Notifiers {
+			if n.BotToken != "" {
+				n.BotToken = "******"
+			}
+			if n.Password != "" {
+				n.Password = "******"
+			}
+			// webhook、Slack、Discord 的地址本身就是凭据；telegram 的 url 只是 API 地址
+			if n.URL != "" && n.Type != "telegram" {
+				n.URL = "******"
+			}
+			if len(n.Headers) > 0 {
+				headers := make(map[string]string, len(n.Headers))
+				for k := range n.Headers {
+					headers[k] = "******"
+				}
+				n.Headers = headers
+			}
+			notifiers[name] = n
+		}
+		cp.Alerts.Notifiers = notifiers
+	}
+	return &cp
 }
 
-// 全局配置实例
-var GlobalConfig = DefaultConfig()
+// 当前生效的全局配置，热加载时整体原子替换
+var current atomic.Pointer[Config]
+
+func init() {
+	current.Store(DefaultConfig())
+}
+
+// Get 返回当前生效的配置。返回值视为只读，需要修改时请复制后调用 Set
+func Get() *Config {
+	return current.Load()
+}
+
+// Set 原子替换当前配置
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
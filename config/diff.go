@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff 比较两份配置，返回发生变化的字段描述，如 "symbols: [BTCUSDT] -> [BTCUSDT SOLUSDT]"。
+// 比较原始值，因此只修改密码等敏感字段也会被检测到；带 redact:"true" 标签的字段只输出字段名，不输出值，
+// 带 redact:"userinfo" 标签的地址去掉用户名和密码后输出
+func Diff(old, new *Config) []string {
+	return diffStruct("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+}
+
+// diffStruct 逐字段比较结构体，嵌套结构体以 "a.b" 的形式展开
+func diffStruct(prefix string, ov, nv reflect.Value) []string {
+	var changes []string
+
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		a := ov.Field(i).Interface()
+		b := nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		name = prefix + name
+
+		switch field.Tag.Get("redact") {
+		case "true":
+			changes = append(changes, name+": 已修改（敏感信息不显示）")
+			continue
+		case "userinfo":
+			// 地址中的用户名和密码不输出，只修改了它们时不显示值
+			ra, rb := RedactURL(a.(string)), RedactURL(b.(string))
+			if ra == rb {
+				changes = append(changes, name+": 已修改（敏感信息不显示）")
+			} else {
+				changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, ra, rb))
+			}
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			changes = append(changes, diffStruct(name+".", ov.Field(i), nv.Field(i))...)
+		case reflect.Map:
+			changes = append(changes, diffMap(name, ov.Field(i), nv.Field(i))...)
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, a, b))
+		}
+	}
+	return changes
+}
+
+// diffMap 逐键比较 map 字段。值为结构体时逐字段展开，新增和删除只输出键，避免带出敏感字段
+func diffMap(name string, a, b reflect.Value) []string {
+	var changes []string
+	isStruct := a.Type().Elem().Kind() == reflect.Struct
+	for _, key := range a.MapKeys() {
+		av, bv := a.MapIndex(key), b.MapIndex(key)
+		switch {
+		case !bv.IsValid() && isStruct:
+			changes = append(changes, fmt.Sprintf("%s[%v]: 删除", name, key))
+		case !bv.IsValid():
+			changes = append(changes, fmt.Sprintf("%s[%v]: 删除 %v", name, key, av))
+		case reflect.DeepEqual(av.Interface(), bv.Interface()):
+		case isStruct:
+			changes = append(changes, diffStruct(fmt.Sprintf("%s[%v].", name, key), av, bv)...)
+		default:
+			changes = append(changes, fmt.Sprintf("%s[%v]: %v -> %v", name, key, av, bv))
+		}
+	}
+	for _, key := range b.MapKeys() {
+		if a.MapIndex(key).IsValid() {
+			continue
+		}
+		if isStruct {
+			changes = append(changes, fmt.Sprintf("%s[%v]: 新增", name, key))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s[%v]: 新增 %v", name, key, b.MapIndex(key)))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 环境变量覆盖的前缀，如 CTM_SYMBOLS=BTCUSDT,SOLUSDT
+const EnvPrefix = "CTM_"
+
+// Load 加载配置：默认值 -> 配置文件（可选）-> 环境变量，最后做校验。
+// path 为空时只使用默认值和环境变量。
+func Load(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		// 配置文件中的 rule_bindings 整体替换默认绑定，而不是合并
+		defaultBindings := cfg.RuleBindings
+		cfg.RuleBindings = nil
+		if err := decodeFile(path, cfg); err != nil {
+			return nil, err
+		}
+		if cfg.RuleBindings == nil {
+			cfg.RuleBindings = defaultBindings
+		}
+	}
+
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeFile 按扩展名解析配置文件，未知字段视为错误
+func decodeFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return fmt.Errorf("解析配置文件 %s 失败: 未知字段 %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("不支持的配置文件格式: %s（支持 .yaml/.yml/.json/.toml）", path)
+	}
+	return nil
+}
+
+// envBinding 环境变量与配置字段的对应关系
+type envBinding struct {
+	name string
+	set  func(cfg *Config, value string) error
+}
+
+var envBindings = []envBinding{
+	{"API_BASE_URL", func(c *Config, v string) error { c.APIBaseURL = v; return nil }},
+	{"KLINE_ENDPOINT", func(c *Config, v string) error { c.KlineEndpoint = v; return nil }},
+	{"SYMBOLS", func(c *Config, v string) error { c.Symbols = splitList(v); return nil }},
+	{"INTERVALS", func(c *Config, v string) error { c.Intervals = splitList(v); return nil }},
+	{"PROXY_URL", func(c *Config, v string) error { c.ProxyURL = v; return nil }},
+	{"EMA25_PERIOD", intSetter(func(c *Config) *int { return &c.EMA25Period })},
+	{"EMA50_PERIOD", intSetter(func(c *Config) *int { return &c.EMA50Period })},
+	{"EMA120_PERIOD", intSetter(func(c *Config) *int { return &c.EMA120Period })},
+	{"MONITOR_INTERVAL", intSetter(func(c *Config) *int { return &c.MonitorInterval })},
+	{"ENABLE_API_SERVER", boolSetter(func(c *Config) *bool { return &c.EnableAPIServer })},
+	{"API_SERVER_PORT", intSetter(func(c *Config) *int { return &c.APIServerPort })},
+	{"DEFAULT_RULE", func(c *Config, v string) error { c.DefaultRule = v; return nil }},
+	{"EXPR_RULE_FILE", func(c *Config, v string) error { c.ExprRuleFile = v; return nil }},
+	{"STORAGE_DRIVER", func(c *Config, v string) error { c.Storage.Driver = v; return nil }},
+	{"SQLITE_PATH", func(c *Config, v string) error { c.Storage.SQLitePath = v; return nil }},
+	{"DB_ENABLED", boolSetter(func(c *Config) *bool { return &c.Database.Enabled })},
+	{"DB_DSN", func(c *Config, v string) error { c.Database.DSN = v; return nil }},
+	{"DB_DSN_FILE", func(c *Config, v string) error { c.Database.DSNFile = v; return nil }},
+	{"DB_HOST", func(c *Config, v string) error { c.Database.Host = v; return nil }},
+	{"DB_PORT", intSetter(func(c *Config) *int { return &c.Database.Port })},
+	{"DB_USER", func(c *Config, v string) error { c.Database.User = v; return nil }},
+	{"DB_PASSWORD", func(c *Config, v string) error { c.Database.Password = v; return nil }},
+	{"DB_PASSWORD_FILE", func(c *Config, v string) error { c.Database.PasswordFile = v; return nil }},
+	{"DB_NAME", func(c *Config, v string) error { c.Database.Name = v; return nil }},
+	{"STREAM_ENABLED", boolSetter(func(c *Config) *bool { return &c.Stream.Enabled })},
+	{"STREAM_WS_URL", func(c *Config, v string) error { c.Stream.WSURL = v; return nil }},
+	{"KLINE_CACHE_ENABLED", boolSetter(func(c *Config) *bool { return &c.KlineCache.Enabled })},
+	{"KLINE_CACHE_DIR", func(c *Config, v string) error { c.KlineCache.Dir = v; return nil }},
+	{"WEIGHT_BUDGET", intSetter(func(c *Config) *int { return &c.RateLimit.WeightBudget })},
+	{"MAX_RETRIES", intSetter(func(c *Config) *int { return &c.RateLimit.MaxRetries })},
+	{"TIME_SYNC_ENABLED", boolSetter(func(c *Config) *bool { return &c.TimeSync.Enabled })},
+	{"WORKERS", intSetter(func(c *Config) *int { return &c.Concurrency.Workers })},
+	{"JOB_TIMEOUT", intSetter(func(c *Config) *int { return &c.Concurrency.JobTimeout })},
+	{"CONFLUENCE_ENABLED", boolSetter(func(c *Config) *bool { return &c.Confluence.Enabled })},
+	{"ALERTS_ENABLED", boolSetter(func(c *Config) *bool { return &c.Alerts.Enabled })},
+	{"DEFAULT_PROVIDER", func(c *Config, v string) error { c.DefaultProvider = v; return nil }},
+}
+
+// applyEnv 用环境变量覆盖配置
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	for _, b := range envBindings {
+		value, ok := lookup(EnvPrefix + b.name)
+		if !ok {
+			continue
+		}
+		if err := b.set(cfg, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("环境变量 %s%s 无效: %v", EnvPrefix, b.name, err)
+		}
+	}
+	return nil
+}
+
+// EnvNames 返回支持的环境变量名（已排序）
+func EnvNames() []string {
+	names := make([]string, len(envBindings))
+	for i, b := range envBindings {
+		names[i] = EnvPrefix + b.name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("需要整数，实际为 %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolSetter(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("需要布尔值，实际为 %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Dump 以 YAML 格式输出配置，敏感信息已隐藏
+func (c *Config) Dump() (string, error) {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return "", fmt.Errorf("序列化配置失败: %v", err)
+	}
+	return string(data), nil
+}
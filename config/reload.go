@@ -0,0 +1,148 @@
+package config
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reloader 配置热加载：收到 SIGHUP 或配置文件修改时间变化时重新加载，
+// 先由 prepare 按新配置准备好规则、K线来源和渠道，全部成功后与配置一起替换再通知订阅者，
+// 任何一步失败都保留旧配置。
+type Reloader struct {
+	path         string
+	pollInterval time.Duration
+
+	mu sync.Mutex
+	// 最近一次尝试加载时的文件时间戳，无论成功与否都会更新，避免同一次错误的修改被反复加载
+	stamp string
+	// 最近一次成功生效的配置对应的文件时间戳，用于判断表达式规则文件是否更新
+	applied  string
+	prepare  func(*Config) (func(), error)
+	onChange []func(old, new *Config)
+}
+
+// NewReloader 创建热加载器，path 为空时只在 SIGHUP 时重新读取环境变量
+func NewReloader(path string) *Reloader {
+	r := &Reloader{
+		path:         path,
+		pollInterval: 5 * time.Second,
+	}
+	r.stamp = r.fileStamp(Get())
+	r.applied = r.stamp
+	return r
+}
+
+// SetPrepare 设置新配置的准备步骤：校验并构建依赖配置的组件（规则、K线来源、告警渠道等），
+// 返回的 apply 函数在发布新配置前调用，使分析时看到的配置与这些组件始终一致
+func (r *Reloader) SetPrepare(prepare func(*Config) (apply func(), err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prepare = prepare
+}
+
+// OnChange 注册配置变更回调，回调在新配置生效后按注册顺序执行，用于重新调度等后续动作
+func (r *Reloader) OnChange(fn func(old, new *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, fn)
+}
+
+// Reload 立即重新加载配置，返回是否发生了变化。
+// 加载或校验失败时也记录文件时间戳，下一次文件变化或 SIGHUP 时再重试
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 与 Watch 的比较方式一致：按当前生效配置中的文件计算
+	r.stamp = r.fileStamp(Get())
+
+	cfg, err := Load(r.path)
+	if err != nil {
+		return false, err
+	}
+	var apply func()
+	if r.prepare != nil {
+		if apply, err = r.prepare(cfg); err != nil {
+			return false, err
+		}
+	}
+
+	old := Get()
+	// 在原始配置上比较，只轮换密码等敏感信息也要生效；Diff 不输出敏感字段的值
+	changes := Diff(old, cfg)
+	stamp := r.fileStamp(cfg)
+	if stamp != r.applied && cfg.ExprRuleFile != "" {
+		changes = append(changes, "expr_rule_file 内容已更新")
+	}
+	r.stamp, r.applied = stamp, stamp
+	if len(changes) == 0 {
+		log.Println("[Config] 配置未发生变化")
+		return false, nil
+	}
+
+	if apply != nil {
+		apply()
+	}
+	Set(cfg)
+	log.Printf("[Config] 配置已重新加载，共 %d 项变化:", len(changes))
+	for _, change := range changes {
+		log.Printf("[Config]   %s", change)
+	}
+
+	for _, fn := range r.onChange {
+		fn(old, cfg)
+	}
+	return true, nil
+}
+
+// Watch 监听 SIGHUP 和配置文件变化，直到 stop 关闭
+func (r *Reloader) Watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Println("[Config] 收到 SIGHUP，重新加载配置")
+			r.reloadAndLog()
+		case <-ticker.C:
+			r.mu.Lock()
+			changed := r.fileStamp(Get()) != r.stamp
+			r.mu.Unlock()
+			if changed {
+				log.Println("[Config] 检测到配置文件变化，重新加载")
+				r.reloadAndLog()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Reloader) reloadAndLog() {
+	if _, err := r.Reload(); err != nil {
+		log.Printf("[Config] 重新加载失败，继续使用旧配置: %v", err)
+	}
+}
+
+// fileStamp 返回配置文件和表达式规则文件的修改时间组合，用于检测文件变化
+func (r *Reloader) fileStamp(cfg *Config) string {
+	var stamp string
+	for _, path := range []string{r.path, cfg.ExprRuleFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamp += path + "@" + info.ModTime().String() + ";"
+		}
+	}
+	return stamp
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile 写入配置文件并设置修改时间，保证每次写入的时间戳不同
+func writeConfigFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReloadFailureRecordsStamp 加载失败后记录时间戳，轮询不会反复加载同一次错误的修改
+func TestReloadFailureRecordsStamp(t *testing.T) {
+	old := Get()
+	t.Cleanup(func() { Set(old) })
+
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	base := time.Now().Add(-time.Hour)
+	writeConfigFile(t, path, "symbols: [BTCUSDT]\n", base)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(cfg)
+	r := NewReloader(path)
+
+	writeConfigFile(t, path, "symbols: [btc]\n", base.Add(time.Minute))
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("Reload of invalid config succeeded")
+	}
+	if r.fileStamp(Get()) != r.stamp {
+		t.Fatal("stamp not recorded after failed reload, poller would retry every tick")
+	}
+	if Get() != cfg {
+		t.Fatal("failed reload replaced the config")
+	}
+
+	writeConfigFile(t, path, "symbols: [BTCUSDT, ETHUSDT]\n", base.Add(2*time.Minute))
+	if r.fileStamp(Get()) == r.stamp {
+		t.Fatal("next real change not detected")
+	}
+	changed, err := r.Reload()
+	if err != nil || !changed {
+		t.Fatalf("Reload after fix = %v, %v", changed, err)
+	}
+	if got := Get().Symbols; len(got) != 2 {
+		t.Fatalf("symbols = %v", got)
+	}
+}
+
+// TestReloadSecretOnlyChange 只轮换数据库密码也要生效，且变化描述中不出现密码
+func TestReloadSecretOnlyChange(t *testing.T) {
+	old := Get()
+	t.Cleanup(func() { Set(old) })
+
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	base := time.Now().Add(-time.Hour)
+	writeConfigFile(t, path, "database:\n  password: old-secret\n", base)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(cfg)
+	r := NewReloader(path)
+
+	writeConfigFile(t, path, "database:\n  password: new-secret\n", base.Add(time.Minute))
+	next, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(cfg, next)
+	if len(changes) != 1 || !strings.HasPrefix(changes[0], "database.password:") {
+		t.Fatalf("changes = %q", changes)
+	}
+	if strings.Contains(changes[0], "secret") {
+		t.Fatalf("change leaks the password: %q", changes[0])
+	}
+
+	changed, err := r.Reload()
+	if err != nil || !changed {
+		t.Fatalf("Reload = %v, %v", changed, err)
+	}
+	if got := Get().Database.Password; got != "new-secret" {
+		t.Fatalf("password = %q", got)
+	}
+}
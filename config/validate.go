@@ -0,0 +1,349 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KnownIntervals 币安支持的K线周期
+var KnownIntervals = []string{
+	"1m", "3m", "5m", "15m", "30m",
+	"1h", "2h", "4h", "6h", "8h", "12h",
+	"1d", "3d", "1w", "1M",
+}
+
+// IsKnownInterval 判断是否为币安支持的K线周期
+func IsKnownInterval(interval string) bool {
+	for _, known := range KnownIntervals {
+		if interval == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationError 配置校验错误，包含所有不合法的字段
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("配置校验失败:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate 校验配置，返回 *ValidationError 列出所有问题
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if err := checkURL(c.APIBaseURL, "http", "https"); err != nil {
+		addf("api_base_url %q 无效: %v", c.APIBaseURL, err)
+	}
+	if !strings.HasPrefix(c.KlineEndpoint, "/") {
+		addf("kline_endpoint %q 必须以 / 开头", c.KlineEndpoint)
+	}
+
+	if len(c.Symbols) == 0 {
+		addf("symbols 不能为空")
+	}
+	seenSymbols := make(map[string]bool)
+	for _, symbol := range c.Symbols {
+		if symbol == "" || strings.ToUpper(symbol) != symbol || strings.ContainsAny(symbol, " _/") {
+			addf("symbols 中的 %q 无效，应为大写交易对，如 BTCUSDT", symbol)
+		}
+		if seenSymbols[symbol] {
+			addf("symbols 中 %q 重复", symbol)
+		}
+		seenSymbols[symbol] = true
+	}
+
+	if len(c.Intervals) == 0 {
+		addf("intervals 不能为空")
+	}
+	seenIntervals := make(map[string]bool)
+	for _, interval := range c.Intervals {
+		if !IsKnownInterval(interval) {
+			addf("intervals 中的 %q 不是支持的周期（可选: %s）", interval, strings.Join(KnownIntervals, ", "))
+		}
+		if seenIntervals[interval] {
+			addf("intervals 中 %q 重复", interval)
+		}
+		seenIntervals[interval] = true
+	}
+
+	if c.ProxyURL != "" {
+		if err := checkURL(c.ProxyURL, "http", "https", "socks5"); err != nil {
+			addf("proxy_url %q 无效: %v", RedactURL(c.ProxyURL), err)
+		}
+	}
+
+	if c.EMA25Period <= 0 {
+		addf("ema25_period 必须为正数，实际为 %d", c.EMA25Period)
+	}
+	if c.EMA50Period <= 0 {
+		addf("ema50_period 必须为正数，实际为 %d", c.EMA50Period)
+	}
+	if c.EMA120Period <= 0 {
+		addf("ema120_period 必须为正数，实际为 %d", c.EMA120Period)
+	}
+	if c.MonitorInterval <= 0 {
+		addf("monitor_interval 必须为正数（分钟），实际为 %d", c.MonitorInterval)
+	}
+
+	if c.APIServerPort <= 0 || c.APIServerPort > 65535 {
+		addf("api_server_port 必须在 1-65535 之间，实际为 %d", c.APIServerPort)
+	}
+
+	if c.DefaultRule == "" {
+		addf("default_rule 不能为空")
+	}
+	for key, rule := range c.RuleBindings {
+		interval := key
+		if i := strings.LastIndex(key, "_"); i >= 0 {
+			interval = key[i+1:]
+		}
+		if !IsKnownInterval(interval) {
+			addf("rule_bindings 的键 %q 无效，应为 interval 或 SYMBOL_interval", key)
+		}
+		if rule == "" {
+			addf("rule_bindings[%s] 未指定规则", key)
+		}
+	}
+
+	for rule, mode := range c.RuleCandleModes {
+		if mode != CandleModeClosed && mode != CandleModeLive {
+			addf("rule_candle_modes[%s] %q 无效（可选: closed, live）", rule, mode)
+		}
+	}
+
+	switch c.Storage.Driver {
+	case "mysql", "memory", "none":
+	case "sqlite":
+		if c.Storage.SQLitePath == "" {
+			addf("storage.sqlite_path 不能为空")
+		}
+	default:
+		addf("storage.driver %q 无效（可选: mysql, sqlite, memory, none）", c.Storage.Driver)
+	}
+
+	if db := c.Database; c.Storage.Driver == "mysql" && db.Enabled {
+		if db.DSN == "" && db.DSNFile == "" {
+			if db.Host == "" {
+				addf("database.host 不能为空")
+			}
+			if db.Port <= 0 || db.Port > 65535 {
+				addf("database.port 必须在 1-65535 之间，实际为 %d", db.Port)
+			}
+			if db.Name == "" {
+				addf("database.name 不能为空")
+			}
+		}
+		if db.MaxOpenConns < 0 || db.MaxIdleConns < 0 {
+			addf("database.max_open_conns / max_idle_conns 不能为负数")
+		}
+		if db.ConnMaxLifetime < 0 {
+			addf("database.conn_max_lifetime 不能为负数，实际为 %d", db.ConnMaxLifetime)
+		}
+		if db.RetryInterval <= 0 {
+			addf("database.retry_interval 必须为正数（秒），实际为 %d", db.RetryInterval)
+		}
+	}
+
+	if c.RateLimit.WeightBudget <= 0 {
+		addf("rate_limit.weight_budget 必须为正数，实际为 %d", c.RateLimit.WeightBudget)
+	}
+	if c.RateLimit.MaxRetries < 0 {
+		addf("rate_limit.max_retries 不能为负数，实际为 %d", c.RateLimit.MaxRetries)
+	}
+
+	if c.TimeSync.Enabled {
+		if !strings.HasPrefix(c.TimeSync.Endpoint, "/") {
+			addf("time_sync.endpoint %q 必须以 / 开头", c.TimeSync.Endpoint)
+		}
+		if c.TimeSync.Interval <= 0 {
+			addf("time_sync.interval 必须为正数（秒），实际为 %d", c.TimeSync.Interval)
+		}
+		if c.TimeSync.MaxSkew <= 0 {
+			addf("time_sync.max_skew 必须为正数（毫秒），实际为 %d", c.TimeSync.MaxSkew)
+		}
+	}
+
+	if c.Concurrency.Workers <= 0 {
+		addf("concurrency.workers 必须为正数，实际为 %d", c.Concurrency.Workers)
+	}
+	if c.Concurrency.JobTimeout <= 0 {
+		addf("concurrency.job_timeout 必须为正数（秒），实际为 %d", c.Concurrency.JobTimeout)
+	}
+
+	if c.Confluence.Enabled {
+		for interval, w := range c.Confluence.Weights {
+			if !IsKnownInterval(interval) {
+				addf("confluence.weights 中的周期 %q 无效", interval)
+			}
+			if w < 0 {
+				addf("confluence.weights[%s] 不能为负数，实际为 %v", interval, w)
+			}
+		}
+		for _, interval := range c.Confluence.VetoIntervals {
+			if !IsKnownInterval(interval) {
+				addf("confluence.veto_intervals 中的周期 %q 无效", interval)
+			}
+		}
+		if c.Confluence.Threshold <= 0 || c.Confluence.Threshold > c.Confluence.StrongThreshold || c.Confluence.StrongThreshold > 1 {
+			addf("confluence 需满足 0 < threshold <= strong_threshold <= 1，实际为 %v / %v",
+				c.Confluence.Threshold, c.Confluence.StrongThreshold)
+		}
+	}
+
+	if c.Alerts.Enabled {
+		c.validateAlerts(addf)
+	}
+
+	if c.DefaultProvider == "" {
+		addf("default_provider 不能为空")
+	}
+	for symbol, source := range c.KlineSources {
+		if !seenSymbols[symbol] {
+			addf("kline_sources 的键 %q 不在 symbols 中", symbol)
+		}
+		if source.BaseURL != "" {
+			if err := checkURL(source.BaseURL, "http", "https"); err != nil {
+				addf("kline_sources[%s].base_url %q 无效: %v", symbol, source.BaseURL, err)
+			}
+		}
+	}
+
+	if c.Stream.Enabled {
+		if err := checkURL(c.Stream.WSURL, "ws", "wss"); err != nil {
+			addf("stream.ws_url %q 无效: %v", c.Stream.WSURL, err)
+		}
+		if c.Stream.BufferSize <= 0 {
+			addf("stream.buffer_size 必须为正数，实际为 %d", c.Stream.BufferSize)
+		}
+		if c.Stream.ReconnectDelay <= 0 {
+			addf("stream.reconnect_delay 必须为正数（秒），实际为 %d", c.Stream.ReconnectDelay)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateAlerts 校验告警的渠道和规则，渠道类型是否存在由 utils.ValidateNotifiers 检查
+func (c *Config) validateAlerts(addf func(format string, args ...interface{})) {
+	a := c.Alerts
+	if a.MaxRetries < 0 {
+		addf("alerts.max_retries 不能为负数，实际为 %d", a.MaxRetries)
+	}
+	if a.RetryDelay <= 0 {
+		addf("alerts.retry_delay 必须为正数（秒），实际为 %d", a.RetryDelay)
+	}
+	if a.DedupeWindow < 0 {
+		addf("alerts.dedupe_window 不能为负数（秒），实际为 %d", a.DedupeWindow)
+	}
+	if a.QueueSize <= 0 {
+		addf("alerts.queue_size 必须为正数，实际为 %d", a.QueueSize)
+	}
+
+	for name, n := range a.Notifiers {
+		switch n.Type {
+		case "webhook", "slack", "discord":
+			// 地址本身就是凭据，错误中不输出
+			if err := checkURL(n.URL, "http", "https"); err != nil {
+				addf("alerts.notifiers[%s].url 无效: %v", name, err)
+			}
+		case "telegram":
+			if n.BotToken == "" || n.ChatID == "" {
+				addf("alerts.notifiers[%s] 缺少 bot_token 或 chat_id", name)
+			}
+			if n.URL != "" {
+				if err := checkURL(n.URL, "http", "https"); err != nil {
+					addf("alerts.notifiers[%s].url %q 无效: %v", name, n.URL, err)
+				}
+			}
+		case "smtp":
+			if n.Host == "" || n.Port <= 0 || n.From == "" || len(n.To) == 0 {
+				addf("alerts.notifiers[%s] 缺少 host、port、from 或 to", name)
+			}
+		case "command":
+			if n.Command == "" {
+				addf("alerts.notifiers[%s] 缺少 command", name)
+			}
+		}
+		if n.Timeout < 0 {
+			addf("alerts.notifiers[%s].timeout 不能为负数（秒），实际为 %d", name, n.Timeout)
+		}
+	}
+
+	for i, r := range a.Rules {
+		label := r.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		if r.Event != "" && r.Event != AlertEventTrend && r.Event != AlertEventConfluence {
+			addf("alerts.rules[%s].event 只能为 %s 或 %s，实际为 %q", label, AlertEventTrend, AlertEventConfluence, r.Event)
+		}
+		for _, interval := range r.Intervals {
+			if !IsKnownInterval(interval) {
+				addf("alerts.rules[%s].intervals 中的周期 %q 无效", label, interval)
+			}
+		}
+		if r.Cooldown < 0 {
+			addf("alerts.rules[%s].cooldown 不能为负数（秒），实际为 %d", label, r.Cooldown)
+		}
+		if r.QuietHours != "" {
+			if _, _, err := ParseQuietHours(r.QuietHours); err != nil {
+				addf("alerts.rules[%s].quiet_hours %q 无效: %v", label, r.QuietHours, err)
+			}
+		}
+		if len(r.Notifiers) == 0 {
+			addf("alerts.rules[%s].notifiers 不能为空", label)
+		}
+		for _, name := range r.Notifiers {
+			if _, ok := a.Notifiers[name]; !ok {
+				addf("alerts.rules[%s] 引用了未配置的渠道 %q", label, name)
+			}
+		}
+	}
+}
+
+// checkURL 校验 URL 的协议和主机
+func checkURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// *url.Error 中带有完整地址，由调用方决定是否输出
+		if ue, ok := err.(*url.Error); ok {
+			return ue.Err
+		}
+		return err
+	}
+	schemeOK := false
+	for _, s := range schemes {
+		if u.Scheme == s {
+			schemeOK = true
+			break
+		}
+	}
+	if !schemeOK {
+		return fmt.Errorf("协议必须为 %s", strings.Join(schemes, "/"))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("缺少主机")
+	}
+	return nil
+}
+
+// RedactURL 去掉地址中的用户名和密码，用于输出代理地址等可能带凭据的 URL；无法解析时整体隐藏
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "******"
+	}
+	u.User = nil
+	return u.Redacted()
+}
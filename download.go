@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"crypto_trend_monitor/utils"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runDownload 处理 download 子命令：把历史K线按月下载到本地归档，或只检查归档的连续性
+//
+//	download -from 2024-01-01 [-to 2024-06-30] [-symbols BTCUSDT,ETHUSDT] [-intervals 1h,4h] [-dir data/klines]
+//	download -verify [-from ...] [-to ...]
+func runDownload(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	symbols := fs.String("symbols", strings.Join(cfg.Symbols, ","), "币种，逗号分隔，默认为配置中的币种")
+	intervals := fs.String("intervals", strings.Join(cfg.Intervals, ","), "K线周期，逗号分隔，默认为配置中的周期")
+	from := fs.String("from", "", "开始时间（按开盘时间），如 2024-01-01；-verify 时可留空")
+	to := fs.String("to", "", "结束时间，留空下载到最近一根已收盘的K线")
+	dir := fs.String("dir", "data/klines", "归档目录")
+	provider := fs.String("provider", "", "K线提供方，默认为该币种配置的提供方")
+	verifyOnly := fs.Bool("verify", false, "只检查已有归档的连续性，不下载")
+	fs.Parse(args)
+
+	var fromTime, toTime time.Time
+	var err error
+	if *from != "" {
+		if fromTime, err = utils.ParseTime(*from); err != nil {
+			return err
+		}
+	} else if !*verifyOnly {
+		return fmt.Errorf("请用 -from 指定开始时间")
+	}
+	if *to != "" {
+		if toTime, err = utils.ParseTime(*to); err != nil {
+			return err
+		}
+	}
+
+	// 收到 SIGINT/SIGTERM 时保存当前月份已下载的数据后退出，再次执行会从断点继续
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	archive := utils.NewKlineArchive(*dir)
+	var gaps int
+	for _, symbol := range splitList(*symbols) {
+		for _, interval := range splitList(*intervals) {
+			if !config.IsKnownInterval(interval) {
+				return fmt.Errorf("不支持的周期: %s", interval)
+			}
+
+			var verify *utils.VerifyReport
+			if *verifyOnly {
+				end := toTime
+				if end.IsZero() {
+					end = time.Now()
+				}
+				if verify, err = utils.VerifyArchive(ctx, archive, symbol, interval, fromTime, end); err != nil {
+					return err
+				}
+			} else {
+				source := cfg.SourceFor(symbol)
+				if *provider != "" {
+					source.Provider = *provider
+				}
+				if source.Provider == "archive" {
+					return fmt.Errorf("%s 的K线来源为 archive，请用 -provider 指定交易所", symbol)
+				}
+				p, err := utils.NewKlineProvider(cfg, source)
+				if err != nil {
+					return fmt.Errorf("%s 的K线来源配置错误: %v", symbol, err)
+				}
+
+				log.Printf("[Download] 开始下载 %s %s（%s）", symbol, interval, p.Name())
+				report, err := utils.DownloadKlines(ctx, p, archive, utils.DownloadOptions{
+					Symbol:         symbol,
+					ExchangeSymbol: source.Symbol,
+					Interval:       interval,
+					From:           fromTime,
+					To:             toTime,
+				})
+				if err != nil {
+					if ctx.Err() != nil {
+						return fmt.Errorf("下载被中断，已保存的数据会在下次执行时跳过")
+					}
+					return err
+				}
+				log.Printf("[Download] %s %s 完成: %d 次请求，新增 %d 根，写入 %d 个月", symbol, interval,
+					report.Requests, report.Fetched, report.Months)
+				verify = report.Verify
+			}
+
+			printVerifyReport(symbol, interval, verify)
+			gaps += len(verify.Gaps)
+		}
+	}
+
+	if *verifyOnly && gaps > 0 {
+		return fmt.Errorf("归档中共有 %d 处缺口", gaps)
+	}
+	return nil
+}
+
+// printVerifyReport 输出连续性检查的结果
+func printVerifyReport(symbol, interval string, r *utils.VerifyReport) {
+	if r.Klines == 0 {
+		log.Printf("[Verify] %s %s: 归档中没有K线", symbol, interval)
+		return
+	}
+	log.Printf("[Verify] %s %s: %d 根，%s ~ %s，缺口 %d 处", symbol, interval, r.Klines,
+		r.First.UTC().Format("2006-01-02 15:04"), r.Last.UTC().Format("2006-01-02 15:04"), len(r.Gaps))
+	for _, gap := range r.Gaps {
+		log.Printf("⚠️ [Verify] %s %s 缺少 %s", symbol, interval, gap)
+	}
+}
+
+// splitList 拆分逗号分隔的参数，忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
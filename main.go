@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto_trend_monitor/config"
-	"crypto_trend_monitor/model"
 	"crypto_trend_monitor/utils"
-	"database/sql"
+	"flag"
+	"fmt"
 	"log"
-	"os"
 	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -18,26 +21,171 @@ const (
 	KlineEndpoint     = "/fapi/v1/klines"
 )
 
-var (
-	db *sql.DB
-)
-
 func main() {
+	configPath := flag.String("config", "", "配置文件路径（.yaml/.yml/.json/.toml），留空使用默认配置")
+	dumpConfig := flag.Bool("dump-config", false, "输出最终生效的配置（YAML）后退出")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	config.Set(cfg)
+
+	if *dumpConfig {
+		out, err := cfg.Dump()
+		if err != nil {
+			log.Fatalf("输出配置失败: %v", err)
+		}
+		fmt.Print(out)
+		return
+	}
+
+	// 子命令
+	switch flag.Arg(0) {
+	case "":
+	case "migrate":
+		if err := runMigrate(cfg, flag.Args()[1:]); err != nil {
+			log.Fatalf("迁移失败: %v", err)
+		}
+		return
+	case "download":
+		if err := runDownload(cfg, flag.Args()[1:]); err != nil {
+			log.Fatalf("下载失败: %v", err)
+		}
+		return
+	case "backtest":
+		if err := runBacktest(cfg, flag.Args()[1:]); err != nil {
+			log.Fatalf("回测失败: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("未知的子命令: %s", flag.Arg(0))
+	}
+
 	log.Println("开始运行币种趋势监控程序...")
 
+	// ✅ 收到 SIGINT/SIGTERM 时取消 ctx：进行中的请求和限速等待立即返回，随后依次关闭各组件
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
 	// 初始化输出管理器
 	output := utils.NewOutputManager()
 	if err := output.Init(); err != nil {
 		log.Fatalf("初始化输出管理器失败: %v", err)
 	}
 
-	// 创建趋势分析器
-	analyzer := utils.NewTrendAnalyzer()
+	// 注册配置中的表达式规则
+	if err := utils.ValidateRuleConfig(cfg); err != nil {
+		log.Fatalf("趋势规则配置错误: %v", err)
+	}
+	if err := utils.ValidateKlineSources(cfg); err != nil {
+		log.Fatalf("K线来源配置错误: %v", err)
+	}
+	if err := utils.RegisterConfiguredExprRules(cfg); err != nil {
+		log.Fatalf("加载表达式规则失败: %v", err)
+	}
+	if err := utils.ValidateNotifiers(cfg); err != nil {
+		log.Fatalf("告警配置错误: %v", err)
+	}
+
+	// 创建存储和趋势分析器
+	store, err := utils.OpenTrendStore(cfg)
+	if err != nil {
+		log.Fatalf("初始化存储失败: %v", err)
+	}
+	if sqlStore, ok := store.(*utils.SQLStore); ok {
+		// 启动时执行迁移；失败（包括数据库暂不可用）时按降级模式继续运行，在首次写入对应周期时再迁移
+		if err := sqlStore.Migrate(cfg.Intervals); err != nil {
+			log.Printf("⚠️ 数据库迁移失败，进入降级模式，首次写入时重试: %v", err)
+		}
+	}
+	analyzer := utils.NewTrendAnalyzer(store)
+
+	// ✅ 告警：状态变化和多周期评分标签变化按 alerts.rules 异步发送，不阻塞分析
+	alerts, err := utils.NewAlertEngine(cfg)
+	if err != nil {
+		log.Fatalf("初始化告警失败: %v", err)
+	}
+	alerts.Start()
+	analyzer.OnTrendChanged(func(ctx context.Context, event *utils.TrendChanged) {
+		log.Printf("[Trend] %s", event)
+		alerts.HandleTrendChanged(ctx, event)
+	})
+	analyzer.OnConfluenceChanged(func(ctx context.Context, event *utils.ConfluenceChanged) {
+		log.Printf("[Confluence] %s", event)
+		alerts.HandleConfluenceChanged(ctx, event)
+	})
+
+	// ✅ 与交易所时间同步，判断K线收盘和调度都以交易所时间为准
+	timeSync, err := utils.NewTimeSync()
+	if err != nil {
+		log.Fatalf("初始化时间同步失败: %v", err)
+	}
+	if cfg.TimeSync.Enabled {
+		if err := timeSync.Sync(); err != nil {
+			log.Printf("⚠️ [TimeSync] 首次同步失败，暂用本机时间: %v", err)
+		} else {
+			log.Printf("[TimeSync] 本机时钟偏差: %v", timeSync.Offset())
+		}
+		go timeSync.Run(time.Duration(cfg.TimeSync.Interval)*time.Second, ctx.Done())
+	}
+	analyzer.SetClock(timeSync.Now)
+
+	// ✅ 按各周期K线收盘时间调度，监控频率只作为兜底心跳
+	scheduler := utils.NewCandleScheduler(candleSettleDelay)
+	if err := scheduler.SetIntervals(cfg.Intervals, timeSync.Now()); err != nil {
+		log.Fatalf("初始化调度失败: %v", err)
+	}
+	rescheduled := make(chan struct{}, 1)
+
+	// ✅ 配置热加载：SIGHUP 或配置文件变化时生效，无需重启
+	reloader := config.NewReloader(*configPath)
+	reloader.SetPrepare(func(c *config.Config) (func(), error) {
+		applyAnalyzer, err := analyzer.PrepareConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		applyAlerts, err := alerts.PrepareConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			applyAnalyzer()
+			applyAlerts()
+		}, nil
+	})
+	reloader.OnChange(func(old, new *config.Config) {
+		if old.MonitorInterval != new.MonitorInterval || !slices.Equal(old.Intervals, new.Intervals) {
+			if err := scheduler.SetIntervals(new.Intervals, timeSync.Now()); err != nil {
+				log.Printf("[Scheduler] 更新调度周期失败: %v", err)
+			}
+			select {
+			case rescheduled <- struct{}{}:
+			default:
+			}
+		}
+		if old.ProxyURL != new.ProxyURL {
+			if err := timeSync.SetProxy(new.ProxyURL); err != nil {
+				log.Printf("[TimeSync] 更新代理失败: %v", err)
+			}
+		}
+		if old.EnableAPIServer != new.EnableAPIServer || old.APIServerPort != new.APIServerPort {
+			log.Println("[Config] API服务器的开关和端口需要重启后生效")
+		}
+		if old.Storage != new.Storage || old.Database != new.Database {
+			log.Println("[Config] 存储和数据库配置需要重启后生效")
+		}
+	})
+	go reloader.Watch(ctx.Done())
 
 	// 创建API服务器
 	var apiServer *utils.TrendAPI
-	if config.GlobalConfig.EnableAPIServer {
-		apiServer = utils.NewTrendAPI(config.GlobalConfig.APIServerPort, analyzer)
+	if cfg.EnableAPIServer {
+		apiServer = utils.NewTrendAPI(cfg.APIServerPort, analyzer)
+		if cfg.TimeSync.Enabled {
+			apiServer.SetTimeSync(timeSync)
+		}
 
 		go func() {
 			if err := apiServer.Start(); err != nil {
@@ -46,78 +194,169 @@ func main() {
 		}()
 	}
 
-	model.InitDB()
-	db = model.DB
+	if cfg.Stream.Enabled {
+		// ✅ 实时推送模式：K线收盘时立即分析，不再轮询
+		stream := utils.NewKlineStream(analyzer.FetchKlines, func(ctx context.Context, symbol, interval string, klines []utils.KlineData) {
+			// 推送的 x 标记已确认收盘，不再按本地时钟判断
+			result, err := analyzer.AnalyzeClosedKlines(ctx, symbol, interval, klines)
+			if err != nil {
+				output.LogError(fmt.Errorf("分析 %s %s 趋势失败: %v", symbol, interval, err))
+				return
+			}
+			results := []*utils.TrendResult{result}
+			confluence := analyzer.UpdateConfluence(ctx, results)
+			if err := output.LogTrendResults(results); err != nil {
+				output.LogError(err)
+			}
+			if err := output.LogConfluence(confluence); err != nil {
+				output.LogError(err)
+			}
+			if apiServer != nil {
+				apiServer.UpdateResults(results)
+				apiServer.UpdateConfluence(confluence)
+			}
+		})
+		reloader.OnChange(func(old, new *config.Config) {
+			if !slices.Equal(old.Symbols, new.Symbols) || !slices.Equal(old.Intervals, new.Intervals) ||
+				!reflect.DeepEqual(old.KlineSources, new.KlineSources) || old.DefaultProvider != new.DefaultProvider {
+				log.Println("[Stream] 币种或周期已变化，重新订阅")
+				stream.Reconnect()
+			}
+		})
 
-	// ✅ 首次立即执行
-	log.Printf("[TrendMonitor] 首次立即执行: %s", time.Now().Format("15:04:05"))
-	results := runAnalysis(analyzer, output)
-	if apiServer != nil && len(results) > 0 {
-		apiServer.UpdateResults(results)
+		// K线来源不是币安合约的币种无法订阅推送，仍按收盘时间调度
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runScheduler(ctx, scheduler, rescheduled, timeSync, analyzer, output, apiServer, utils.UnstreamedSymbols)
+		}()
+		stream.Run(ctx)
+		wg.Wait()
+	} else {
+		runScheduler(ctx, scheduler, rescheduled, timeSync, analyzer, output, apiServer,
+			func(c *config.Config) []string { return c.Symbols })
 	}
 
-	// ✅ 计算下一次 minute % 5 == 0 的时间
-	now := time.Now()
-	minutesToNext := 5 - (now.Minute() % 5)
-	if minutesToNext == 0 {
-		minutesToNext = 5
-	}
-	nextAligned := now.Truncate(time.Minute).Add(time.Duration(minutesToNext) * time.Minute)
-	delay := time.Until(nextAligned)
+	// 再次收到信号时不再等待，直接退出
+	stopSignals()
+	log.Println("接收到退出信号，程序正在退出...")
+	shutdown(apiServer, analyzer, alerts, store)
+	log.Println("程序已退出。")
+}
 
-	log.Printf("[TrendMonitor] 下一次对齐执行时间: %s（等待 %v）", nextAligned.Format("15:04:05"), delay)
+// shutdownTimeout 退出时等待API请求完成和写入暂存结果的最长时间
+const shutdownTimeout = 10 * time.Second
 
-	// ✅ 通道控制优雅退出
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// runScheduler 首次立即分析所有周期，之后每个周期只在自己的K线收盘后分析，直到 ctx 取消。
+// 最长每隔监控频率唤醒一次，重试失败的周期，并在系统休眠等导致计时器延误后及时补上。
+// symbols 按当前配置返回要分析的币种，为空时跳过本轮
+func runScheduler(ctx context.Context, scheduler *utils.CandleScheduler, rescheduled <-chan struct{},
+	timeSync *utils.TimeSync, analyzer *utils.TrendAnalyzer, output *utils.OutputManager, apiServer *utils.TrendAPI,
+	symbols func(*config.Config) []string) {
+	run := func(intervals []string) {
+		syms := symbols(config.Get())
+		if len(syms) == 0 {
+			return
+		}
+		report := runAnalysis(ctx, analyzer, output, syms, intervals)
+		if apiServer != nil && len(report.Results) > 0 {
+			apiServer.UpdateResults(report.Results)
+			apiServer.UpdateConfluence(report.Confluence)
+		}
+		for _, interval := range report.FailedIntervals() {
+			scheduler.MarkFailed(interval)
+		}
+	}
+	nextWait := func() time.Duration {
+		heartbeat := time.Duration(config.Get().MonitorInterval) * time.Minute
+		if next := scheduler.NextRun(); !next.IsZero() {
+			return min(max(next.Sub(timeSync.Now()), 0), heartbeat)
+		}
+		return heartbeat
+	}
 
-	// ✅ 启动延迟后执行一次 + 开始固定周期执行
-	go func() {
-		time.Sleep(delay)
+	// ✅ 首次立即执行
+	log.Printf("[TrendMonitor] 首次立即执行: %s", timeSync.Now().Format("15:04:05"))
+	run(config.Get().Intervals)
 
-		log.Printf("[TrendMonitor] 对齐执行: %s", time.Now().Format("15:04:05"))
-		results := runAnalysis(analyzer, output)
-		if apiServer != nil && len(results) > 0 {
-			apiServer.UpdateResults(results)
+	timer := time.NewTimer(nextWait())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if due := scheduler.Due(timeSync.Now()); len(due) > 0 {
+				log.Printf("[Scheduler] %s 执行周期: %v", timeSync.Now().Format("15:04:05"), due)
+				run(due)
+			}
+			timer.Reset(nextWait())
+		case <-rescheduled:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(nextWait())
+		case <-ctx.Done():
+			return
 		}
+	}
+}
 
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
+// shutdown 依次关闭API服务器、写入暂存的趋势结果、发送队列中的告警、关闭存储（含数据库连接），整体不超过 shutdownTimeout
+func shutdown(apiServer *utils.TrendAPI, analyzer *utils.TrendAnalyzer, alerts *utils.AlertEngine, store utils.TrendStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-		for {
-			select {
-			case <-ticker.C:
-				log.Printf("[TrendMonitor] 周期触发: %s", time.Now().Format("15:04:05"))
-				results := runAnalysis(analyzer, output)
-				if apiServer != nil && len(results) > 0 {
-					apiServer.UpdateResults(results)
-				}
-			case <-sigChan:
-				log.Println("接收到退出信号，程序正在退出...")
-				return
-			}
+	if apiServer != nil {
+		if err := apiServer.Shutdown(ctx); err != nil {
+			log.Printf("⚠️ %v", err)
 		}
-	}()
+	}
 
-	// 阻塞主协程，直到收到退出信号
-	<-sigChan
-	log.Println("程序已退出。")
+	if pending := analyzer.PendingWrites(); pending > 0 {
+		written, err := analyzer.FlushPending(ctx)
+		log.Printf("退出前写入暂存的趋势结果: %d/%d", written, pending)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+
+	if err := alerts.Close(ctx); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("关闭存储失败: %v", err)
+		}
+	}
 }
 
-// runAnalysis 运行一次趋势分析
-func runAnalysis(analyzer *utils.TrendAnalyzer, output *utils.OutputManager) []*utils.TrendResult {
+// candleSettleDelay K线收盘后等待交易所生成数据的时间
+const candleSettleDelay = 2 * time.Second
+
+// runAnalysis 运行一次指定币种和周期的趋势分析
+func runAnalysis(ctx context.Context, analyzer *utils.TrendAnalyzer, output *utils.OutputManager, symbols, intervals []string) *utils.AnalysisReport {
 	log.Println("开始执行趋势分析...")
-	time.Sleep(7 * time.Second) //等待当前K线出来
 
-	// 分析所有趋势
-	results := analyzer.AnalyzeAllTrends(db)
+	// 并发分析这些币种在这些周期的趋势
+	report := analyzer.AnalyzeSymbols(ctx, symbols, intervals)
 
-	// 记录结果
-	if err := output.LogTrendResults(results); err != nil {
+	// 记录结果和失败的任务
+	if err := output.LogTrendResults(report.Results); err != nil {
+		output.LogError(err)
+	}
+	if err := output.LogConfluence(report.Confluence); err != nil {
 		output.LogError(err)
 	}
+	if ctx.Err() != nil {
+		log.Printf("趋势分析被中断: %s", report.Summary())
+		return report
+	}
+	if err := report.Err(); err != nil {
+		output.LogError(fmt.Errorf("部分趋势分析失败:\n%v", err))
+	}
 
-	log.Println("趋势分析完成")
+	log.Printf("趋势分析完成: %s", report.Summary())
 
-	return results
+	return report
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto_trend_monitor/config"
+	"crypto_trend_monitor/utils"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runMigrate 处理 migrate 子命令：migrate up | down [-steps N] [-scope 1h,4h,_global] | status
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: migrate up | down [-steps N] [-scope 1h,4h,_global] | status")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "down 时每个表回滚的迁移数")
+	scope := fs.String("scope", "", "down 时回滚的周期，逗号分隔，默认为配置中的全部周期；全局表需明确写出 "+utils.GlobalMigrationScope)
+	fs.Parse(args[1:])
+
+	store, err := utils.OpenTrendStore(cfg)
+	if err != nil {
+		return err
+	}
+	sqlStore, ok := store.(*utils.SQLStore)
+	if !ok {
+		return fmt.Errorf("存储类型 %s 不需要迁移", cfg.Storage.Driver)
+	}
+	defer sqlStore.Close()
+
+	m, err := utils.NewMigrator(sqlStore)
+	if err != nil {
+		return fmt.Errorf("连接数据库失败: %v", err)
+	}
+
+	switch args[0] {
+	case "up":
+		return m.Up(cfg.Intervals)
+	case "down":
+		targets := cfg.Intervals
+		if *scope != "" {
+			targets = strings.Split(*scope, ",")
+			for i := range targets {
+				targets[i] = strings.TrimSpace(targets[i])
+			}
+		}
+		return m.Down(targets, *steps)
+	case "status":
+		statuses, err := m.Status(cfg.Intervals)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "未执行"
+			if s.Applied {
+				state = "已执行"
+			}
+			fmt.Fprintf(os.Stdout, "%-16s %04d_%-32s %s\n", s.Scope, s.Version, s.Name, state)
+		}
+		return nil
+	}
+	return fmt.Errorf("未知的 migrate 命令: %s", args[0])
+}
@@ -1,36 +1,176 @@
 package model
 
 import (
+	"context"
+	"crypto_trend_monitor/config"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-var DB *sql.DB
+// dialTimeout 建立连接的超时，pingTimeout 检查连接的超时：数据库主机无响应时不会阻塞启动和重连检查
+const (
+	dialTimeout = 5 * time.Second
+	pingTimeout = 10 * time.Second
+)
 
-func InitDB() {
-	// 连接配置
-	username := "root"
-	password := "Aa123456"
-	host := "127.0.0.1"
-	port := 3306
-	dbname := "trend_trade_mysql"
+var (
+	mu        sync.RWMutex
+	db        *sql.DB
+	available bool
+	stopOnce  sync.Once
+	stopCh    = make(chan struct{})
+)
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		username, password, host, port, dbname)
+// BuildDSN 根据配置生成 MySQL DSN。
+// 优先级：DSN > DSNFile > Host/Port/User/Password(File)/Name。
+// 按字段生成时带上建立连接的超时（timeout 参数），直接给出的 DSN 原样使用
+func BuildDSN(cfg config.DatabaseConfig) (string, error) {
+	if cfg.DSN != "" {
+		return cfg.DSN, nil
+	}
+	if cfg.DSNFile != "" {
+		dsn, err := readSecret(cfg.DSNFile)
+		if err != nil {
+			return "", fmt.Errorf("读取 DSN 文件失败: %v", err)
+		}
+		return dsn, nil
+	}
 
-	var err error
-	DB, err = sql.Open("mysql", dsn)
+	password := cfg.Password
+	if cfg.PasswordFile != "" {
+		secret, err := readSecret(cfg.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("读取数据库密码文件失败: %v", err)
+		}
+		password = secret
+	}
+
+	mc := mysql.NewConfig()
+	mc.User = cfg.User
+	mc.Passwd = password
+	mc.Net = "tcp"
+	mc.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	mc.DBName = cfg.Name
+	mc.ParseTime = true
+	mc.Loc = time.Local
+	mc.Timeout = dialTimeout
+	mc.Params = map[string]string{"charset": "utf8mb4"}
+	return mc.FormatDSN(), nil
+}
+
+// readSecret 读取密钥文件内容，去掉首尾空白
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("数据库连接失败: %v", err)
+		return "", err
 	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// InitDB 连接数据库。连接失败时不会退出程序，而是进入降级模式：
+// 持久化暂停，后台按 RetryInterval 重试直到数据库恢复。
+// 返回的错误仅用于提示，调用方可以继续运行。
+func InitDB(cfg config.DatabaseConfig) error {
+	dsn, err := BuildDSN(cfg)
+	if err != nil {
+		return err
+	}
+
+	conn, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("数据库配置错误: %v", err)
+	}
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	conn.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+
+	mu.Lock()
+	db = conn
+	mu.Unlock()
 
 	// 测试连接
-	if err = DB.Ping(); err != nil {
-		log.Fatalf("数据库 ping 失败: %v", err)
+	err = ping(conn)
+	setAvailable(err == nil)
+	if err == nil {
+		log.Println("✅ 成功连接 MySQL 数据库")
+	} else {
+		err = fmt.Errorf("数据库 ping 失败，进入降级模式（持久化暂停）: %v", err)
 	}
 
-	log.Println("✅ 成功连接 MySQL 数据库")
+	go keepAlive(conn, time.Duration(cfg.RetryInterval)*time.Second)
+	return err
+}
+
+// keepAlive 定期检查连接，断开时暂停持久化，恢复后自动重新启用
+func keepAlive(conn *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := ping(conn)
+			wasAvailable := Available()
+			setAvailable(err == nil)
+			if err == nil && !wasAvailable {
+				log.Println("✅ 数据库已恢复，重新启用持久化")
+			} else if err != nil && wasAvailable {
+				log.Printf("⚠️ 数据库连接断开，暂停持久化: %v", err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// ping 在 pingTimeout 内检查连接是否可用
+func ping(conn *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return conn.PingContext(ctx)
+}
+
+func setAvailable(ok bool) {
+	mu.Lock()
+	available = ok
+	mu.Unlock()
+}
+
+// Available 数据库当前是否可用
+func Available() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return available
+}
+
+// GetDB 返回可用的数据库连接，数据库未启用或不可用时返回 nil
+func GetDB() *sql.DB {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !available {
+		return nil
+	}
+	return db
+}
+
+// CloseDB 停止后台重试并关闭数据库连接
+func CloseDB() error {
+	stopOnce.Do(func() { close(stopCh) })
+
+	mu.Lock()
+	defer mu.Unlock()
+	available = false
+	if db == nil {
+		return nil
+	}
+	err := db.Close()
+	db = nil
+	return err
 }
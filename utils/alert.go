@@ -0,0 +1,421 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// alertWorkers 同时发送告警的 goroutine 数
+const alertWorkers = 2
+
+// Alert 按规则触发、待发送的告警
+type Alert struct {
+	Rule     string
+	Event    string // config.AlertEventTrend 或 config.AlertEventConfluence
+	Symbol   string
+	Interval string // 多周期评分为空
+	From     string
+	To       string
+	// 状态变化：新状态所在K线的收盘价和旧状态持续的时间
+	Price float64
+	Held  time.Duration
+	// 多周期评分：变化后的得分
+	Score float64
+	// 状态变化为新状态所在K线的开盘时间，多周期评分为评分时间
+	OpenTime time.Time
+	Time     time.Time
+}
+
+// Title 告警标题，如 "BTCUSDT 1h: RANGE -> BUYMACD"
+func (a *Alert) Title() string {
+	if a.Event == config.AlertEventConfluence {
+		return fmt.Sprintf("%s 多周期评分: %s -> %s", a.Symbol, a.From, a.To)
+	}
+	return fmt.Sprintf("%s %s: %s -> %s", a.Symbol, a.Interval, a.From, a.To)
+}
+
+// Text 告警正文，包含标题和详情
+func (a *Alert) Text() string {
+	if a.Event == config.AlertEventConfluence {
+		return fmt.Sprintf("%s（得分 %+.2f，%s，规则 %s）", a.Title(), a.Score, formatAPITime(a.OpenTime), a.Rule)
+	}
+	return fmt.Sprintf("%s（持续 %v，价格 %.2f，K线 %s，规则 %s）",
+		a.Title(), a.Held, a.Price, formatAPITime(a.OpenTime), a.Rule)
+}
+
+// dedupeKey 同一事件的去重键，与触发的规则无关
+func (a *Alert) dedupeKey() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d", a.Event, a.Symbol, a.Interval, a.From, a.To, a.OpenTime.UnixMilli())
+}
+
+// AlertPayload webhook 和 command 渠道收到的 JSON
+type AlertPayload struct {
+	Rule        string  `json:"rule"`
+	Event       string  `json:"event"`
+	Symbol      string  `json:"symbol"`
+	Interval    string  `json:"interval,omitempty"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Price       float64 `json:"price,omitempty"`
+	HeldSeconds int64   `json:"held_seconds,omitempty"`
+	Score       float64 `json:"score"`
+	OpenTime    string  `json:"open_time"`
+	Time        string  `json:"time"`
+	Title       string  `json:"title"`
+	Text        string  `json:"text"`
+}
+
+// NewAlertPayload 转换为 JSON 输出格式
+func NewAlertPayload(a *Alert) AlertPayload {
+	return AlertPayload{
+		Rule:        a.Rule,
+		Event:       a.Event,
+		Symbol:      a.Symbol,
+		Interval:    a.Interval,
+		From:        a.From,
+		To:          a.To,
+		Price:       a.Price,
+		HeldSeconds: int64(a.Held / time.Second),
+		Score:       a.Score,
+		OpenTime:    formatAPITime(a.OpenTime),
+		Time:        formatAPITime(a.Time),
+		Title:       a.Title(),
+		Text:        a.Text(),
+	}
+}
+
+// alertDelivery 发往某个渠道的一条告警
+type alertDelivery struct {
+	alert    *Alert
+	notifier string
+}
+
+// AlertEngine 告警引擎：按 alerts.rules 匹配状态变化和多周期评分标签变化，
+// 经冷却、免打扰和去重过滤后放入队列，由后台 goroutine 发送并失败重试，结果写入发送记录。
+// 规则在每次匹配时从当前配置读取，渠道在热加载时由 PrepareConfig 重建，因此都支持热加载
+type AlertEngine struct {
+	mu        sync.Mutex
+	notifiers map[string]Notifier
+	cooldowns map[string]time.Time // 规则|币种|周期 -> 上次触发时间
+	sent      map[string]time.Time // 去重键|渠道 -> 放入队列的时间
+	queue     chan *alertDelivery
+	closed    bool
+	now       func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logMu  sync.Mutex
+}
+
+// NewAlertEngine 按配置创建告警引擎，需要调用 Start 开始发送
+func NewAlertEngine(cfg *config.Config) (*AlertEngine, error) {
+	notifiers, err := NewNotifiers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	size := cfg.Alerts.QueueSize
+	if size <= 0 {
+		size = config.DefaultConfig().Alerts.QueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AlertEngine{
+		notifiers: notifiers,
+		cooldowns: make(map[string]time.Time),
+		sent:      make(map[string]time.Time),
+		queue:     make(chan *alertDelivery, size),
+		now:       time.Now,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// PrepareConfig 按新配置创建通知渠道，返回的 apply 函数替换当前渠道。
+// 供热加载在发布新配置前调用，创建失败时返回错误，继续使用原来的渠道
+func (e *AlertEngine) PrepareConfig(cfg *config.Config) (apply func(), err error) {
+	notifiers, err := NewNotifiers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		e.mu.Lock()
+		e.notifiers = notifiers
+		e.mu.Unlock()
+	}, nil
+}
+
+// Start 启动后台发送
+func (e *AlertEngine) Start() {
+	for i := 0; i < alertWorkers; i++ {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			for d := range e.queue {
+				e.deliver(d)
+			}
+		}()
+	}
+}
+
+// Close 停止接收新的告警，等待队列中的告警发送完成；ctx 到期后中断重试和进行中的发送
+func (e *AlertEngine) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		e.cancel()
+		return nil
+	case <-ctx.Done():
+		remaining := len(e.queue)
+		e.cancel()
+		<-done
+		return fmt.Errorf("等待告警发送超时，%d 条告警未发送", remaining)
+	}
+}
+
+// HandleTrendChanged 处理状态变化事件，可直接注册到 TrendAnalyzer.OnTrendChanged
+func (e *AlertEngine) HandleTrendChanged(ctx context.Context, event *TrendChanged) {
+	e.dispatch(&Alert{
+		Event:    config.AlertEventTrend,
+		Symbol:   event.Symbol,
+		Interval: event.Interval,
+		From:     string(event.From),
+		To:       string(event.To),
+		Price:    event.Price,
+		Held:     event.Held,
+		OpenTime: event.OpenTime,
+		Time:     event.Time,
+	})
+}
+
+// HandleConfluenceChanged 处理多周期评分标签变化，可直接注册到 TrendAnalyzer.OnConfluenceChanged。
+// 去重键使用触发评分的K线开盘时间，同一根K线重复分析产生的相同变化只发送一次
+func (e *AlertEngine) HandleConfluenceChanged(ctx context.Context, event *ConfluenceChanged) {
+	openTime := event.OpenTime
+	if openTime.IsZero() {
+		openTime = event.Result.Time
+	}
+	e.dispatch(&Alert{
+		Event:    config.AlertEventConfluence,
+		Symbol:   event.Symbol,
+		From:     string(event.From),
+		To:       string(event.To),
+		Score:    event.Result.Score,
+		OpenTime: openTime,
+		Time:     event.Result.Time,
+	})
+}
+
+// dispatch 按规则匹配事件，通过过滤的告警放入队列，不阻塞调用方
+func (e *AlertEngine) dispatch(event *Alert) {
+	cfg := config.Get().Alerts
+	if !cfg.Enabled {
+		return
+	}
+	now := e.now()
+
+	for i, rule := range cfg.Rules {
+		if !matchAlertRule(rule, event) {
+			continue
+		}
+		alert := *event
+		alert.Rule = rule.Name
+		if alert.Rule == "" {
+			alert.Rule = fmt.Sprintf("#%d", i+1)
+		}
+
+		if inQuietHours(rule.QuietHours, now) {
+			e.logDelivery(&alert, "", "免打扰", 0, nil)
+			continue
+		}
+
+		if rule.Cooldown > 0 {
+			key := fmt.Sprintf("%s|%s|%s", alert.Rule, alert.Symbol, alert.Interval)
+			e.mu.Lock()
+			last, ok := e.cooldowns[key]
+			inCooldown := ok && now.Sub(last) < time.Duration(rule.Cooldown)*time.Second
+			if !inCooldown {
+				e.cooldowns[key] = now
+			}
+			e.mu.Unlock()
+			if inCooldown {
+				e.logDelivery(&alert, "", "冷却中", 0, nil)
+				continue
+			}
+		}
+
+		for _, name := range rule.Notifiers {
+			e.enqueue(&alert, name, time.Duration(cfg.DedupeWindow)*time.Second, now)
+		}
+	}
+}
+
+// enqueue 去重后放入发送队列，队列已满或已关闭时丢弃
+func (e *AlertEngine) enqueue(alert *Alert, notifier string, window time.Duration, now time.Time) {
+	key := alert.dedupeKey() + "|" + notifier
+
+	e.mu.Lock()
+	for k, t := range e.sent {
+		if now.Sub(t) >= window {
+			delete(e.sent, k)
+		}
+	}
+	if _, dup := e.sent[key]; dup {
+		e.mu.Unlock()
+		e.logDelivery(alert, notifier, "重复", 0, nil)
+		return
+	}
+	if e.closed {
+		e.mu.Unlock()
+		e.logDelivery(alert, notifier, "已丢弃", 0, errors.New("告警引擎已关闭"))
+		return
+	}
+	select {
+	case e.queue <- &alertDelivery{alert: alert, notifier: notifier}:
+		if window > 0 {
+			e.sent[key] = now
+		}
+		e.mu.Unlock()
+	default:
+		e.mu.Unlock()
+		e.logDelivery(alert, notifier, "已丢弃", 0, errors.New("发送队列已满"))
+	}
+}
+
+// deliver 发送一条告警，失败时按 retry_delay 指数退避重试，4xx 等不可重试的错误直接放弃
+func (e *AlertEngine) deliver(d *alertDelivery) {
+	cfg := config.Get().Alerts
+	e.mu.Lock()
+	notifier, ok := e.notifiers[d.notifier]
+	e.mu.Unlock()
+	if !ok {
+		e.logDelivery(d.alert, d.notifier, "发送失败", 0, fmt.Errorf("未配置的渠道: %s", d.notifier))
+		return
+	}
+
+	delay := time.Duration(cfg.RetryDelay) * time.Second
+	attempts := 0
+	var err error
+	for {
+		attempts++
+		if err = notifier.Notify(e.ctx, d.alert); err == nil {
+			e.logDelivery(d.alert, d.notifier, "已发送", attempts, nil)
+			return
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempts > cfg.MaxRetries || e.ctx.Err() != nil {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-e.ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		delay *= 2
+	}
+
+	// 发送失败的事件允许在去重窗口内再次发送
+	e.mu.Lock()
+	delete(e.sent, d.alert.dedupeKey()+"|"+d.notifier)
+	e.mu.Unlock()
+	e.logDelivery(d.alert, d.notifier, "发送失败", attempts, err)
+}
+
+// logDelivery 输出日志并写入发送记录 <log_dir>/alerts_YYYYMMDD.log
+func (e *AlertEngine) logDelivery(alert *Alert, notifier, status string, attempts int, err error) {
+	line := fmt.Sprintf("%s 规则=%s", status, alert.Rule)
+	if notifier != "" {
+		line += " 渠道=" + notifier
+	}
+	if attempts > 0 {
+		line += fmt.Sprintf(" 尝试=%d", attempts)
+	}
+	line += " " + alert.Title()
+	if err != nil {
+		line += fmt.Sprintf(" 错误: %v", err)
+	}
+	log.Printf("[Alert] %s", line)
+
+	dir := config.Get().Alerts.LogDir
+	if dir == "" {
+		return
+	}
+	e.logMu.Lock()
+	defer e.logMu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("创建告警记录目录失败: %v", err)
+		return
+	}
+	now := e.now()
+	path := filepath.Join(dir, fmt.Sprintf("alerts_%s.log", now.Format("20060102")))
+	f, ferr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		log.Printf("打开告警记录失败: %v", ferr)
+		return
+	}
+	defer f.Close()
+	f.WriteString(fmt.Sprintf("[%s] %s\n", now.Format("2006-01-02 15:04:05"), line))
+}
+
+// matchAlertRule 判断事件是否满足规则的条件，列表为空时不限制
+func matchAlertRule(rule config.AlertRule, a *Alert) bool {
+	event := rule.Event
+	if event == "" {
+		event = config.AlertEventTrend
+	}
+	if event != a.Event {
+		return false
+	}
+	if len(rule.Symbols) > 0 && !slices.Contains(rule.Symbols, a.Symbol) {
+		return false
+	}
+	if a.Event == config.AlertEventTrend && len(rule.Intervals) > 0 && !slices.Contains(rule.Intervals, a.Interval) {
+		return false
+	}
+	if len(rule.From) > 0 && !slices.Contains(rule.From, a.From) {
+		return false
+	}
+	if len(rule.To) > 0 && !slices.Contains(rule.To, a.To) {
+		return false
+	}
+	return true
+}
+
+// inQuietHours 判断 now（本地时间）是否在免打扰时段内，时段为空或无效时返回 false
+func inQuietHours(spec string, now time.Time) bool {
+	if spec == "" {
+		return false
+	}
+	start, end, err := config.ParseQuietHours(spec)
+	if err != nil {
+		return false
+	}
+	local := now.Local()
+	m := local.Hour()*60 + local.Minute()
+	if start < end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
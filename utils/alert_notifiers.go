@@ -0,0 +1,387 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNotifierTimeout 未配置 timeout 时单次发送的超时时间
+const defaultNotifierTimeout = 10 * time.Second
+
+// Notifier 告警的通知渠道
+type Notifier interface {
+	// Notify 发送一条告警，返回 *permanentError 时不再重试
+	Notify(ctx context.Context, alert *Alert) error
+}
+
+// NotifierFactory 根据配置创建通知渠道
+type NotifierFactory func(cfg *config.Config, nc config.NotifierConfig) (Notifier, error)
+
+var (
+	notifierMu        sync.RWMutex
+	notifierFactories = make(map[string]NotifierFactory)
+)
+
+func init() {
+	RegisterNotifier("webhook", newWebhookNotifier)
+	RegisterNotifier("telegram", newTelegramNotifier)
+	RegisterNotifier("slack", newSlackNotifier)
+	RegisterNotifier("discord", newDiscordNotifier)
+	RegisterNotifier("smtp", newSMTPNotifier)
+	RegisterNotifier("command", newCommandNotifier)
+}
+
+// RegisterNotifier 注册通知渠道类型，同名会 panic，供 init 使用
+func RegisterNotifier(name string, factory NotifierFactory) {
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+	if _, exists := notifierFactories[name]; exists {
+		panic(fmt.Sprintf("通知渠道类型已存在: %s", name))
+	}
+	notifierFactories[name] = factory
+}
+
+// NotifierTypes 返回所有已注册渠道类型的名称（已排序）
+func NotifierTypes() []string {
+	notifierMu.RLock()
+	defer notifierMu.RUnlock()
+	names := make([]string, 0, len(notifierFactories))
+	for name := range notifierFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewNotifier 按渠道配置创建通知渠道
+func NewNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	notifierMu.RLock()
+	factory, ok := notifierFactories[nc.Type]
+	notifierMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的通知渠道类型: %q（可用: %v）", nc.Type, NotifierTypes())
+	}
+	return factory(cfg, nc)
+}
+
+// NewNotifiers 创建 alerts.notifiers 中的所有渠道，key 为渠道名称
+func NewNotifiers(cfg *config.Config) (map[string]Notifier, error) {
+	notifiers := make(map[string]Notifier, len(cfg.Alerts.Notifiers))
+	for name, nc := range cfg.Alerts.Notifiers {
+		n, err := NewNotifier(cfg, nc)
+		if err != nil {
+			return nil, fmt.Errorf("告警渠道 %s 配置错误: %v", name, err)
+		}
+		notifiers[name] = n
+	}
+	return notifiers, nil
+}
+
+// ValidateNotifiers 检查所有告警渠道能否创建，供启动和热加载前使用
+func ValidateNotifiers(cfg *config.Config) error {
+	_, err := NewNotifiers(cfg)
+	return err
+}
+
+// permanentError 不可重试的发送错误，如 4xx 响应
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// notifierTimeout 返回渠道单次发送的超时时间
+func notifierTimeout(nc config.NotifierConfig) time.Duration {
+	if nc.Timeout > 0 {
+		return time.Duration(nc.Timeout) * time.Second
+	}
+	return defaultNotifierTimeout
+}
+
+// newNotifierHTTPClient 创建 HTTP 类渠道的客户端，use_proxy 时通过 proxy_url 发送
+func newNotifierHTTPClient(cfg *config.Config, nc config.NotifierConfig) (*http.Client, error) {
+	proxy := ""
+	if nc.UseProxy {
+		proxy = cfg.ProxyURL
+	}
+	return newProxyHTTPClient(proxy, notifierTimeout(nc))
+}
+
+// postJSON 以 JSON 发送 POST 请求，返回响应体。非 2xx 响应返回错误，其中 429 以外的 4xx 不可重试
+func postJSON(ctx context.Context, client *http.Client, rawURL string, headers map[string]string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("编码告警失败: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("创建请求失败: %v", stripURL(err))}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %v", stripURL(err))
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, &permanentError{err}
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// stripURL 去掉 *url.Error 中的完整地址。Telegram 的 bot token 和 Slack/Discord 的 webhook 地址
+// 都在 URL 中，错误会写入日志和发送记录，不能带出
+func stripURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return fmt.Errorf("%s: %w", ue.Op, ue.Err)
+	}
+	return err
+}
+
+// webhookNotifier 以 JSON（AlertPayload）POST 到任意地址
+type webhookNotifier struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func newWebhookNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	client, err := newNotifierHTTPClient(cfg, nc)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{client: client, url: nc.URL, headers: nc.Headers}, nil
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, alert *Alert) error {
+	_, err := postJSON(ctx, n.client, n.url, n.headers, NewAlertPayload(alert))
+	return err
+}
+
+// telegramNotifier 通过 Telegram Bot API 的 sendMessage 发送
+type telegramNotifier struct {
+	client *http.Client
+	url    string
+	chatID string
+}
+
+func newTelegramNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	client, err := newNotifierHTTPClient(cfg, nc)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimRight(nc.URL, "/")
+	if base == "" {
+		base = "https://api.telegram.org"
+	}
+	return &telegramNotifier{client: client, url: base + "/bot" + nc.BotToken + "/sendMessage", chatID: nc.ChatID}, nil
+}
+
+func (n *telegramNotifier) Notify(ctx context.Context, alert *Alert) error {
+	data, err := postJSON(ctx, n.client, n.url, nil, map[string]interface{}{
+		"chat_id":                  n.chatID,
+		"text":                     alert.Text(),
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("解析 Telegram 响应失败: %v", err)
+	}
+	if !resp.OK {
+		return &permanentError{fmt.Errorf("Telegram 返回错误: %s", resp.Description)}
+	}
+	return nil
+}
+
+// chatWebhookNotifier Slack / Discord 的 Incoming Webhook，只是消息字段名不同
+type chatWebhookNotifier struct {
+	client *http.Client
+	url    string
+	field  string
+}
+
+func newSlackNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	client, err := newNotifierHTTPClient(cfg, nc)
+	if err != nil {
+		return nil, err
+	}
+	return &chatWebhookNotifier{client: client, url: nc.URL, field: "text"}, nil
+}
+
+func newDiscordNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	client, err := newNotifierHTTPClient(cfg, nc)
+	if err != nil {
+		return nil, err
+	}
+	return &chatWebhookNotifier{client: client, url: nc.URL, field: "content"}, nil
+}
+
+func (n *chatWebhookNotifier) Notify(ctx context.Context, alert *Alert) error {
+	_, err := postJSON(ctx, n.client, n.url, nil, map[string]string{n.field: alert.Text()})
+	return err
+}
+
+// smtpNotifier 通过 SMTP 发送邮件。465 端口使用 TLS 直连，其他端口在服务器支持时使用 STARTTLS
+type smtpNotifier struct {
+	nc      config.NotifierConfig
+	timeout time.Duration
+}
+
+func newSMTPNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	return &smtpNotifier{nc: nc, timeout: notifierTimeout(nc)}, nil
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, alert *Alert) error {
+	addr := net.JoinHostPort(n.nc.Host, strconv.Itoa(n.nc.Port))
+	dialer := &net.Dialer{Timeout: n.timeout}
+	var conn net.Conn
+	var err error
+	if n.nc.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: n.nc.Host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("连接 SMTP 服务器失败: %v", err)
+	}
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, n.nc.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP 握手失败: %v", err)
+	}
+	defer c.Close()
+
+	if n.nc.Port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: n.nc.Host}); err != nil {
+				return fmt.Errorf("SMTP STARTTLS 失败: %v", err)
+			}
+		}
+	}
+	if n.nc.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.nc.Username, n.nc.Password, n.nc.Host)); err != nil {
+			return &permanentError{fmt.Errorf("SMTP 认证失败: %v", err)}
+		}
+	}
+	if err := c.Mail(n.nc.From); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM 失败: %v", err)
+	}
+	for _, to := range n.nc.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s 失败: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA 失败: %v", err)
+	}
+	if _, err := w.Write(n.message(alert)); err != nil {
+		return fmt.Errorf("写入邮件失败: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return c.Quit()
+}
+
+// message 生成 UTF-8 纯文本邮件
+func (n *smtpNotifier) message(alert *Alert) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.nc.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.nc.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", alert.Title()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(alert.Text())
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// commandNotifier 执行本地命令：告警的 JSON（AlertPayload）从标准输入传入，
+// 摘要放在环境变量 CTM_ALERT_RULE/EVENT/SYMBOL/INTERVAL/FROM/TO/TEXT 中，非零退出视为失败
+type commandNotifier struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func newCommandNotifier(cfg *config.Config, nc config.NotifierConfig) (Notifier, error) {
+	if _, err := exec.LookPath(nc.Command); err != nil {
+		return nil, fmt.Errorf("找不到命令 %s: %v", nc.Command, err)
+	}
+	return &commandNotifier{command: nc.Command, args: nc.Args, timeout: notifierTimeout(nc)}, nil
+}
+
+func (n *commandNotifier) Notify(ctx context.Context, alert *Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(NewAlertPayload(alert))
+	if err != nil {
+		return fmt.Errorf("编码告警失败: %v", err)
+	}
+	cmd := exec.CommandContext(ctx, n.command, n.args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"CTM_ALERT_RULE="+alert.Rule,
+		"CTM_ALERT_EVENT="+alert.Event,
+		"CTM_ALERT_SYMBOL="+alert.Symbol,
+		"CTM_ALERT_INTERVAL="+alert.Interval,
+		"CTM_ALERT_FROM="+alert.From,
+		"CTM_ALERT_TO="+alert.To,
+		"CTM_ALERT_TEXT="+alert.Text(),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		return fmt.Errorf("执行 %s 失败: %v %s", n.command, err, msg)
+	}
+	return nil
+}
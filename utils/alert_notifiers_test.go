@@ -0,0 +1,406 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAlert 用于发送测试的告警
+func testAlert() *Alert {
+	open := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	return &Alert{
+		Rule: "trend", Event: config.AlertEventTrend, Symbol: "BTCUSDT", Interval: "1h",
+		From: "RANGE", To: "BUYMACD", Price: 42000.5, Held: 3 * time.Hour, OpenTime: open, Time: open.Add(time.Hour),
+	}
+}
+
+// closedAddr 返回一个没有监听的本地地址
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// TestNotifierErrorsHideURL 请求失败的错误中不包含 bot token 和 webhook 地址
+func TestNotifierErrorsHideURL(t *testing.T) {
+	base := "http://" + closedAddr(t)
+	cfg := config.DefaultConfig()
+	notifiers := []config.NotifierConfig{
+		{Type: "telegram", URL: base, BotToken: "123:tg-secret", ChatID: "42", Timeout: 2},
+		{Type: "slack", URL: base + "/services/slack-secret", Timeout: 2},
+		{Type: "discord", URL: base + "/api/webhooks/discord-secret", Timeout: 2},
+		{Type: "webhook", URL: base + "/hook?key=hook-secret", Timeout: 2},
+		{Type: "webhook", URL: "http://exa mple.com/hook-secret", Timeout: 2},
+	}
+	for _, nc := range notifiers {
+		n, err := NewNotifier(cfg, nc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = n.Notify(context.Background(), testAlert())
+		if err == nil {
+			t.Fatalf("%s: Notify to closed port succeeded", nc.Type)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Fatalf("%s: error leaks the url: %v", nc.Type, err)
+		}
+	}
+}
+
+// notifierStub 记录收到的请求并返回固定响应的 HTTP 服务器
+type notifierStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	response string
+	requests []stubRequest
+}
+
+type stubRequest struct {
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+func newNotifierStub(t *testing.T, response string) *notifierStub {
+	s := &notifierStub{status: http.StatusOK, response: response}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, stubRequest{path: r.URL.RequestURI(), header: r.Header.Clone(), body: body})
+		status, response := s.status, s.response
+		s.mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *notifierStub) last(t *testing.T) stubRequest {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		t.Fatal("stub received no request")
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *notifierStub) setStatus(status int, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.response = status, response
+}
+
+func newTestNotifier(t *testing.T, nc config.NotifierConfig) Notifier {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	n, err := NewNotifier(cfg, nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func isPermanent(err error) bool {
+	var perm *permanentError
+	return errors.As(err, &perm)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	stub := newNotifierStub(t, "ok")
+	n := newTestNotifier(t, config.NotifierConfig{Type: "webhook", URL: stub.URL + "/hook?key=1",
+		Headers: map[string]string{"Authorization": "Bearer xxx"}})
+	alert := testAlert()
+
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	req := stub.last(t)
+	if req.path != "/hook?key=1" || req.header.Get("Authorization") != "Bearer xxx" || req.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("request = %s %v", req.path, req.header)
+	}
+	want := map[string]interface{}{
+		"rule": "trend", "event": "trend", "symbol": "BTCUSDT", "interval": "1h", "from": "RANGE", "to": "BUYMACD",
+		"price": 42000.5, "held_seconds": float64(3 * 3600), "title": alert.Title(), "text": alert.Text(),
+		"open_time": formatAPITime(alert.OpenTime),
+	}
+	for k, v := range want {
+		if req.body[k] != v {
+			t.Errorf("payload[%s] = %v, want %v", k, req.body[k], v)
+		}
+	}
+
+	// 5xx 和 429 可以重试，其他 4xx 不重试
+	for _, tt := range []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusNotFound, true},
+		{http.StatusForbidden, true},
+	} {
+		stub.setStatus(tt.status, "nope")
+		err := n.Notify(context.Background(), alert)
+		if err == nil || isPermanent(err) != tt.permanent {
+			t.Errorf("status %d: err = %v, permanent = %v, want permanent = %v", tt.status, err, isPermanent(err), tt.permanent)
+		}
+	}
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	stub := newNotifierStub(t, `{"ok":true,"result":{}}`)
+	n := newTestNotifier(t, config.NotifierConfig{Type: "telegram", URL: stub.URL + "/", BotToken: "123:abc", ChatID: "10001"})
+	alert := testAlert()
+
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	req := stub.last(t)
+	if req.path != "/bot123:abc/sendMessage" {
+		t.Fatalf("path = %s", req.path)
+	}
+	if req.body["chat_id"] != "10001" || req.body["text"] != alert.Text() || req.body["disable_web_page_preview"] != true {
+		t.Fatalf("body = %v", req.body)
+	}
+
+	// Bot API 返回 ok=false 时不重试
+	stub.setStatus(http.StatusOK, `{"ok":false,"description":"Bad Request: chat not found"}`)
+	err := n.Notify(context.Background(), alert)
+	if !isPermanent(err) || !strings.Contains(err.Error(), "chat not found") {
+		t.Fatalf("ok=false: err = %v", err)
+	}
+	stub.setStatus(http.StatusOK, "not json")
+	if err := n.Notify(context.Background(), alert); err == nil || isPermanent(err) {
+		t.Fatalf("invalid response: err = %v", err)
+	}
+}
+
+func TestChatWebhookNotifiers(t *testing.T) {
+	for _, tt := range []struct {
+		typ   string
+		field string
+	}{
+		{"slack", "text"},
+		{"discord", "content"},
+	} {
+		stub := newNotifierStub(t, "ok")
+		n := newTestNotifier(t, config.NotifierConfig{Type: tt.typ, URL: stub.URL + "/services/T000/B000/XXX"})
+		alert := testAlert()
+		if err := n.Notify(context.Background(), alert); err != nil {
+			t.Fatalf("%s: %v", tt.typ, err)
+		}
+		req := stub.last(t)
+		if req.path != "/services/T000/B000/XXX" || len(req.body) != 1 || req.body[tt.field] != alert.Text() {
+			t.Fatalf("%s: request = %s %v", tt.typ, req.path, req.body)
+		}
+	}
+}
+
+// fakeSMTPServer 最小的 SMTP 服务器，记录收到的命令和邮件内容，不支持 STARTTLS
+type fakeSMTPServer struct {
+	addr     string
+	authCode string // AUTH 的响应码，235 表示认证成功
+
+	mu       sync.Mutex
+	commands []string
+	auth     string
+	data     string
+}
+
+func newFakeSMTPServer(t *testing.T, authCode string) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSMTPServer{addr: l.Addr().String(), authCode: authCode}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = line
+			s.mu.Unlock()
+			if s.authCode != "235" {
+				reply(s.authCode + " authentication failed")
+				continue
+			}
+			reply("235 ok")
+		case "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.mu.Lock()
+			s.data = b.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	srv := newFakeSMTPServer(t, "235")
+	host, port, _ := net.SplitHostPort(srv.addr)
+	portNum, _ := strconv.Atoi(port)
+	nc := config.NotifierConfig{Type: "smtp", Host: host, Port: portNum, Username: "bot", Password: "pw",
+		From: "bot@example.com", To: []string{"a@example.com", "b@example.com"}, Timeout: 5}
+	alert := testAlert()
+
+	if err := newTestNotifier(t, nc).Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	commands, auth, data := strings.Join(srv.commands, "\n"), srv.auth, srv.data
+	srv.mu.Unlock()
+
+	// AUTH PLAIN 的凭据为 base64("\x00bot\x00pw")
+	if want := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00bot\x00pw")); auth != want {
+		t.Fatalf("auth = %q, want %q", auth, want)
+	}
+	for _, want := range []string{"MAIL FROM:<bot@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "QUIT"} {
+		if !strings.Contains(commands, want) {
+			t.Fatalf("commands missing %q:\n%s", want, commands)
+		}
+	}
+	for _, want := range []string{
+		"From: bot@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: " + mime.BEncoding.Encode("UTF-8", alert.Title()) + "\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\n" + alert.Text() + "\r\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("message missing %q:\n%s", want, data)
+		}
+	}
+
+	// 认证失败不重试
+	bad := newFakeSMTPServer(t, "535")
+	_, port, _ = net.SplitHostPort(bad.addr)
+	nc.Port, _ = strconv.Atoi(port)
+	if err := newTestNotifier(t, nc).Notify(context.Background(), alert); !isPermanent(err) {
+		t.Fatalf("auth failure: err = %v, want permanent", err)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "payload.json")
+	script := `cat > "$0"; printf '%s|%s|%s|%s|%s|%s|%s' "$CTM_ALERT_RULE" "$CTM_ALERT_EVENT" "$CTM_ALERT_SYMBOL" ` +
+		`"$CTM_ALERT_INTERVAL" "$CTM_ALERT_FROM" "$CTM_ALERT_TO" "$CTM_ALERT_TEXT" > "$0.env"`
+	alert := testAlert()
+
+	n := newTestNotifier(t, config.NotifierConfig{Type: "command", Command: "sh", Args: []string{"-c", script, out}})
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload AlertPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("stdin is not an AlertPayload: %v\n%s", err, data)
+	}
+	if payload != NewAlertPayload(alert) {
+		t.Fatalf("payload = %+v", payload)
+	}
+	env, err := os.ReadFile(out + ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "trend|trend|BTCUSDT|1h|RANGE|BUYMACD|" + alert.Text(); string(env) != want {
+		t.Fatalf("env = %q, want %q", env, want)
+	}
+
+	// 非零退出视为失败，错误中带上输出
+	n = newTestNotifier(t, config.NotifierConfig{Type: "command", Command: "sh", Args: []string{"-c", "echo boom >&2; exit 3"}})
+	if err := n.Notify(context.Background(), alert); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("failing command: err = %v", err)
+	}
+	// 超时后终止
+	n = newTestNotifier(t, config.NotifierConfig{Type: "command", Command: "sleep", Args: []string{"10"}, Timeout: 1})
+	start := time.Now()
+	if err := n.Notify(context.Background(), alert); err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("slow command: err = %v after %v", err, time.Since(start))
+	}
+
+	if _, err := NewNotifier(config.DefaultConfig(), config.NotifierConfig{Type: "command", Command: "no-such-command-ctm"}); err == nil {
+		t.Fatal("missing command should fail at creation")
+	}
+}
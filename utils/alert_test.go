@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier 记录收到的告警，按顺序返回预设的错误，用完后发送成功
+type recordingNotifier struct {
+	mu    sync.Mutex
+	errs  []error
+	calls []*Alert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert *Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, alert)
+	if len(n.errs) == 0 {
+		return nil
+	}
+	err := n.errs[0]
+	n.errs = n.errs[1:]
+	return err
+}
+
+func (n *recordingNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.calls)
+}
+
+// newTestAlertEngine 使用给定规则和假时钟创建告警引擎，渠道替换为 notifiers，发送记录写入临时目录
+func newTestAlertEngine(t *testing.T, clock *fakeClock, rules []config.AlertRule, notifiers map[string]Notifier) *AlertEngine {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.Alerts.Enabled = true
+	cfg.Alerts.Notifiers = nil
+	cfg.Alerts.Rules = rules
+	cfg.Alerts.MaxRetries = 3
+	cfg.Alerts.RetryDelay = 0
+	cfg.Alerts.DedupeWindow = 600
+	cfg.Alerts.LogDir = t.TempDir()
+	setTestConfig(t, cfg)
+
+	e, err := NewAlertEngine(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.notifiers = notifiers
+	e.now = clock.Now
+	return e
+}
+
+// drain 在当前 goroutine 中发送队列里的全部告警
+func (e *AlertEngine) drain() {
+	for {
+		select {
+		case d := <-e.queue:
+			e.deliver(d)
+		default:
+			return
+		}
+	}
+}
+
+// alertLog 读取假时钟当天的发送记录
+func alertLog(t *testing.T, clock *fakeClock) string {
+	t.Helper()
+	path := filepath.Join(config.Get().Alerts.LogDir, "alerts_"+clock.Now().Format("20060102")+".log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func trendEvent(symbol string, openTime time.Time) *TrendChanged {
+	return &TrendChanged{Symbol: symbol, Interval: "1h", From: RANGE, To: BUYMACD, Price: 100, OpenTime: openTime, Time: openTime}
+}
+
+func TestAlertEngineCooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)}
+	n := &recordingNotifier{}
+	e := newTestAlertEngine(t, clock, []config.AlertRule{
+		{Name: "cool", Event: config.AlertEventTrend, Cooldown: 600, Notifiers: []string{"fake"}},
+	}, map[string]Notifier{"fake": n})
+	ctx := context.Background()
+
+	e.HandleTrendChanged(ctx, trendEvent("BTCUSDT", clock.Now()))
+	clock.Advance(5 * time.Minute)
+	// 同一规则、币种和周期在冷却中，其他币种不受影响
+	e.HandleTrendChanged(ctx, trendEvent("BTCUSDT", clock.Now()))
+	e.HandleTrendChanged(ctx, trendEvent("ETHUSDT", clock.Now()))
+	clock.Advance(5 * time.Minute)
+	e.HandleTrendChanged(ctx, trendEvent("BTCUSDT", clock.Now()))
+	e.drain()
+
+	if got := n.count(); got != 3 {
+		t.Fatalf("sent %d alerts, want 3", got)
+	}
+	if log := alertLog(t, clock); strings.Count(log, "冷却中 规则=cool BTCUSDT 1h") != 1 {
+		t.Fatalf("log:\n%s", log)
+	}
+}
+
+func TestAlertEngineQuietHours(t *testing.T) {
+	clock := &fakeClock{}
+	n := &recordingNotifier{}
+	e := newTestAlertEngine(t, clock, []config.AlertRule{
+		{Name: "night", Event: config.AlertEventTrend, QuietHours: "23:00-07:00", Notifiers: []string{"fake"}},
+	}, map[string]Notifier{"fake": n})
+
+	// 免打扰时段按本地时间计算，跨过零点
+	for _, tt := range []struct {
+		hour, minute int
+		sent         bool
+	}{
+		{23, 30, false},
+		{12, 0, true},
+		{6, 59, false},
+		{7, 0, true},
+		{22, 59, true},
+	} {
+		clock.now = time.Date(2025, 1, 2, tt.hour, tt.minute, 0, 0, time.Local)
+		before := n.count()
+		e.HandleTrendChanged(context.Background(), trendEvent("BTCUSDT", clock.now))
+		e.drain()
+		if sent := n.count() > before; sent != tt.sent {
+			t.Errorf("%02d:%02d: sent = %v, want %v", tt.hour, tt.minute, sent, tt.sent)
+		}
+	}
+	if log := alertLog(t, clock); strings.Count(log, "免打扰 规则=night") != 2 {
+		t.Fatalf("log:\n%s", log)
+	}
+}
+
+func TestAlertEngineDedupe(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)}
+	n := &recordingNotifier{}
+	// 两条规则匹配同一事件，去重键与规则无关
+	e := newTestAlertEngine(t, clock, []config.AlertRule{
+		{Name: "a", Event: config.AlertEventTrend, Notifiers: []string{"fake"}},
+		{Name: "b", Event: config.AlertEventTrend, Symbols: []string{"BTCUSDT"}, Notifiers: []string{"fake"}},
+	}, map[string]Notifier{"fake": n})
+	ctx := context.Background()
+	event := trendEvent("BTCUSDT", clock.Now())
+
+	e.HandleTrendChanged(ctx, event)
+	e.drain()
+	if got := n.count(); got != 1 {
+		t.Fatalf("sent %d alerts, want 1", got)
+	}
+
+	// 窗口内重复的事件不再发送，窗口过后允许再次发送
+	clock.Advance(5 * time.Minute)
+	e.HandleTrendChanged(ctx, event)
+	e.drain()
+	if got := n.count(); got != 1 {
+		t.Fatalf("sent %d alerts within the window, want 1", got)
+	}
+	clock.Advance(6 * time.Minute)
+	e.HandleTrendChanged(ctx, event)
+	e.drain()
+	if got := n.count(); got != 2 {
+		t.Fatalf("sent %d alerts after the window, want 2", got)
+	}
+	if log := alertLog(t, clock); strings.Count(log, "重复 规则=") != 4 {
+		t.Fatalf("log:\n%s", log)
+	}
+
+	// 发送失败的事件可以在窗口内重新发送
+	n.errs = []error{&permanentError{errors.New("HTTP 400: bad")}}
+	failed := trendEvent("ETHUSDT", clock.Now())
+	e.HandleTrendChanged(ctx, failed)
+	e.drain()
+	e.HandleTrendChanged(ctx, failed)
+	e.drain()
+	if got := n.count(); got != 4 {
+		t.Fatalf("sent %d alerts, want a retry after the failed delivery", got)
+	}
+}
+
+func TestAlertEngineRetry(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)}
+	transient := errors.New("HTTP 500: oops")
+	flaky := &recordingNotifier{errs: []error{transient, transient}}
+	down := &recordingNotifier{errs: []error{transient, transient, transient, transient, transient}}
+	bad := &recordingNotifier{errs: []error{&permanentError{errors.New("HTTP 403: forbidden")}}}
+	e := newTestAlertEngine(t, clock, []config.AlertRule{
+		{Name: "all", Event: config.AlertEventTrend, Notifiers: []string{"flaky", "down", "bad", "missing"}},
+	}, map[string]Notifier{"flaky": flaky, "down": down, "bad": bad})
+
+	e.Start()
+	e.HandleTrendChanged(context.Background(), trendEvent("BTCUSDT", clock.Now()))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重试 max_retries 次后放弃，不可重试的错误只尝试一次
+	if flaky.count() != 3 || down.count() != 4 || bad.count() != 1 {
+		t.Fatalf("attempts: flaky=%d down=%d bad=%d, want 3, 4, 1", flaky.count(), down.count(), bad.count())
+	}
+	log := alertLog(t, clock)
+	for _, want := range []string{
+		"已发送 规则=all 渠道=flaky 尝试=3 BTCUSDT 1h: RANGE -> BUYMACD\n",
+		"发送失败 规则=all 渠道=down 尝试=4 BTCUSDT 1h: RANGE -> BUYMACD 错误: HTTP 500: oops\n",
+		"发送失败 规则=all 渠道=bad 尝试=1 BTCUSDT 1h: RANGE -> BUYMACD 错误: HTTP 403: forbidden\n",
+		"发送失败 规则=all 渠道=missing BTCUSDT 1h: RANGE -> BUYMACD 错误: 未配置的渠道: missing\n",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log missing %q:\n%s", want, log)
+		}
+	}
+
+	// 关闭后的告警直接丢弃
+	e.HandleTrendChanged(context.Background(), trendEvent("ETHUSDT", clock.Now()))
+	if flaky.count() != 3 || !strings.Contains(alertLog(t, clock), "已丢弃 规则=all 渠道=flaky ETHUSDT") {
+		t.Fatalf("alert after Close was not dropped:\n%s", alertLog(t, clock))
+	}
+}
+
+// TestConfluenceDedupeUsesCandleTime 多周期评分告警按触发评分的K线去重，与评分计算的时间无关
+func TestConfluenceDedupeUsesCandleTime(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)}
+	n := &recordingNotifier{}
+	e := newTestAlertEngine(t, clock, []config.AlertRule{
+		{Name: "score", Event: config.AlertEventConfluence, Notifiers: []string{"fake"}},
+	}, map[string]Notifier{"fake": n})
+	config.Get().KlineCache.Enabled = false
+
+	// 事件的 OpenTime 为本次更新中该币种最新的K线
+	a := NewTrendAnalyzer(nil)
+	var events []*ConfluenceChanged
+	a.OnConfluenceChanged(func(ctx context.Context, event *ConfluenceChanged) { events = append(events, event) })
+	h4 := time.Date(2025, 1, 1, 8, 0, 0, 0, time.Local)
+	h1 := time.Date(2025, 1, 1, 11, 0, 0, 0, time.Local)
+	ctx := context.Background()
+	a.UpdateConfluence(ctx, []*TrendResult{
+		{Symbol: "BTCUSDT", Interval: "4h", Status: RANGE, OpenTime: h4},
+		{Symbol: "BTCUSDT", Interval: "1h", Status: RANGE, OpenTime: h1},
+	})
+	a.UpdateConfluence(ctx, []*TrendResult{
+		{Symbol: "BTCUSDT", Interval: "1h", Status: BUYMACD, OpenTime: h1},
+		{Symbol: "BTCUSDT", Interval: "4h", Status: BUYMACD, OpenTime: h4},
+	})
+	if len(events) != 1 || !events[0].OpenTime.Equal(h1) {
+		t.Fatalf("events = %+v, want one change at the 1h candle", events)
+	}
+
+	// 同一根K线重复分析得出相同的变化，评分时间不同也只发送一次
+	event := func(openTime time.Time) *ConfluenceChanged {
+		return &ConfluenceChanged{
+			Symbol: "BTCUSDT", From: MIXED, To: STRONG_BULL, OpenTime: openTime,
+			Result: &ConfluenceResult{Symbol: "BTCUSDT", Score: 1, Label: STRONG_BULL, Time: clock.Now()},
+		}
+	}
+	e.HandleConfluenceChanged(ctx, event(h1))
+	clock.Advance(time.Minute)
+	e.HandleConfluenceChanged(ctx, event(h1))
+	e.drain()
+	if got := n.count(); got != 1 {
+		t.Fatalf("sent %d alerts for the same candle, want 1", got)
+	}
+
+	// 下一根K线上的同样变化是新的事件
+	e.HandleConfluenceChanged(ctx, event(h1.Add(time.Hour)))
+	e.drain()
+	if got := n.count(); got != 2 {
+		t.Fatalf("sent %d alerts, want 2 after a new candle", got)
+	}
+}
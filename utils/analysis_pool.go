@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AnalysisJob 一个币种周期的分析任务
+type AnalysisJob struct {
+	Symbol   string
+	Interval string
+}
+
+// JobError 分析任务失败的原因
+type JobError struct {
+	Symbol   string
+	Interval string
+	Err      error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Symbol, e.Interval, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// AnalysisReport 一轮并发分析的结果。Results 与 Errors 都按任务顺序排列，
+// 与并发执行的完成顺序无关
+type AnalysisReport struct {
+	Results  []*TrendResult
+	Errors   []*JobError
+	Workers  int
+	Duration time.Duration
+	// 本轮结果更新后各币种的多周期共振评分
+	Confluence []*ConfluenceResult
+}
+
+// Err 汇总所有失败的任务，全部成功时返回 nil
+func (r *AnalysisReport) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	errs := make([]error, len(r.Errors))
+	for i, e := range r.Errors {
+		errs[i] = e
+	}
+	return errors.Join(errs...)
+}
+
+// FailedIntervals 返回有任务失败的周期（按首次出现的顺序）
+func (r *AnalysisReport) FailedIntervals() []string {
+	var intervals []string
+	for _, e := range r.Errors {
+		if !slices.Contains(intervals, e.Interval) {
+			intervals = append(intervals, e.Interval)
+		}
+	}
+	return intervals
+}
+
+// Summary 返回一行统计信息
+func (r *AnalysisReport) Summary() string {
+	return fmt.Sprintf("成功 %d，失败 %d，并发 %d，耗时 %v",
+		len(r.Results), len(r.Errors), r.Workers, r.Duration.Round(time.Millisecond))
+}
+
+// AnalyzeJobs 用有界的协程池并发执行 jobs。每个任务有单独的超时（concurrency.job_timeout）；
+// ctx 取消后尚未开始的任务不再执行，进行中的请求和限速等待会立即返回
+func (a *TrendAnalyzer) AnalyzeJobs(ctx context.Context, jobs []AnalysisJob) *AnalysisReport {
+	start := time.Now()
+	cfg := config.Get()
+	timeout := time.Duration(cfg.Concurrency.JobTimeout) * time.Second
+	workers := analysisWorkers(cfg, len(jobs))
+
+	results := make([]*TrendResult, len(jobs))
+	errs := make([]error, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = a.analyzeJob(ctx, jobs[i], timeout)
+			}
+		}()
+	}
+
+dispatch:
+	for i := range jobs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			for j := i; j < len(jobs); j++ {
+				errs[j] = ctx.Err()
+			}
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	report := &AnalysisReport{Workers: workers}
+	for i, job := range jobs {
+		if errs[i] != nil {
+			report.Errors = append(report.Errors, &JobError{Symbol: job.Symbol, Interval: job.Interval, Err: errs[i]})
+			continue
+		}
+		report.Results = append(report.Results, results[i])
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// analyzeJob 在单独的超时内分析一个币种周期
+func (a *TrendAnalyzer) analyzeJob(ctx context.Context, job AnalysisJob, timeout time.Duration) (*TrendResult, error) {
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := a.AnalyzeTrend(jobCtx, job.Symbol, job.Interval)
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("超过单个任务超时 %v: %w", timeout, err)
+	}
+	return result, err
+}
+
+// analysisWorkers 计算并发数：不超过配置的 workers 和任务数，
+// 且同时进行的全量K线请求的权重之和不超过每分钟的权重预算
+func analysisWorkers(cfg *config.Config, jobs int) int {
+	workers := min(cfg.Concurrency.Workers, jobs)
+	if byWeight := cfg.RateLimit.WeightBudget / klineWeight(klineHistoryLimit); byWeight < workers {
+		workers = byWeight
+	}
+	return max(workers, 1)
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfluenceView 多周期共振评分的 API 输出格式
+type ConfluenceView struct {
+	Symbol     string                `json:"symbol"`
+	Score      float64               `json:"score"`
+	Label      string                `json:"label"`
+	Vetoed     bool                  `json:"vetoed"`
+	VetoBy     string                `json:"veto_by,omitempty"`
+	Coverage   float64               `json:"coverage"`
+	Components []ConfluenceComponent `json:"components"`
+	Time       string                `json:"time"`
+}
+
+// NewConfluenceView 转换为 API 输出格式
+func NewConfluenceView(r *ConfluenceResult) ConfluenceView {
+	return ConfluenceView{
+		Symbol:     r.Symbol,
+		Score:      r.Score,
+		Label:      string(r.Label),
+		Vetoed:     r.Vetoed,
+		VetoBy:     r.VetoBy,
+		Coverage:   r.Coverage,
+		Components: r.Components,
+		Time:       formatAPITime(r.Time),
+	}
+}
+
+// UpdateConfluence 更新各币种最新的多周期评分
+func (api *TrendAPI) UpdateConfluence(results []*ConfluenceResult) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, r := range results {
+		api.latestConfluence[r.Symbol] = r
+	}
+}
+
+// confluence 返回币种最新的多周期评分
+func (api *TrendAPI) confluence(symbol string) (*ConfluenceResult, bool) {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	r, ok := api.latestConfluence[symbol]
+	return r, ok
+}
+
+// handleConfluence 返回所有已配置币种最新的多周期评分
+//
+//	GET /api/confluence?format=json|text
+func (api *TrendAPI) handleConfluence(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Get()
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, symbol := range cfg.Symbols {
+			label := "unknown"
+			if res, ok := api.confluence(symbol); ok {
+				label = fmt.Sprintf("%s %+.2f", res.Label, res.Score)
+			}
+			fmt.Fprintf(w, "%s: %s\n", symbol, label)
+		}
+		return
+	}
+
+	items := make(map[string]*ConfluenceView, len(cfg.Symbols))
+	for _, symbol := range cfg.Symbols {
+		if res, ok := api.confluence(symbol); ok {
+			view := NewConfluenceView(res)
+			items[symbol] = &view
+		} else {
+			items[symbol] = nil
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbols":    cfg.Symbols,
+		"confluence": items,
+	})
+}
+
+// handleConfluenceSymbol 返回单个币种最新的多周期评分，支持与 /api/trend/{symbol} 相同的简写
+//
+//	GET /api/confluence/{symbol}?format=json|text
+func (api *TrendAPI) handleConfluenceSymbol(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/confluence/"), "/")
+	textFormat := r.URL.Query().Get("format") == "text"
+
+	symbol, _, ok := resolveSymbol(name)
+	if name == "" || strings.Contains(name, "/") || !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("未配置的币种: %s", name))
+		return
+	}
+
+	label := displayName(symbol)
+	res, ok := api.confluence(symbol)
+	if textFormat {
+		// 纯文本格式，适合Rainmeter
+		w.Header().Set("Content-Type", "text/plain")
+		if !ok {
+			fmt.Fprintf(w, "%s Confluence: unknown", label)
+			return
+		}
+		fmt.Fprintf(w, "%s Confluence: %s %+.2f", label, res.Label, res.Score)
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("%s Confluence: unknown", label))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewConfluenceView(res))
+}
+
+// handleConfluenceHistory 查询历史多周期评分
+//
+//	GET /api/confluence/history?symbol=BTCUSDT&from=2025-08-01&to=2025-08-31&limit=100
+func (api *TrendAPI) handleConfluenceHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	symbol := strings.ToUpper(params.Get("symbol"))
+	if symbol == "" {
+		writeJSONError(w, http.StatusBadRequest, "缺少参数 symbol")
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if v := params.Get("from"); v != "" {
+		if from, err = ParseTime(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 from 无效: %v", err))
+			return
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if to, err = ParseTime(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 to 无效: %v", err))
+			return
+		}
+	}
+	limit := defaultHistoryLimit
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 limit 必须在 1-%d 之间", maxHistoryLimit))
+			return
+		}
+	}
+
+	store, ok := api.analyzer.Store().(ConfluenceStore)
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, "未启用持久化存储")
+		return
+	}
+	results, err := store.QueryConfluence(symbol, from, to, limit)
+	if errors.Is(err, ErrStoreUnavailable) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	views := make([]ConfluenceView, len(results))
+	for i, res := range results {
+		views[i] = NewConfluenceView(res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol": symbol,
+		"count":  len(views),
+		"items":  views,
+	})
+}
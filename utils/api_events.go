@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrendEventView 状态变化事件的 API 输出格式
+type TrendEventView struct {
+	Symbol      string  `json:"symbol"`
+	Interval    string  `json:"interval"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Since       string  `json:"since"`
+	HeldSeconds int64   `json:"held_seconds"`
+	Price       float64 `json:"price"`
+	OpenTime    string  `json:"open_time"`
+	Time        string  `json:"time"`
+}
+
+// NewTrendEventView 转换为 API 输出格式
+func NewTrendEventView(e *TrendChanged) TrendEventView {
+	return TrendEventView{
+		Symbol:      e.Symbol,
+		Interval:    e.Interval,
+		From:        string(e.From),
+		To:          string(e.To),
+		Since:       formatAPITime(e.Since),
+		HeldSeconds: int64(e.Held / time.Second),
+		Price:       e.Price,
+		OpenTime:    formatAPITime(e.OpenTime),
+		Time:        formatAPITime(e.Time),
+	}
+}
+
+// handleEvents 查询状态变化事件，symbol 和 interval 可省略
+//
+//	GET /api/events?symbol=BTCUSDT&interval=1h&from=2025-08-01&to=2025-08-31&limit=100
+func (api *TrendAPI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := EventQuery{
+		Symbol:   strings.ToUpper(params.Get("symbol")),
+		Interval: params.Get("interval"),
+		Limit:    defaultHistoryLimit,
+	}
+	if q.Interval != "" && !config.IsKnownInterval(q.Interval) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("不支持的 interval: %s", q.Interval))
+		return
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if q.From, err = ParseTime(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 from 无效: %v", err))
+			return
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if q.To, err = ParseTime(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 to 无效: %v", err))
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 || q.Limit > maxHistoryLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("参数 limit 必须在 1-%d 之间", maxHistoryLimit))
+			return
+		}
+	}
+
+	store, ok := api.analyzer.Store().(EventStore)
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, "未启用持久化存储")
+		return
+	}
+	events, err := store.QueryTrendEvents(q)
+	if errors.Is(err, ErrStoreUnavailable) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	views := make([]TrendEventView, len(events))
+	for i, e := range events {
+		views[i] = NewTrendEventView(e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": len(views),
+		"items": views,
+	})
+}
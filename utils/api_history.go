@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// TrendResultView 趋势结果的 API 输出格式
+type TrendResultView struct {
+	Symbol      string  `json:"symbol"`
+	Interval    string  `json:"interval"`
+	Trend       string  `json:"trend"`
+	Provisional string  `json:"provisional"`
+	Closed      bool    `json:"closed"`
+	Rule        string  `json:"rule"`
+	OpenTime    string  `json:"open_time"`
+	CloseTime   string  `json:"close_time"`
+	Price       float64 `json:"price"`
+	EMA25       float64 `json:"ema25"`
+	EMA50       float64 `json:"ema50"`
+	EMA120      float64 `json:"ema120"`
+	MA60        float64 `json:"ma60"`
+	DIF         float64 `json:"dif"`
+	DEA         float64 `json:"dea"`
+	Histogram   float64 `json:"histogram"`
+	AnalyzedAt  string  `json:"time"`
+}
+
+// NewTrendResultView 转换为 API 输出格式
+func NewTrendResultView(r *TrendResult) TrendResultView {
+	return TrendResultView{
+		Symbol:      r.Symbol,
+		Interval:    r.Interval,
+		Trend:       string(r.Status),
+		Provisional: string(r.Provisional),
+		Closed:      r.Closed,
+		Rule:        r.Rule,
+		OpenTime:    formatAPITime(r.OpenTime),
+		CloseTime:   formatAPITime(r.CloseTime),
+		Price:       r.Price,
+		EMA25:       r.EMA25,
+		EMA50:       r.EMA50,
+		EMA120:      r.EMA120,
+		MA60:        r.MA60,
+		DIF:         r.DIF,
+		DEA:         r.DEA,
+		Histogram:   r.Histogram,
+		AnalyzedAt:  formatAPITime(r.Time),
+	}
+}
+
+func formatAPITime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// historyCSVHeader CSV 输出的表头，与 csvRecord 的顺序一致
+var historyCSVHeader = []string{
+	"symbol", "interval", "trend", "provisional", "closed", "rule", "open_time", "close_time", "price",
+	"ema25", "ema50", "ema120", "ma60", "dif", "dea", "histogram", "time",
+}
+
+func (v TrendResultView) csvRecord() []string {
+	f := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+	return []string{
+		v.Symbol, v.Interval, v.Trend, v.Provisional, strconv.FormatBool(v.Closed), v.Rule, v.OpenTime, v.CloseTime, f(v.Price),
+		f(v.EMA25), f(v.EMA50), f(v.EMA120), f(v.MA60), f(v.DIF), f(v.DEA), f(v.Histogram), v.AnalyzedAt,
+	}
+}
+
+// handleTrendHistory 查询历史趋势
+//
+//	GET /api/trend/history?symbol=BTCUSDT&interval=1h&from=2025-08-01&to=2025-08-31&limit=100&offset=0&format=json|csv
+func (api *TrendAPI) handleTrendHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	store := api.analyzer.Store()
+	if store == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "未启用持久化存储")
+		return
+	}
+
+	// 多取一条用于判断是否还有下一页
+	limit := q.Limit
+	q.Limit++
+	results, err := store.QueryHistory(q)
+	if errors.Is(err, ErrStoreUnavailable) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+
+	views := make([]TrendResultView, len(results))
+	for i, res := range results {
+		views[i] = NewTrendResultView(res)
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=%s_%s_history.csv", q.Symbol, q.Interval))
+		if hasMore {
+			w.Header().Set("X-Next-Offset", strconv.Itoa(q.Offset+limit))
+		}
+		cw := csv.NewWriter(w)
+		cw.Write(historyCSVHeader)
+		for _, v := range views {
+			cw.Write(v.csvRecord())
+		}
+		cw.Flush()
+		return
+	}
+
+	resp := map[string]interface{}{
+		"symbol":   q.Symbol,
+		"interval": q.Interval,
+		"count":    len(views),
+		"limit":    limit,
+		"offset":   q.Offset,
+		"has_more": hasMore,
+		"items":    views,
+	}
+	if hasMore {
+		resp["next_offset"] = q.Offset + limit
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseHistoryQuery 解析并校验历史查询参数
+func parseHistoryQuery(r *http.Request) (HistoryQuery, error) {
+	params := r.URL.Query()
+	q := HistoryQuery{
+		Symbol:   strings.ToUpper(params.Get("symbol")),
+		Interval: params.Get("interval"),
+		Limit:    defaultHistoryLimit,
+	}
+
+	if q.Symbol == "" {
+		return q, fmt.Errorf("缺少参数 symbol")
+	}
+	if q.Interval == "" {
+		return q, fmt.Errorf("缺少参数 interval")
+	}
+	if !config.IsKnownInterval(q.Interval) {
+		return q, fmt.Errorf("不支持的 interval: %s", q.Interval)
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if q.From, err = ParseTime(v); err != nil {
+			return q, fmt.Errorf("参数 from 无效: %v", err)
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if q.To, err = ParseTime(v); err != nil {
+			return q, fmt.Errorf("参数 to 无效: %v", err)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.From.After(q.To) {
+		return q, fmt.Errorf("from 不能晚于 to")
+	}
+
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 || q.Limit > maxHistoryLimit {
+			return q, fmt.Errorf("参数 limit 必须在 1-%d 之间", maxHistoryLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("参数 offset 必须为非负整数")
+		}
+	}
+	return q, nil
+}
+
+// ParseTime 解析 API 和命令行中的时间参数：Unix 秒/毫秒、RFC3339、"2006-01-02 15:04:05" 或 "2006-01-02"（本地时区）
+func ParseTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法识别的时间格式 %q", v)
+}
+
+// writeJSONError 输出 JSON 格式的错误
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"context"
+	"crypto_trend_monitor/config"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 )
 
@@ -13,29 +17,64 @@ type TrendAPI struct {
 	Port          int
 	analyzer      *TrendAnalyzer
 	latestResults map[string]*TrendResult // 按symbol存储最新结果
-	mu            sync.RWMutex
+	// 按symbol存储最新的多周期评分
+	latestConfluence map[string]*ConfluenceResult
+	timeSync         *TimeSync
+	server           *http.Server
+	mu               sync.RWMutex
 }
 
 // NewTrendAPI 创建新的API服务器
 func NewTrendAPI(port int, analyzer *TrendAnalyzer) *TrendAPI {
 	return &TrendAPI{
-		Port:          port,
-		analyzer:      analyzer,
-		latestResults: make(map[string]*TrendResult),
+		Port:             port,
+		analyzer:         analyzer,
+		latestResults:    make(map[string]*TrendResult),
+		latestConfluence: make(map[string]*ConfluenceResult),
 	}
 }
 
-// Start 启动API服务器
+// Start 启动API服务器，阻塞直到出错或被 Shutdown 关闭（此时返回 nil）
 func (api *TrendAPI) Start() error {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/api/trend/btc", api.handleTrendBTC)
-	mux.HandleFunc("/api/trend/eth", api.handleTrendETH)
+	// /api/trend/btc、/api/trend/eth 作为 /api/trend/{symbol} 的简写继续可用
+	mux.HandleFunc("/api/trend/", api.handleTrendSymbol)
+	mux.HandleFunc("/api/trend/history", api.handleTrendHistory)
+	mux.HandleFunc("/api/trends", api.handleTrends)
+	mux.HandleFunc("/api/time", api.handleTime)
+	mux.HandleFunc("/api/confluence", api.handleConfluence)
+	mux.HandleFunc("/api/confluence/", api.handleConfluenceSymbol)
+	mux.HandleFunc("/api/confluence/history", api.handleConfluenceHistory)
+	mux.HandleFunc("/api/events", api.handleEvents)
 
 	addr := fmt.Sprintf(":%d", api.Port)
 	log.Printf("API服务器启动在 http://localhost%s", addr)
 
-	return http.ListenAndServe(addr, corsMiddleware(mux))
+	server := &http.Server{Addr: addr, Handler: corsMiddleware(mux)}
+	api.mu.Lock()
+	api.server = server
+	api.mu.Unlock()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 停止接收新请求，等待进行中的请求完成，ctx 到期后强制关闭
+func (api *TrendAPI) Shutdown(ctx context.Context) error {
+	api.mu.RLock()
+	server := api.server
+	api.mu.RUnlock()
+	if server == nil {
+		return nil
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("关闭API服务器失败: %v", err)
+	}
+	return nil
 }
 
 // UpdateResults 更新最新的趋势结果
@@ -50,69 +89,89 @@ func (api *TrendAPI) UpdateResults(results []*TrendResult) {
 	}
 }
 
-// handleTrendBTC 处理获取BTC趋势的请求
-func (api *TrendAPI) handleTrendBTC(w http.ResponseWriter, r *http.Request) {
+// SetTimeSync 设置时间同步组件，用于 /api/time 输出时钟偏差
+func (api *TrendAPI) SetTimeSync(ts *TimeSync) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.timeSync = ts
+}
+
+// handleTime 返回本机与交易所的时间偏差
+//
+//	GET /api/time
+func (api *TrendAPI) handleTime(w http.ResponseWriter, r *http.Request) {
+	api.mu.RLock()
+	ts := api.timeSync
+	api.mu.RUnlock()
+	if ts == nil {
+		writeJSONError(w, http.StatusNotFound, "未启用时间同步")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ts.Status())
+}
+
+// latest 返回某个币种周期的最新结果
+func (api *TrendAPI) latest(symbol, interval string) (*TrendResult, bool) {
 	api.mu.RLock()
 	defer api.mu.RUnlock()
 
-	// 获取interval参数，默认为1h
-	interval := r.URL.Query().Get("interval")
-	if interval == "" {
-		interval = "1h"
-	}
+	result, ok := api.latestResults[fmt.Sprintf("%s_%s", symbol, interval)]
+	return result, ok
+}
 
-	// 获取BTC趋势
-	btcKey := fmt.Sprintf("BTCUSDT_%s", interval)
-	if btcResult, ok := api.latestResults[btcKey]; ok {
-		// 根据请求格式返回不同的响应
-		apiStatus := "unknown"
-		if btcResult.Status == BUYMACD {
-			apiStatus = "BUYMACD"
-		} else if btcResult.Status == SELLMACD {
-			apiStatus = "SELLMACD"
-		} else if btcResult.Status == RANGE {
-			apiStatus = "RANGE"
-		} else if btcResult.Status == "XBUYMID" {
-			apiStatus = "XBUYMID"
-		} else if btcResult.Status == "XSELLMID" {
-			apiStatus = "XSELLMID"
+// resolveSymbol 将路径中的币种解析为配置中的交易对。
+// 支持完整交易对（BTCUSDT）和省略 USDT 的简写（btc），返回交易对以及是否为简写
+func resolveSymbol(name string) (string, bool, bool) {
+	upper := strings.ToUpper(name)
+	for _, symbol := range config.Get().Symbols {
+		if symbol == upper {
+			return symbol, false, true
 		}
-
-		if r.URL.Query().Get("format") == "text" {
-			// 纯文本格式，适合Rainmeter
-			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "BTC Trend: %s", apiStatus)
-		} else {
-			// JSON格式
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"symbol":   "BTC",
-				"interval": btcResult.Interval,
-				"trend":    apiStatus,
-				"ema25":    btcResult.EMA25,
-				"ema50":    btcResult.EMA50,
-				"time":     btcResult.Time.Format("2006-01-02 15:04:05"),
-			})
+	}
+	for _, symbol := range config.Get().Symbols {
+		if symbol == upper+"USDT" {
+			return symbol, true, true
 		}
-	} else {
-		// 数据不可用
-		if r.URL.Query().Get("format") == "text" {
+	}
+	return "", false, false
+}
+
+// displayName 交易对的显示名称，去掉 USDT 后缀，如 BTCUSDT -> BTC
+func displayName(symbol string) string {
+	if base := strings.TrimSuffix(symbol, "USDT"); base != "" {
+		return base
+	}
+	return symbol
+}
+
+// apiStatus 返回对外展示的状态，无状态时为 unknown
+func apiStatus(result *TrendResult) string {
+	if result == nil || result.Status == "" {
+		return "unknown"
+	}
+	return string(result.Status)
+}
+
+// handleTrendSymbol 处理获取任意已配置币种趋势的请求
+//
+//	GET /api/trend/{symbol}?interval=1h&format=json|text
+func (api *TrendAPI) handleTrendSymbol(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/trend/"), "/")
+	textFormat := r.URL.Query().Get("format") == "text"
+
+	symbol, isAlias, ok := resolveSymbol(name)
+	if name == "" || strings.Contains(name, "/") || !ok {
+		if textFormat {
 			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "BTC Trend: unknown")
-		} else {
-			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "BTC Trend: unknown",
-			})
+			fmt.Fprintf(w, "未配置的币种: %s", name)
+			return
 		}
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("未配置的币种: %s", name))
+		return
 	}
-}
-
-// handleTrendETH 处理获取ETH趋势的请求
-func (api *TrendAPI) handleTrendETH(w http.ResponseWriter, r *http.Request) {
-	api.mu.RLock()
-	defer api.mu.RUnlock()
 
 	// 获取interval参数，默认为1h
 	interval := r.URL.Query().Get("interval")
@@ -120,53 +179,75 @@ func (api *TrendAPI) handleTrendETH(w http.ResponseWriter, r *http.Request) {
 		interval = "1h"
 	}
 
-	// 获取ETH趋势
-	ethKey := fmt.Sprintf("ETHUSDT_%s", interval)
-	if ethResult, ok := api.latestResults[ethKey]; ok {
-		// 根据请求格式返回不同的响应
-		apiStatus := "unknown"
-		if ethResult.Status == BUYMACD {
-			apiStatus = "BUYMACD"
-		} else if ethResult.Status == SELLMACD {
-			apiStatus = "SELLMACD"
-		} else if ethResult.Status == RANGE {
-			apiStatus = "RANGE"
-		} else if ethResult.Status == "XBUYMID" {
-			apiStatus = "XBUYMID"
-		} else if ethResult.Status == "XSELLMID" {
-			apiStatus = "XSELLMID"
-		}
-
-		if r.URL.Query().Get("format") == "text" {
-			// 纯文本格式，适合Rainmeter
-			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "ETH Trend: %s", apiStatus)
-		} else {
-			// JSON格式
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"symbol":   "ETH",
-				"interval": ethResult.Interval,
-				"trend":    apiStatus,
-				"ema25":    ethResult.EMA25,
-				"ema50":    ethResult.EMA50,
-				"time":     ethResult.Time.Format("2006-01-02 15:04:05"),
-			})
-		}
-	} else {
+	label := displayName(symbol)
+	result, ok := api.latest(symbol, interval)
+	if !ok {
 		// 数据不可用
-		if r.URL.Query().Get("format") == "text" {
+		if textFormat {
 			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "ETH Trend: unknown")
+			fmt.Fprintf(w, "%s Trend: unknown", label)
 		} else {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "ETH Trend: unknown",
-			})
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("%s Trend: unknown", label))
+		}
+		return
+	}
+
+	if textFormat {
+		// 纯文本格式，适合Rainmeter
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s Trend: %s", label, apiStatus(result))
+		return
+	}
+
+	// JSON格式；通过简写访问时 symbol 字段为简写，与旧接口保持一致
+	view := NewTrendResultView(result)
+	view.Trend = apiStatus(result)
+	if isAlias {
+		view.Symbol = label
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// handleTrends 返回所有已配置币种 × 周期的最新趋势矩阵
+//
+//	GET /api/trends?format=json|text
+func (api *TrendAPI) handleTrends(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Get()
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, symbol := range cfg.Symbols {
+			for _, interval := range cfg.Intervals {
+				result, _ := api.latest(symbol, interval)
+				fmt.Fprintf(w, "%s %s: %s\n", symbol, interval, apiStatus(result))
+			}
+		}
+		return
+	}
+
+	trends := make(map[string]map[string]*TrendResultView, len(cfg.Symbols))
+	for _, symbol := range cfg.Symbols {
+		row := make(map[string]*TrendResultView, len(cfg.Intervals))
+		for _, interval := range cfg.Intervals {
+			if result, ok := api.latest(symbol, interval); ok {
+				view := NewTrendResultView(result)
+				row[interval] = &view
+			} else {
+				row[interval] = nil
+			}
 		}
+		trends[symbol] = row
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbols":   cfg.Symbols,
+		"intervals": cfg.Intervals,
+		"trends":    trends,
+	})
 }
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 允许任意来源跨域，或者这里写你的前端地址，比如 http://localhost:3000
@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// BacktestOptions 回测参数
+type BacktestOptions struct {
+	Symbol   string
+	Interval string
+	// 单边手续费率，如 0.0004 表示 0.04%
+	Fee float64
+	// 单边滑点比例，买入按开盘价上浮、卖出按开盘价下浮
+	Slippage float64
+	// 是否在看空状态下做空，否则只做多
+	AllowShort bool
+	// 统计状态命中率时观察之后多少根K线的涨跌
+	Horizon int
+	// 每根K线分析时使用的历史根数，默认与实盘一致（499）
+	Window int
+}
+
+// BacktestTrade 一笔模拟交易
+type BacktestTrade struct {
+	Side       string      `json:"side"` // long / short
+	Status     TrendStatus `json:"status"`
+	EntryTime  time.Time   `json:"entry_time"`
+	ExitTime   time.Time   `json:"exit_time"`
+	EntryPrice float64     `json:"entry_price"`
+	ExitPrice  float64     `json:"exit_price"`
+	Bars       int         `json:"bars"`
+	Return     float64     `json:"return"` // 扣除手续费和滑点后的收益率
+}
+
+// BacktestStatusStats 某个状态出现后的表现。中性状态没有命中率
+type BacktestStatusStats struct {
+	Status    TrendStatus `json:"status"`
+	Signals   int         `json:"signals"` // 进入该状态的次数
+	Hits      int         `json:"hits"`
+	HitRate   *float64    `json:"hit_rate,omitempty"`
+	AvgReturn float64     `json:"avg_return"` // 之后 Horizon 根K线的平均涨跌幅
+}
+
+// BacktestReport 回测结果
+type BacktestReport struct {
+	Symbol      string                `json:"symbol"`
+	Interval    string                `json:"interval"`
+	Rule        string                `json:"rule"`
+	From        time.Time             `json:"from"`
+	To          time.Time             `json:"to"`
+	Bars        int                   `json:"bars"` // 参与回测的K线数，不含指标预热
+	Fee         float64               `json:"fee"`
+	Slippage    float64               `json:"slippage"`
+	Horizon     int                   `json:"horizon"`
+	TotalReturn float64               `json:"total_return"`
+	WinRate     float64               `json:"win_rate"`
+	MaxDrawdown float64               `json:"max_drawdown"`
+	Sharpe      float64               `json:"sharpe"` // 按K线收益率年化
+	Trades      []BacktestTrade       `json:"trades"`
+	Statuses    []BacktestStatusStats `json:"statuses"`
+}
+
+// RunBacktest 把历史K线逐根回放给与实时推送相同的 AnalyzeClosedKlines：第 i 根K线收盘时只能看到
+// 它及之前的 Window 根K线，规则的输入与实盘在该K线收盘时得到的完全一致，
+// 得出的状态在第 i+1 根开盘时按状态方向开平仓，没有未来数据。
+// 状态切换时先平旧仓再开新仓，回测结束时按最后收盘价平仓
+func RunBacktest(ctx context.Context, klines []KlineData, opts BacktestOptions) (*BacktestReport, error) {
+	if err := ValidateKlines(klines); err != nil {
+		return nil, fmt.Errorf("K线校验失败: %w", err)
+	}
+	if opts.Window <= 0 {
+		opts.Window = klineHistoryLimit
+	}
+	if opts.Horizon <= 0 {
+		opts.Horizon = 1
+	}
+
+	// 独立的分析器，不持久化
+	analyzer := NewTrendAnalyzer(nil)
+
+	start := GetMaxPeriod(analyzer.snapshot().indicators) - 1
+	if len(klines) < start+2 {
+		return nil, fmt.Errorf("K线数量不足: %d，至少需要 %d 根", len(klines), start+2)
+	}
+
+	statuses := make([]TrendStatus, len(klines))
+	var rule string
+	for i := start; i < len(klines); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		window := klines[max(0, i+1-opts.Window) : i+1]
+		res, err := analyzer.AnalyzeClosedKlines(ctx, opts.Symbol, opts.Interval, window)
+		if err != nil {
+			return nil, fmt.Errorf("分析第 %d 根K线失败: %w", i, err)
+		}
+		statuses[i] = res.Status
+		rule = res.Rule
+	}
+
+	report := &BacktestReport{
+		Symbol:   opts.Symbol,
+		Interval: opts.Interval,
+		Rule:     rule,
+		From:     time.UnixMilli(klines[start].OpenTime),
+		To:       time.UnixMilli(klines[len(klines)-1].CloseTime),
+		Bars:     len(klines) - start,
+		Fee:      opts.Fee,
+		Slippage: opts.Slippage,
+		Horizon:  opts.Horizon,
+		Trades:   make([]BacktestTrade, 0),
+	}
+	simulateTrades(report, klines[start:], statuses[start:], opts)
+	report.Statuses = statusStats(klines[start:], statuses[start:], opts.Horizon)
+	return report, nil
+}
+
+// simulateTrades 按状态方向模拟开平仓，并计算收益、胜率、最大回撤和夏普比率
+func simulateTrades(report *BacktestReport, klines []KlineData, statuses []TrendStatus, opts BacktestOptions) {
+	equity := 1.0 // 已实现的权益
+	var pos int   // 1 多 / -1 空 / 0 空仓
+	var entryPrice float64
+	var entryIndex int
+	var entryStatus TrendStatus
+
+	closePosition := func(i int, price float64) {
+		exit := price * (1 - float64(pos)*opts.Slippage)
+		growth := (1 + float64(pos)*(exit/entryPrice-1)) * (1 - opts.Fee)
+		equity *= growth
+		side := "long"
+		if pos < 0 {
+			side = "short"
+		}
+		report.Trades = append(report.Trades, BacktestTrade{
+			Side:       side,
+			Status:     entryStatus,
+			EntryTime:  time.UnixMilli(klines[entryIndex].OpenTime),
+			ExitTime:   time.UnixMilli(klines[i].OpenTime),
+			EntryPrice: entryPrice,
+			ExitPrice:  exit,
+			Bars:       i - entryIndex,
+			Return:     (1-opts.Fee)*growth - 1,
+		})
+		pos = 0
+	}
+
+	// 每根K线收盘时按持仓计算的权益，用于回撤和夏普比率
+	marks := make([]float64, 0, len(klines))
+	for i := range klines {
+		// 第 i-1 根收盘得出的状态在第 i 根开盘时执行
+		if i > 0 {
+			target := statuses[i-1].Direction()
+			if target < 0 && !opts.AllowShort {
+				target = 0
+			}
+			if target != pos {
+				if pos != 0 {
+					closePosition(i, klines[i].Open)
+				}
+				if target != 0 {
+					pos = target
+					entryPrice = klines[i].Open * (1 + float64(pos)*opts.Slippage)
+					entryIndex = i
+					entryStatus = statuses[i-1]
+					equity *= 1 - opts.Fee
+				}
+			}
+		}
+
+		mark := equity
+		if pos != 0 {
+			mark = equity * (1 + float64(pos)*(klines[i].Close/entryPrice-1))
+		}
+		marks = append(marks, mark)
+	}
+
+	if pos != 0 {
+		last := len(klines) - 1
+		closePosition(last, klines[last].Close)
+		trade := &report.Trades[len(report.Trades)-1]
+		trade.ExitTime = time.UnixMilli(klines[last].CloseTime)
+		trade.Bars++
+		marks[last] = equity
+	}
+
+	report.TotalReturn = equity - 1
+	wins := 0
+	for _, t := range report.Trades {
+		if t.Return > 0 {
+			wins++
+		}
+	}
+	if len(report.Trades) > 0 {
+		report.WinRate = float64(wins) / float64(len(report.Trades))
+	}
+	report.MaxDrawdown = maxDrawdown(marks)
+	report.Sharpe = sharpeRatio(marks, opts.Interval)
+}
+
+// statusStats 统计每个状态出现（由其他状态切换而来）后 horizon 根K线的涨跌和命中率
+func statusStats(klines []KlineData, statuses []TrendStatus, horizon int) []BacktestStatusStats {
+	var order []TrendStatus
+	stats := make(map[TrendStatus]*BacktestStatusStats)
+	for i, status := range statuses {
+		if i > 0 && status == statuses[i-1] {
+			continue
+		}
+		s, ok := stats[status]
+		if !ok {
+			s = &BacktestStatusStats{Status: status}
+			stats[status] = s
+			order = append(order, status)
+		}
+		if i+horizon >= len(klines) {
+			continue
+		}
+		fwd := klines[i+horizon].Close/klines[i].Close - 1
+		s.Signals++
+		s.AvgReturn += fwd
+		if dir := status.Direction(); dir != 0 && float64(dir)*fwd > 0 {
+			s.Hits++
+		}
+	}
+
+	result := make([]BacktestStatusStats, 0, len(order))
+	for _, status := range order {
+		s := stats[status]
+		if s.Signals > 0 {
+			s.AvgReturn /= float64(s.Signals)
+			if status.Direction() != 0 {
+				rate := float64(s.Hits) / float64(s.Signals)
+				s.HitRate = &rate
+			}
+		}
+		result = append(result, *s)
+	}
+	return result
+}
+
+// maxDrawdown 返回权益曲线从高点回落的最大比例
+func maxDrawdown(marks []float64) float64 {
+	var peak, worst float64
+	for _, m := range marks {
+		peak = max(peak, m)
+		if peak > 0 {
+			worst = max(worst, 1-m/peak)
+		}
+	}
+	return worst
+}
+
+// sharpeRatio 按每根K线的权益收益率计算年化夏普比率（无风险利率为 0）
+func sharpeRatio(marks []float64, interval string) float64 {
+	if len(marks) < 3 {
+		return 0
+	}
+	returns := make([]float64, len(marks)-1)
+	var mean float64
+	for i := 1; i < len(marks); i++ {
+		returns[i-1] = marks[i]/marks[i-1] - 1
+		mean += returns[i-1]
+	}
+	mean /= float64(len(returns))
+
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+	return mean / std * math.Sqrt(barsPerYear(interval))
+}
+
+// barsPerYear 每年的K线根数
+func barsPerYear(interval string) float64 {
+	year := 365 * 24 * time.Hour
+	switch interval {
+	case "1w":
+		return 52
+	case "1M":
+		return 12
+	}
+	if d, err := intervalDuration(interval); err == nil {
+		return float64(year) / float64(d)
+	}
+	return 365
+}
+
+// WriteText 以文本表格输出回测结果
+func (r *BacktestReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "回测\t%s %s（规则 %s）\n", r.Symbol, r.Interval, r.Rule)
+	fmt.Fprintf(tw, "区间\t%s ~ %s，%d 根K线\n", r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04"), r.Bars)
+	fmt.Fprintf(tw, "手续费/滑点\t%.4f%% / %.4f%%\n", r.Fee*100, r.Slippage*100)
+	fmt.Fprintf(tw, "总收益\t%.2f%%\n", r.TotalReturn*100)
+	fmt.Fprintf(tw, "交易次数\t%d\n", len(r.Trades))
+	fmt.Fprintf(tw, "胜率\t%.2f%%\n", r.WinRate*100)
+	fmt.Fprintf(tw, "最大回撤\t%.2f%%\n", r.MaxDrawdown*100)
+	fmt.Fprintf(tw, "夏普比率\t%.2f\n", r.Sharpe)
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "状态\t次数\t命中\t命中率\t之后%d根平均涨跌\n", r.Horizon)
+	for _, s := range r.Statuses {
+		rate := "-"
+		if s.HitRate != nil {
+			rate = fmt.Sprintf("%.2f%%", *s.HitRate*100)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%.3f%%\n", s.Status, s.Signals, s.Hits, rate, s.AvgReturn*100)
+	}
+	return tw.Flush()
+}
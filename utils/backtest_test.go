@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"math"
+	"testing"
+	"time"
+)
+
+// backtestKlines 按 [开盘价, 收盘价] 生成连续的 1h K线
+func backtestKlines(start time.Time, bars [][2]float64) []KlineData {
+	klines := make([]KlineData, len(bars))
+	for i, b := range bars {
+		open := start.Add(time.Duration(i) * time.Hour)
+		klines[i] = KlineData{
+			OpenTime:  open.UnixMilli(),
+			Open:      b[0],
+			High:      math.Max(b[0], b[1]),
+			Low:       math.Min(b[0], b[1]),
+			Close:     b[1],
+			Volume:    1,
+			CloseTime: open.Add(time.Hour).UnixMilli() - 1,
+		}
+	}
+	return klines
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// 共用的五根K线：涨 10%、跌 10%、再跌到 90、收在 95
+var backtestBars = [][2]float64{{100, 100}, {100, 110}, {110, 99}, {99, 90}, {90, 95}}
+
+func TestSimulateTrades(t *testing.T) {
+	klines := backtestKlines(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), backtestBars)
+
+	t.Run("long and short with fees", func(t *testing.T) {
+		// 第 0 根收盘看多 -> 第 1 根开盘 100 做多；第 2 根收盘看空 -> 第 3 根开盘 99 平多开空；回测结束按 95 平空
+		statuses := []TrendStatus{BUYMACD, BUYMACD, SELLMACD, SELLMACD, RANGE}
+		report := &BacktestReport{}
+		simulateTrades(report, klines, statuses, BacktestOptions{Interval: "1h", Fee: 0.001, AllowShort: true})
+
+		if len(report.Trades) != 2 {
+			t.Fatalf("got %d trades, want 2: %+v", len(report.Trades), report.Trades)
+		}
+		long, short := report.Trades[0], report.Trades[1]
+		// 多单：99/100 = 0.99，开平各扣 0.1% 手续费
+		if long.Side != "long" || long.EntryPrice != 100 || long.ExitPrice != 99 || long.Bars != 2 ||
+			!approx(long.Return, 0.99*0.999*0.999-1) {
+			t.Errorf("long trade = %+v, want 100 -> 99 over 2 bars, return %v", long, 0.99*0.999*0.999-1)
+		}
+		// 空单：1 + (99-95)/99 = 103/99，最后一根收盘平仓计入持仓根数
+		if short.Side != "short" || short.Status != SELLMACD || short.EntryPrice != 99 || short.ExitPrice != 95 ||
+			short.Bars != 2 || !short.ExitTime.Equal(time.UnixMilli(klines[4].CloseTime)) ||
+			!approx(short.Return, 103.0/99*0.999*0.999-1) {
+			t.Errorf("short trade = %+v, want 99 -> 95 over 2 bars, return %v", short, 103.0/99*0.999*0.999-1)
+		}
+		// 0.99 * 103/99 = 1.03，四次成交各扣一次手续费
+		if want := math.Pow(0.999, 4)*1.03 - 1; !approx(report.TotalReturn, want) {
+			t.Errorf("TotalReturn = %v, want %v", report.TotalReturn, want)
+		}
+		if report.WinRate != 0.5 {
+			t.Errorf("WinRate = %v, want 0.5", report.WinRate)
+		}
+		// 权益最高在第 1 根收盘（0.999 * 1.1），第 2 根收盘回落到 0.999 * 0.99，回撤 10%
+		if !approx(report.MaxDrawdown, 0.1) {
+			t.Errorf("MaxDrawdown = %v, want 0.1", report.MaxDrawdown)
+		}
+	})
+
+	t.Run("long only with slippage", func(t *testing.T) {
+		statuses := []TrendStatus{BUYMACD, BUYMACD, SELLMACD, SELLMACD, RANGE}
+		report := &BacktestReport{}
+		simulateTrades(report, klines, statuses, BacktestOptions{Interval: "1h", Slippage: 0.01})
+
+		// 买入 100 上浮 1% = 101，卖出 99 下浮 1% = 98.01；看空时只平仓不做空
+		if len(report.Trades) != 1 {
+			t.Fatalf("got %d trades, want 1: %+v", len(report.Trades), report.Trades)
+		}
+		trade := report.Trades[0]
+		if trade.EntryPrice != 101 || !approx(trade.ExitPrice, 98.01) || !approx(trade.Return, 98.01/101-1) {
+			t.Errorf("trade = %+v, want 101 -> 98.01", trade)
+		}
+		if !approx(report.TotalReturn, 98.01/101-1) || report.WinRate != 0 {
+			t.Errorf("TotalReturn = %v WinRate = %v", report.TotalReturn, report.WinRate)
+		}
+		// 最高 110/101，最低为平仓后的 98.01/101
+		if want := 1 - 98.01/110; !approx(report.MaxDrawdown, want) {
+			t.Errorf("MaxDrawdown = %v, want %v", report.MaxDrawdown, want)
+		}
+	})
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	for _, tt := range []struct {
+		marks []float64
+		want  float64
+	}{
+		{nil, 0},
+		{[]float64{1, 1.1, 1.2}, 0},
+		{[]float64{1, 1.2, 0.9, 1.1}, 0.25},
+		// 第二次回撤更深：1.2 -> 0.6
+		{[]float64{1, 1.2, 0.9, 1.3, 1.0, 1.2, 0.65}, 0.5},
+	} {
+		if got := maxDrawdown(tt.marks); !approx(got, tt.want) {
+			t.Errorf("maxDrawdown(%v) = %v, want %v", tt.marks, got, tt.want)
+		}
+	}
+}
+
+func TestSharpeRatio(t *testing.T) {
+	// 收益率 +10%、-10%、+10%：均值 1/30，样本标准差 1/(5√3)，每根 √3/6
+	marks := []float64{100, 110, 99, 108.9}
+	for _, tt := range []struct {
+		interval string
+		want     float64
+	}{
+		{"1d", math.Sqrt(3) / 6 * math.Sqrt(365)},
+		{"1h", math.Sqrt(3) / 6 * math.Sqrt(365*24)},
+		{"1w", math.Sqrt(3) / 6 * math.Sqrt(52)},
+		{"1M", math.Sqrt(3) / 6 * math.Sqrt(12)},
+	} {
+		if got := sharpeRatio(marks, tt.interval); !approx(got, tt.want) {
+			t.Errorf("sharpeRatio(%s) = %v, want %v", tt.interval, got, tt.want)
+		}
+	}
+	// 数据太少或没有波动时为 0
+	if got := sharpeRatio([]float64{1, 1.1}, "1h"); got != 0 {
+		t.Errorf("two marks: %v, want 0", got)
+	}
+	if got := sharpeRatio([]float64{1, 2, 4, 8}, "1h"); got != 0 {
+		t.Errorf("constant returns: %v, want 0", got)
+	}
+}
+
+// TestRunBacktestHandChecked 用按K线涨跌给出状态的表达式规则回放，交易和收益可以手算
+func TestRunBacktestHandChecked(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.KlineCache.Enabled = false
+	cfg.ExprRules = []config.ExprRuleSet{{
+		Name: "candle_color",
+		Cases: []config.ExprCase{
+			{Status: string(BUYMACD), When: "close > open"},
+			{Status: string(SELLMACD), When: "close < open"},
+		},
+	}}
+	cfg.RuleBindings = map[string]string{"1h": "candle_color"}
+	setTestConfig(t, cfg)
+
+	// 指标预热用平盘K线，之后接上共用的五根K线
+	warmup := GetMaxPeriod(NewIndicatorsFor(cfg)) - 1
+	bars := make([][2]float64, warmup, warmup+len(backtestBars))
+	for i := range bars {
+		bars[i] = [2]float64{100, 100}
+	}
+	klines := backtestKlines(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), append(bars, backtestBars...))
+
+	report, err := RunBacktest(context.Background(), klines, BacktestOptions{
+		Symbol: "BTCUSDT", Interval: "1h", Fee: 0.001, AllowShort: true,
+	})
+	if err != nil {
+		t.Fatalf("RunBacktest: %v", err)
+	}
+	if report.Rule != "candle_color" || report.Bars != len(backtestBars) ||
+		!report.From.Equal(time.UnixMilli(klines[warmup].OpenTime)) {
+		t.Fatalf("report rule=%s bars=%d from=%v", report.Rule, report.Bars, report.From)
+	}
+
+	// 状态 RANGE、BUY、SELL、SELL、BUY：第 2 根开盘 110 做多，第 3 根开盘 99 平多开空，最后按 95 平空
+	if len(report.Trades) != 2 {
+		t.Fatalf("got %d trades, want 2: %+v", len(report.Trades), report.Trades)
+	}
+	long, short := report.Trades[0], report.Trades[1]
+	if long.EntryPrice != 110 || long.ExitPrice != 99 || !approx(long.Return, 0.9*0.999*0.999-1) {
+		t.Errorf("long trade = %+v", long)
+	}
+	if short.EntryPrice != 99 || short.ExitPrice != 95 || !approx(short.Return, 103.0/99*0.999*0.999-1) {
+		t.Errorf("short trade = %+v", short)
+	}
+	if want := math.Pow(0.999, 4)*0.9*103/99 - 1; !approx(report.TotalReturn, want) {
+		t.Errorf("TotalReturn = %v, want %v", report.TotalReturn, want)
+	}
+}
+
+// TestRunBacktestMatchesLive 回测每根K线的状态与实时推送、轮询在该K线收盘后得到的 Status 一致
+func TestRunBacktestMatchesLive(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.KlineCache.Enabled = false
+	cfg.RuleBindings = map[string]string{"1h": RuleMACDXStrong}
+	setTestConfig(t, cfg)
+
+	klines := fixtureKlines(260, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	opts := BacktestOptions{Symbol: "BTCUSDT", Interval: "1h", Fee: 0.0004, AllowShort: true, Window: 200}
+	report, err := RunBacktest(context.Background(), klines, opts)
+	if err != nil {
+		t.Fatalf("RunBacktest: %v", err)
+	}
+
+	a := NewTrendAnalyzer(nil)
+	start := len(klines) - report.Bars
+	statuses := make([]TrendStatus, len(klines))
+	for i := start; i < len(klines); i++ {
+		window := klines[max(0, i+1-opts.Window) : i+1]
+		stream, err := a.AnalyzeClosedKlines(context.Background(), "BTCUSDT", "1h", window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses[i] = stream.Status
+		if i+1 == len(klines) {
+			continue
+		}
+		// 轮询时缓冲区末尾多一根未收盘K线
+		forming := klines[max(0, i+2-opts.Window-1) : i+2]
+		a.SetClock(func() time.Time { return time.UnixMilli(klines[i+1].OpenTime + 1) })
+		poll, err := a.AnalyzeKlines(context.Background(), "BTCUSDT", "1h", forming)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if poll.Status != stream.Status {
+			t.Fatalf("bar %d: poll %s, stream %s", i, poll.Status, stream.Status)
+		}
+	}
+
+	want := &BacktestReport{}
+	simulateTrades(want, klines[start:], statuses[start:], opts)
+	if len(want.Trades) == 0 {
+		t.Fatal("fixture produced no trades")
+	}
+	if len(report.Trades) != len(want.Trades) || !approx(report.TotalReturn, want.TotalReturn) {
+		t.Fatalf("backtest %d trades return %v, live statuses give %d trades return %v",
+			len(report.Trades), report.TotalReturn, len(want.Trades), want.TotalReturn)
+	}
+	for i := range want.Trades {
+		if report.Trades[i] != want.Trades[i] {
+			t.Fatalf("trade %d = %+v, want %+v", i, report.Trades[i], want.Trades[i])
+		}
+	}
+}
@@ -1,21 +1,53 @@
 package utils
 
 import (
+	"context"
 	"crypto_trend_monitor/config"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log"
 	"net/http"
-	"net/url"
-	"strconv"
 	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// 币安各市场的K线接口
+const (
+	BinanceSpotBaseURL   = "https://api.binance.com"
+	BinanceSpotEndpoint  = "/api/v3/klines"
+	BinanceCoinMBaseURL  = "https://dapi.binance.com"
+	BinanceCoinMEndpoint = "/dapi/v1/klines"
 )
 
-// BinanceClient 币安API客户端
+func init() {
+	RegisterKlineProvider("binance_futures", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		// 按传入的配置创建：热加载时新配置尚未发布
+		return newBinanceMarketClient(cfg, "binance_futures", source.BaseURL, cfg.APIBaseURL, cfg.KlineEndpoint,
+			klineWeight)
+	})
+	RegisterKlineProvider("binance_spot", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		return newBinanceMarketClient(cfg, "binance_spot", source.BaseURL, BinanceSpotBaseURL, BinanceSpotEndpoint,
+			func(int) int { return 2 })
+	})
+	RegisterKlineProvider("binance_coinm", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		return newBinanceMarketClient(cfg, "binance_coinm", source.BaseURL, BinanceCoinMBaseURL, BinanceCoinMEndpoint,
+			klineWeight)
+	})
+}
+
+// BinanceClient 币安API客户端，默认为 U 本位合约，现货和币本位合约只是地址、接口和权重不同
 type BinanceClient struct {
-	BaseURL    string
+	BaseURL       string
+	KlineEndpoint string
+	// HTTPClient 按配置的代理创建一次，所有请求复用同一个连接池
 	HTTPClient *http.Client
-	ProxyURL   string
+	Limiter    *WeightLimiter
+	MaxRetries int
+
+	name   string
+	weight func(limit int) int
 }
 
 // KlineData K线数据结构
@@ -33,104 +65,125 @@ type KlineData struct {
 	TakerBuyQuoteAssetVolume float64
 }
 
-// NewBinanceClient 创建一个新的币安客户端
-func NewBinanceClient() *BinanceClient {
-	return &BinanceClient{
-		BaseURL: config.GlobalConfig.APIBaseURL,
-		HTTPClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		ProxyURL: config.GlobalConfig.ProxyURL,
+// NewBinanceClient 创建一个新的币安 U 本位合约客户端
+func NewBinanceClient() (*BinanceClient, error) {
+	cfg := config.Get()
+	return newBinanceMarketClient(cfg, "binance_futures", cfg.APIBaseURL, "", cfg.KlineEndpoint, klineWeight)
+}
+
+// newBinanceMarketClient 创建币安其他市场的客户端，各市场的权重分别统计
+func newBinanceMarketClient(cfg *config.Config, name, baseURL, defaultBaseURL, endpoint string, weight func(int) int) (*BinanceClient, error) {
+	if baseURL == "" {
+		baseURL = defaultBaseURL
 	}
+	client, err := newProxyHTTPClient(cfg.ProxyURL, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &BinanceClient{
+		BaseURL:       baseURL,
+		KlineEndpoint: endpoint,
+		HTTPClient:    client,
+		Limiter:       SharedWeightLimiter(name, cfg.RateLimit.WeightBudget),
+		MaxRetries:    cfg.RateLimit.MaxRetries,
+		name:          name,
+		weight:        weight,
+	}, nil
 }
 
-// GetKlines 获取K线数据
-func (c *BinanceClient) GetKlines(symbol, interval string, limit int) ([]KlineData, error) {
-	urls := fmt.Sprintf("%s%s?symbol=%s&interval=%s&limit=%d",
-		c.BaseURL, config.GlobalConfig.KlineEndpoint, symbol, interval, limit)
+// Name 提供方名称
+func (c *BinanceClient) Name() string {
+	return c.name
+}
 
-	proxyURL, _ := url.Parse(c.ProxyURL)
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
-	}
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   c.HTTPClient.Timeout,
+// GetKlines 获取最近的 limit 根K线数据
+func (c *BinanceClient) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	return c.getKlines(ctx, symbol, interval, 0, limit)
+}
+
+// GetKlinesSince 获取开盘时间不早于 startTime（毫秒）的K线，最多 limit 根
+func (c *BinanceClient) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	return c.getKlines(ctx, symbol, interval, startTime, limit)
+}
+
+func (c *BinanceClient) getKlines(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	urls := fmt.Sprintf("%s%s?symbol=%s&interval=%s&limit=%d",
+		c.BaseURL, c.KlineEndpoint, symbol, interval, limit)
+	if startTime > 0 {
+		urls += fmt.Sprintf("&startTime=%d", startTime)
 	}
 
-	var resp *http.Response
-	var err error
-	maxRetries := 3
-	retryCount := 0
-	retryDelay := 2 * time.Second
+	weight := c.weight(limit)
+	b := &backoff.Backoff{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: true}
 
-	for retryCount < maxRetries {
-		resp, err = client.Get(urls)
-		if err == nil && resp.StatusCode == http.StatusOK {
+	var body []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		body, retry, err = c.do(ctx, c.HTTPClient, urls, weight, symbol)
+		if err == nil {
 			break
 		}
-
-		if resp != nil {
-			resp.Body.Close()
+		if !retry || attempt >= c.MaxRetries || ctx.Err() != nil {
+			if attempt > 0 {
+				return nil, fmt.Errorf("请求K线数据失败(已重试%d次): %w", attempt, err)
+			}
+			return nil, err
 		}
 
-		retryCount++
-		if retryCount >= maxRetries {
-			if err != nil {
-				return nil, fmt.Errorf("请求K线数据失败(已重试%d次): %v", maxRetries, err)
-			}
-			return nil, fmt.Errorf("API返回错误状态码(已重试%d次): %d", maxRetries, resp.StatusCode)
+		// 429 已由限速器按 Retry-After 暂停，其余错误按指数退避（带抖动）等待
+		var delay time.Duration
+		var rateErr *RateLimitError
+		if !errors.As(err, &rateErr) {
+			delay = b.Duration()
+		}
+		log.Printf("请求失败: %v，%v 后进行第%d次重试...", err, delay.Round(time.Millisecond), attempt+1)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
 		}
+	}
+
+	return parseBinanceKlines(body)
+}
 
-		fmt.Printf("请求失败，%d秒后进行第%d次重试...\n", retryDelay/time.Second, retryCount+1)
-		time.Sleep(retryDelay)
+// do 在限速器允许后发出一次请求，返回响应内容以及失败时是否可以重试
+func (c *BinanceClient) do(ctx context.Context, client *http.Client, urls string, weight int, symbol string) ([]byte, bool, error) {
+	if err := c.Limiter.Wait(ctx, weight); err != nil {
+		return nil, false, err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urls, nil)
 	if err != nil {
-		return nil, fmt.Errorf("读取响应内容失败: %v", err)
+		return nil, false, fmt.Errorf("创建请求失败: %v", err)
 	}
-
-	var rawKlines [][]interface{}
-	if err := json.Unmarshal(body, &rawKlines); err != nil {
-		return nil, fmt.Errorf("解析K线数据失败: %v", err)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("请求K线数据失败: %w", err)
 	}
+	defer resp.Body.Close()
+	c.Limiter.Observe(resp.Header)
 
-	klines := make([]KlineData, 0, len(rawKlines))
-	for _, k := range rawKlines {
-		if len(k) < 11 {
-			return nil, fmt.Errorf("K线数据格式错误")
-		}
-
-		openTime, _ := k[0].(float64)
-		open, _ := strconv.ParseFloat(k[1].(string), 64)
-		high, _ := strconv.ParseFloat(k[2].(string), 64)
-		low, _ := strconv.ParseFloat(k[3].(string), 64)
-		close, _ := strconv.ParseFloat(k[4].(string), 64)
-		volume, _ := strconv.ParseFloat(k[5].(string), 64)
-		closeTime, _ := k[6].(float64)
-		quoteVolume, _ := strconv.ParseFloat(k[7].(string), 64)
-		numTrades, _ := k[8].(float64)
-		takerBuyBaseVolume, _ := strconv.ParseFloat(k[9].(string), 64)
-		takerBuyQuoteVolume, _ := strconv.ParseFloat(k[10].(string), 64)
-
-		klines = append(klines, KlineData{
-			OpenTime:                 int64(openTime),
-			Open:                     open,
-			High:                     high,
-			Low:                      low,
-			Close:                    close,
-			Volume:                   volume,
-			CloseTime:                int64(closeTime),
-			QuoteAssetVolume:         quoteVolume,
-			NumberOfTrades:           int64(numTrades),
-			TakerBuyBaseAssetVolume:  takerBuyBaseVolume,
-			TakerBuyQuoteAssetVolume: takerBuyQuoteVolume,
-		})
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("读取响应内容失败: %v", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, false, nil
 	}
 
-	return klines, nil
+	retryAfter := parseRetryAfter(resp.Header, time.Minute)
+	apiErr := parseAPIError(resp.StatusCode, body, symbol, retryAfter)
+	switch resp.StatusCode {
+	case http.StatusTeapot:
+		// 被封禁时不再重试，避免延长封禁时间
+		c.Limiter.Pause(retryAfter)
+		log.Printf("⚠️ %v，暂停所有请求", apiErr)
+		return nil, false, apiErr
+	case http.StatusTooManyRequests:
+		c.Limiter.Pause(retryAfter)
+		return nil, true, apiErr
+	}
+	return nil, resp.StatusCode >= http.StatusInternalServerError, apiErr
 }
 
 // ExtractClosePrices 从K线数据中提取收盘价
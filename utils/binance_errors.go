@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 币安错误码
+const (
+	binanceCodeInvalidSymbol = -1121
+)
+
+// APIError 币安返回的其他错误响应
+type APIError struct {
+	StatusCode int
+	Code       int
+	Msg        string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API返回错误状态码 %d: code=%d, msg=%s", e.StatusCode, e.Code, e.Msg)
+}
+
+// RateLimitError 请求超过频率限制（HTTP 429），RetryAfter 内不应再请求
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("请求超过频率限制，需等待 %v", e.RetryAfter)
+}
+
+// BannedError IP 因持续超限被封禁（HTTP 418），封禁期间的请求都会被拒绝
+type BannedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BannedError) Error() string {
+	return fmt.Sprintf("IP 已被币安封禁，需等待 %v", e.RetryAfter)
+}
+
+// InvalidSymbolError 交易对不存在
+type InvalidSymbolError struct {
+	Symbol string
+}
+
+func (e *InvalidSymbolError) Error() string {
+	return fmt.Sprintf("无效的交易对: %s", e.Symbol)
+}
+
+// parseAPIError 将非 200 响应转换为对应的错误类型
+func parseAPIError(statusCode int, body []byte, symbol string, retryAfter time.Duration) error {
+	switch statusCode {
+	case 418:
+		return &BannedError{RetryAfter: retryAfter}
+	case 429:
+		return &RateLimitError{RetryAfter: retryAfter}
+	}
+
+	var payload struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	json.Unmarshal(body, &payload)
+	if payload.Code == binanceCodeInvalidSymbol {
+		return &InvalidSymbolError{Symbol: symbol}
+	}
+	return &APIError{StatusCode: statusCode, Code: payload.Code, Msg: payload.Msg}
+}
@@ -40,3 +40,24 @@ func CalculateMA(data []float64, period int) float64 {
 	}
 	return sum / float64(period)
 }
+
+// CalculateMASeries 计算简单移动平均线序列，长度与 data 相同。
+// 前 period-1 个点数据不足，取已有数据的均值。
+func CalculateMASeries(data []float64, period int) []float64 {
+	if period <= 0 || len(data) == 0 {
+		return nil
+	}
+
+	ma := make([]float64, len(data))
+	sum := 0.0
+	for i, v := range data {
+		sum += v
+		if i >= period {
+			sum -= data[i-period]
+			ma[i] = sum / float64(period)
+		} else {
+			ma[i] = sum / float64(i+1)
+		}
+	}
+	return ma
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfluenceLabel 多周期共振的综合标签
+type ConfluenceLabel string
+
+const (
+	STRONG_BULL ConfluenceLabel = "STRONG_BULL"
+	BULL        ConfluenceLabel = "BULL"
+	MIXED       ConfluenceLabel = "MIXED"
+	BEAR        ConfluenceLabel = "BEAR"
+	STRONG_BEAR ConfluenceLabel = "STRONG_BEAR"
+)
+
+// ConfluenceComponent 参与评分的单个周期
+type ConfluenceComponent struct {
+	Interval  string      `json:"interval"`
+	Status    TrendStatus `json:"status"`
+	Direction int         `json:"direction"`
+	Weight    float64     `json:"weight"`
+}
+
+// ConfluenceResult 某个币种的多周期共振评分
+type ConfluenceResult struct {
+	Symbol string
+	// 各周期方向的加权平均，-1（全部看空）~ 1（全部看多），只按已有结果的周期计算
+	Score float64
+	Label ConfluenceLabel
+	// 被高周期否决时为 true，VetoBy 为否决的周期
+	Vetoed bool
+	VetoBy string
+	// 已有结果的周期权重占全部权重的比例
+	Coverage   float64
+	Components []ConfluenceComponent
+	Time       time.Time
+}
+
+// ComputeConfluence 按配置的权重汇总币种各周期的最新结果，results 的 key 为周期。
+// 周期按 cfg.Intervals 的顺序参与，没有任何结果时返回 nil
+func ComputeConfluence(symbol string, results map[string]*TrendResult, cfg *config.Config) *ConfluenceResult {
+	cc := cfg.Confluence
+	res := &ConfluenceResult{Symbol: symbol, Label: MIXED, Time: time.Now()}
+
+	var total, covered, sum float64
+	for _, interval := range cfg.Intervals {
+		weight := cc.Weight(interval)
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		r, ok := results[interval]
+		if !ok || r == nil {
+			continue
+		}
+		dir := r.Status.Direction()
+		covered += weight
+		sum += weight * float64(dir)
+		res.Components = append(res.Components, ConfluenceComponent{
+			Interval:  interval,
+			Status:    r.Status,
+			Direction: dir,
+			Weight:    weight,
+		})
+	}
+	if covered == 0 {
+		return nil
+	}
+	res.Score = sum / covered
+	res.Coverage = covered / total
+
+	switch abs := math.Abs(res.Score); {
+	case abs >= cc.StrongThreshold && res.Score > 0:
+		res.Label = STRONG_BULL
+	case abs >= cc.StrongThreshold:
+		res.Label = STRONG_BEAR
+	case abs >= cc.Threshold && res.Score > 0:
+		res.Label = BULL
+	case abs >= cc.Threshold:
+		res.Label = BEAR
+	}
+
+	// 高周期否决：大周期方向相反时不给出方向性的标签
+	if res.Label != MIXED {
+		for _, interval := range cc.VetoIntervals {
+			r, ok := results[interval]
+			if !ok || r == nil {
+				continue
+			}
+			if dir := r.Status.Direction(); dir != 0 && float64(dir)*res.Score < 0 {
+				res.Label = MIXED
+				res.Vetoed = true
+				res.VetoBy = interval
+				break
+			}
+		}
+	}
+	return res
+}
+
+// String 输出评分摘要，如 "STRONG_BULL +0.75（5m=BUYMACD 1h=RANGE ...）"
+func (r *ConfluenceResult) String() string {
+	parts := make([]string, len(r.Components))
+	for i, c := range r.Components {
+		parts[i] = fmt.Sprintf("%s=%s", c.Interval, c.Status)
+	}
+	s := fmt.Sprintf("%s %+.2f（%s）", r.Label, r.Score, strings.Join(parts, " "))
+	if r.Vetoed {
+		s += fmt.Sprintf("，被 %s 否决", r.VetoBy)
+	}
+	if r.Coverage < 1 {
+		s += fmt.Sprintf("，覆盖 %.0f%%", r.Coverage*100)
+	}
+	return s
+}
+
+// ConfluenceStore 可以保存多周期共振评分的存储，TrendStore 的实现可选择实现
+type ConfluenceStore interface {
+	// SaveConfluence 保存一次评分
+	SaveConfluence(ctx context.Context, result *ConfluenceResult) error
+	// QueryConfluence 按时间倒序查询币种的历史评分，From/To 为零值时不限制
+	QueryConfluence(symbol string, from, to time.Time, limit int) ([]*ConfluenceResult, error)
+}
+
+// ConfluenceChanged 某个币种多周期评分的标签发生变化
+type ConfluenceChanged struct {
+	Symbol string
+	From   ConfluenceLabel
+	To     ConfluenceLabel
+	// 变化后的评分
+	Result *ConfluenceResult
+	// 触发本次评分的K线开盘时间，即本次更新中该币种最新一根K线，同一根K线重复分析时不变
+	OpenTime time.Time
+}
+
+// String 输出事件摘要，如 "BTCUSDT 多周期评分: MIXED -> BULL（+0.45）"
+func (e *ConfluenceChanged) String() string {
+	return fmt.Sprintf("%s 多周期评分: %s -> %s（%+.2f）", e.Symbol, e.From, e.To, e.Result.Score)
+}
+
+// confluenceTracker 保存各币种每个周期的最新结果，任一周期更新时重新计算该币种的评分
+type confluenceTracker struct {
+	mu     sync.Mutex
+	latest map[string]map[string]*TrendResult // symbol -> interval -> result
+	labels map[string]ConfluenceLabel         // symbol -> 最近一次评分的标签
+}
+
+// update 记录新的结果，返回受影响币种的评分，按配置中的币种顺序排列
+func (t *confluenceTracker) update(results []*TrendResult, cfg *config.Config) []*ConfluenceResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.latest == nil {
+		t.latest = make(map[string]map[string]*TrendResult)
+	}
+
+	changed := make(map[string]bool)
+	for _, r := range results {
+		row, ok := t.latest[r.Symbol]
+		if !ok {
+			row = make(map[string]*TrendResult)
+			t.latest[r.Symbol] = row
+		}
+		row[r.Interval] = r
+		changed[r.Symbol] = true
+	}
+
+	var out []*ConfluenceResult
+	for _, symbol := range cfg.Symbols {
+		if !changed[symbol] {
+			continue
+		}
+		if res := ComputeConfluence(symbol, t.latest[symbol], cfg); res != nil {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// UpdateConfluence 用新的分析结果更新多周期共振评分并保存，返回受影响币种的评分；未启用时返回 nil。
+// 保存失败只记录日志，不影响评分结果
+func (a *TrendAnalyzer) UpdateConfluence(ctx context.Context, results []*TrendResult) []*ConfluenceResult {
+	cfg := config.Get()
+	if !cfg.Confluence.Enabled || len(results) == 0 {
+		return nil
+	}
+
+	confluence := a.confluence.update(results, cfg)
+	store, _ := a.store.(ConfluenceStore)
+	// 先与上一次的标签比较，再保存本次评分
+	changes := a.confluence.changes(store, confluence)
+	if store != nil {
+		for _, res := range confluence {
+			err := store.SaveConfluence(ctx, res)
+			if err != nil && !errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil {
+				log.Printf("保存 %s 多周期评分失败: %v", res.Symbol, err)
+			}
+		}
+	}
+
+	a.mu.RLock()
+	handlers := a.confluenceHandlers
+	a.mu.RUnlock()
+	for _, event := range changes {
+		for _, r := range results {
+			if r.Symbol == event.Symbol && r.OpenTime.After(event.OpenTime) {
+				event.OpenTime = r.OpenTime
+			}
+		}
+		for _, fn := range handlers {
+			fn(ctx, event)
+		}
+	}
+	return confluence
+}
+
+// changes 返回标签与上一次不同的评分。币种首次出现时从存储读取上一次的评分，
+// 因此重启后不会重复产生已发生过的变化；没有历史时以本次评分为起点，不产生事件
+func (t *confluenceTracker) changes(store ConfluenceStore, results []*ConfluenceResult) []*ConfluenceChanged {
+	var out []*ConfluenceChanged
+	for _, res := range results {
+		t.mu.Lock()
+		prev, ok := t.labels[res.Symbol]
+		t.mu.Unlock()
+		if !ok && store != nil {
+			history, err := store.QueryConfluence(res.Symbol, time.Time{}, time.Time{}, 1)
+			if err == nil && len(history) > 0 {
+				prev, ok = history[0].Label, true
+			}
+		}
+
+		t.mu.Lock()
+		if t.labels == nil {
+			t.labels = make(map[string]ConfluenceLabel)
+		}
+		t.labels[res.Symbol] = res.Label
+		t.mu.Unlock()
+
+		if ok && prev != res.Label {
+			out = append(out, &ConfluenceChanged{Symbol: res.Symbol, From: prev, To: res.Label, Result: res})
+		}
+	}
+	return out
+}
+
+// OnConfluenceChanged 注册多周期评分标签变化的回调，在分析所在的 goroutine 中同步调用，耗时的处理应自行异步执行
+func (a *TrendAnalyzer) OnConfluenceChanged(fn func(ctx context.Context, event *ConfluenceChanged)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.confluenceHandlers = append(a.confluenceHandlers, fn)
+}
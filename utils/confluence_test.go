@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"testing"
+)
+
+func TestComputeConfluence(t *testing.T) {
+	base := config.ConfluenceConfig{
+		Enabled:         true,
+		Weights:         map[string]float64{"15m": 1, "1h": 2, "4h": 3, "1d": 4},
+		Threshold:       0.2,
+		StrongThreshold: 0.6,
+		VetoIntervals:   []string{"1d"},
+	}
+	tests := []struct {
+		name     string
+		weights  map[string]float64 // 非 nil 时替换 base.Weights
+		statuses map[string]TrendStatus
+		score    float64
+		coverage float64
+		label    ConfluenceLabel
+		vetoBy   string
+	}{
+		{name: "all bullish", statuses: map[string]TrendStatus{"15m": BUYMACD, "1h": XBUYMID, "4h": BUYMACD, "1d": BUYMACD},
+			score: 1, coverage: 1, label: STRONG_BULL},
+		{name: "all bearish", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": SELLMACD, "4h": XSELLMID, "1d": SELLMACD},
+			score: -1, coverage: 1, label: STRONG_BEAR},
+		// (1 + 2 + 4) / 10
+		{name: "range counts as zero", statuses: map[string]TrendStatus{"15m": BUYMACD, "1h": BUYMACD, "4h": RANGE, "1d": BUYMACD},
+			score: 0.7, coverage: 1, label: STRONG_BULL},
+		// 得分只按已有结果的周期计算：(-1 + 2) / 3，覆盖 3 / 10
+		{name: "partial coverage", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": BUYMACD},
+			score: 1.0 / 3, coverage: 0.3, label: BULL},
+		{name: "partial bearish", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": RANGE},
+			score: -1.0 / 3, coverage: 0.3, label: BEAR},
+		// 1 / (1 + 3 + 4)
+		{name: "below threshold", statuses: map[string]TrendStatus{"15m": BUYMACD, "4h": RANGE, "1d": RANGE},
+			score: 0.125, coverage: 0.8, label: MIXED},
+		// 阈值本身属于较强的一档：1 / 5 = 0.2，3 / 5 = 0.6
+		{name: "at threshold", statuses: map[string]TrendStatus{"15m": BUYMACD, "1d": RANGE},
+			score: 0.2, coverage: 0.5, label: BULL},
+		{name: "at strong threshold", statuses: map[string]TrendStatus{"1h": RANGE, "4h": SELLMACD},
+			score: -0.6, coverage: 0.5, label: STRONG_BEAR},
+		// 权重为 0 的周期不参与评分和覆盖率
+		{name: "zero weight ignored", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": BUYMACD},
+			weights: map[string]float64{"15m": 0, "1h": 2, "4h": 3, "1d": 4}, score: 1, coverage: 2.0 / 9, label: STRONG_BULL},
+		// 未配置权重的周期权重为 1：(1 - 1) / 2
+		{name: "default weight", statuses: map[string]TrendStatus{"15m": BUYMACD, "1d": SELLMACD},
+			weights: map[string]float64{}, score: 0, coverage: 0.5, label: MIXED},
+		// (1 + 2 + 3 - 4) / 10 = 0.2 为 BULL，1d 看空否决
+		{name: "higher timeframe veto", statuses: map[string]TrendStatus{"15m": BUYMACD, "1h": BUYMACD, "4h": BUYMACD, "1d": SELLMACD},
+			score: 0.2, coverage: 1, label: MIXED, vetoBy: "1d"},
+		// 否决周期不参与评分时仍然可以否决
+		{name: "veto without weight", statuses: map[string]TrendStatus{"15m": SELLMACD, "4h": SELLMACD, "1d": BUYMACD},
+			weights: map[string]float64{"15m": 1, "1h": 2, "4h": 3, "1d": 0}, score: -1, coverage: 4.0 / 6, label: MIXED, vetoBy: "1d"},
+		{name: "veto agrees", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": SELLMACD, "1d": SELLMACD},
+			score: -1, coverage: 0.7, label: STRONG_BEAR},
+		{name: "veto range", statuses: map[string]TrendStatus{"15m": SELLMACD, "1h": SELLMACD, "4h": SELLMACD, "1d": RANGE},
+			score: -0.6, coverage: 1, label: STRONG_BEAR},
+		// 本来就是 MIXED 时不算否决：(2 + 3 - 4) / 10
+		{name: "no veto when mixed", statuses: map[string]TrendStatus{"15m": RANGE, "1h": BUYMACD, "4h": BUYMACD, "1d": SELLMACD},
+			score: 0.1, coverage: 1, label: MIXED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Intervals = []string{"15m", "1h", "4h", "1d"}
+			cfg.Confluence = base
+			if tt.weights != nil {
+				cfg.Confluence.Weights = tt.weights
+			}
+			results := make(map[string]*TrendResult)
+			for interval, status := range tt.statuses {
+				results[interval] = &TrendResult{Symbol: "BTCUSDT", Interval: interval, Status: status}
+			}
+
+			res := ComputeConfluence("BTCUSDT", results, cfg)
+			if res == nil {
+				t.Fatal("ComputeConfluence returned nil")
+			}
+			if !approx(res.Score, tt.score) || !approx(res.Coverage, tt.coverage) || res.Label != tt.label {
+				t.Errorf("got %s score %v coverage %v, want %s score %v coverage %v",
+					res.Label, res.Score, res.Coverage, tt.label, tt.score, tt.coverage)
+			}
+			if res.Vetoed != (tt.vetoBy != "") || res.VetoBy != tt.vetoBy {
+				t.Errorf("vetoed = %v by %q, want %q", res.Vetoed, res.VetoBy, tt.vetoBy)
+			}
+			// 组成部分按 cfg.Intervals 的顺序排列，只包含有结果且权重大于 0 的周期
+			want := 0
+			for _, interval := range cfg.Intervals {
+				if _, ok := tt.statuses[interval]; ok && cfg.Confluence.Weight(interval) > 0 {
+					want++
+				}
+			}
+			if len(res.Components) != want {
+				t.Errorf("got %d components, want %d: %+v", len(res.Components), want, res.Components)
+			}
+			prev := -1
+			for _, c := range res.Components {
+				idx := -1
+				for i, interval := range cfg.Intervals {
+					if interval == c.Interval {
+						idx = i
+					}
+				}
+				if idx <= prev || c.Status != tt.statuses[c.Interval] || c.Direction != c.Status.Direction() ||
+					c.Weight != cfg.Confluence.Weight(c.Interval) || c.Weight <= 0 {
+					t.Errorf("unexpected component %+v in %+v", c, res.Components)
+				}
+				prev = idx
+			}
+		})
+	}
+}
+
+func TestComputeConfluenceNoResults(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Intervals = []string{"1h", "4h"}
+	cfg.Confluence.Weights = map[string]float64{"1h": 1, "4h": 0}
+
+	// 没有结果，或只有不参与评分的周期有结果
+	for _, results := range []map[string]*TrendResult{
+		nil,
+		{"1h": nil},
+		{"4h": {Symbol: "BTCUSDT", Interval: "4h", Status: BUYMACD}},
+		{"1d": {Symbol: "BTCUSDT", Interval: "1d", Status: BUYMACD}},
+	} {
+		if res := ComputeConfluence("BTCUSDT", results, cfg); res != nil {
+			t.Errorf("ComputeConfluence(%v) = %v, want nil", results, res)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 规则表达式语言
+//
+// 语法示例：
+//
+//	close > ema(25) && close > ma(60) && dif > 0
+//	hist > 0 && hist > hist[-2]
+//	(close - ma(60)) / ma(60) > 0.01 || !(dif(12,26,9) < 0)
+//
+// 序列：close/open/high/low/volume、ema(n)、ma(n)、dif/dea/hist（默认参数 6,13,5，
+// 也可写成 dif(fast,slow,signal)）。序列后可跟 [-k] 取倒数第 k 个值，省略时等同 [-1]，
+// 即最近一根已收盘的 K 线（closed 模式）。
+// 运算符：+ - * /、> >= < <= == !=、&& || !，支持括号。
+
+// exprKind 表达式结果类型
+type exprKind int
+
+const (
+	kindNumber exprKind = iota
+	kindBool
+)
+
+func (k exprKind) String() string {
+	if k == kindBool {
+		return "布尔"
+	}
+	return "数值"
+}
+
+// ---------- 词法分析 ----------
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokNumber
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, strings.ToLower(src[start:i]), start})
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		default:
+			if i+1 < len(src) {
+				two := src[i : i+2]
+				switch two {
+				case "&&", "||", ">=", "<=", "==", "!=":
+					tokens = append(tokens, token{tokOp, two, i})
+					i += 2
+					continue
+				}
+			}
+			switch c {
+			case '+', '-', '*', '/', '>', '<', '!':
+				tokens = append(tokens, token{tokOp, string(c), i})
+				i++
+			default:
+				return nil, fmt.Errorf("位置 %d: 无法识别的字符 %q", i, c)
+			}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(src)})
+	return tokens, nil
+}
+
+// ---------- 语法树 ----------
+
+type exprNode interface {
+	kind() exprKind
+	eval(env *exprEnv) (float64, bool, error)
+}
+
+type numberNode struct {
+	val float64
+}
+
+func (n *numberNode) kind() exprKind { return kindNumber }
+func (n *numberNode) eval(env *exprEnv) (float64, bool, error) {
+	return n.val, false, nil
+}
+
+// seriesNode 序列引用，offset 为倒数第几个（1 表示最新）
+type seriesNode struct {
+	key    string
+	name   string
+	args   []int
+	offset int
+}
+
+func (n *seriesNode) kind() exprKind { return kindNumber }
+func (n *seriesNode) eval(env *exprEnv) (float64, bool, error) {
+	series, err := env.series(n)
+	if err != nil {
+		return 0, false, err
+	}
+	// live 模式的规则对已收盘K线求值时下标后移，最少为最新一个
+	offset := max(n.offset-env.shift, 1)
+	if offset > len(series) {
+		return 0, false, fmt.Errorf("%s[-%d] 超出数据范围(%d)", n.key, n.offset, len(series))
+	}
+	return series[len(series)-offset], false, nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) kind() exprKind {
+	if n.op == "!" {
+		return kindBool
+	}
+	return kindNumber
+}
+
+func (n *unaryNode) eval(env *exprEnv) (float64, bool, error) {
+	v, b, err := n.x.eval(env)
+	if err != nil {
+		return 0, false, err
+	}
+	if n.op == "!" {
+		return 0, !b, nil
+	}
+	return -v, false, nil
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (n *binaryNode) kind() exprKind {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return kindNumber
+	}
+	return kindBool
+}
+
+func (n *binaryNode) eval(env *exprEnv) (float64, bool, error) {
+	lv, lb, err := n.l.eval(env)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// 逻辑运算短路求值
+	switch n.op {
+	case "&&":
+		if !lb {
+			return 0, false, nil
+		}
+		_, rb, err := n.r.eval(env)
+		return 0, rb, err
+	case "||":
+		if lb {
+			return 0, true, nil
+		}
+		_, rb, err := n.r.eval(env)
+		return 0, rb, err
+	}
+
+	rv, _, err := n.r.eval(env)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch n.op {
+	case "+":
+		return lv + rv, false, nil
+	case "-":
+		return lv - rv, false, nil
+	case "*":
+		return lv * rv, false, nil
+	case "/":
+		if rv == 0 {
+			return 0, false, fmt.Errorf("除数为 0")
+		}
+		return lv / rv, false, nil
+	case ">":
+		return 0, lv > rv, nil
+	case ">=":
+		return 0, lv >= rv, nil
+	case "<":
+		return 0, lv < rv, nil
+	case "<=":
+		return 0, lv <= rv, nil
+	case "==":
+		return 0, lv == rv, nil
+	case "!=":
+		return 0, lv != rv, nil
+	}
+	return 0, false, fmt.Errorf("未知运算符: %s", n.op)
+}
+
+// ---------- 语法分析 ----------
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) acceptOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.typ != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(typ tokenType, what string) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, fmt.Errorf("位置 %d: 期望 %s，实际为 %q", t.pos, what, t.val)
+	}
+	return t, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, "||", kindBool, left, right); err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "||", l: left, r: right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, "&&", kindBool, left, right); err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "&&", l: left, r: right}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	pos := p.peek().pos
+	if _, ok := p.acceptOp("!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, "!", kindBool, x); err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "!", x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	pos := p.peek().pos
+	op, ok := p.acceptOp(">", ">=", "<", "<=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKinds(pos, op, kindNumber, left, right); err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, l: left, r: right}, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		op, ok := p.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, op, kindNumber, left, right); err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, l: left, r: right}
+	}
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		op, ok := p.acceptOp("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, op, kindNumber, left, right); err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, l: left, r: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	pos := p.peek().pos
+	if _, ok := p.acceptOp("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKinds(pos, "-", kindNumber, x); err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.typ {
+	case tokNumber:
+		v, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("位置 %d: 非法数字 %q", t.pos, t.val)
+		}
+		return &numberNode{val: v}, nil
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokIdent:
+		return p.parseSeries(t)
+	}
+	if t.typ == tokEOF {
+		return nil, fmt.Errorf("位置 %d: 表达式不完整", t.pos)
+	}
+	return nil, fmt.Errorf("位置 %d: 意外的 %q", t.pos, t.val)
+}
+
+// parseSeries 解析序列引用：name、name(args)、以及可选的 [-k]
+func (p *exprParser) parseSeries(t token) (exprNode, error) {
+	spec, ok := exprSeriesSpecs[t.val]
+	if !ok {
+		return nil, fmt.Errorf("位置 %d: 未知的序列 %q", t.pos, t.val)
+	}
+
+	var args []int
+	if p.peek().typ == tokLParen {
+		p.next()
+		for p.peek().typ != tokRParen {
+			if len(args) > 0 {
+				if _, err := p.expect(tokComma, ","); err != nil {
+					return nil, err
+				}
+			}
+			at, err := p.expect(tokNumber, "整数参数")
+			if err != nil {
+				return nil, err
+			}
+			n, err := strconv.Atoi(at.val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("位置 %d: 参数必须为正整数，实际为 %q", at.pos, at.val)
+			}
+			args = append(args, n)
+		}
+		p.next()
+	}
+
+	if len(args) == 0 {
+		args = spec.defaults
+	}
+	if len(args) != spec.arity {
+		return nil, fmt.Errorf("位置 %d: %s 需要 %d 个参数，实际为 %d", t.pos, t.val, spec.arity, len(args))
+	}
+
+	offset := 1
+	if p.peek().typ == tokLBracket {
+		p.next()
+		if _, ok := p.acceptOp("-"); !ok {
+			return nil, fmt.Errorf("位置 %d: 下标必须为负数，如 [-2]", p.peek().pos)
+		}
+		it, err := p.expect(tokNumber, "下标")
+		if err != nil {
+			return nil, err
+		}
+		offset, err = strconv.Atoi(it.val)
+		if err != nil || offset <= 0 {
+			return nil, fmt.Errorf("位置 %d: 非法下标 %q", it.pos, it.val)
+		}
+		if _, err := p.expect(tokRBracket, "]"); err != nil {
+			return nil, err
+		}
+	}
+
+	key := t.val
+	if len(args) > 0 {
+		parts := make([]string, len(args))
+		for i, a := range args {
+			parts[i] = strconv.Itoa(a)
+		}
+		key = fmt.Sprintf("%s(%s)", t.val, strings.Join(parts, ","))
+	}
+
+	return &seriesNode{key: key, name: t.val, args: args, offset: offset}, nil
+}
+
+func checkKinds(pos int, op string, want exprKind, nodes ...exprNode) error {
+	for _, n := range nodes {
+		if n.kind() != want {
+			return fmt.Errorf("位置 %d: 运算符 %s 需要%s操作数", pos, op, want)
+		}
+	}
+	return nil
+}
+
+// ---------- 序列定义与求值环境 ----------
+
+type seriesSpec struct {
+	arity    int
+	defaults []int
+	build    func(klines []KlineData, closes []float64, args []int) []float64
+}
+
+var exprSeriesSpecs = map[string]seriesSpec{
+	"close": {0, nil, func(k []KlineData, c []float64, a []int) []float64 { return c }},
+	"open":  {0, nil, func(k []KlineData, c []float64, a []int) []float64 { return ExtractOpensPrices(k) }},
+	"high": {0, nil, func(k []KlineData, c []float64, a []int) []float64 {
+		return extractField(k, func(d KlineData) float64 { return d.High })
+	}},
+	"low": {0, nil, func(k []KlineData, c []float64, a []int) []float64 {
+		return extractField(k, func(d KlineData) float64 { return d.Low })
+	}},
+	"volume": {0, nil, func(k []KlineData, c []float64, a []int) []float64 {
+		return extractField(k, func(d KlineData) float64 { return d.Volume })
+	}},
+	"ema": {1, nil, func(k []KlineData, c []float64, a []int) []float64 { return CalculateEMA(c, a[0]) }},
+	"ma":  {1, nil, func(k []KlineData, c []float64, a []int) []float64 { return CalculateMASeries(c, a[0]) }},
+	"dif": {3, []int{6, 13, 5}, func(k []KlineData, c []float64, a []int) []float64 {
+		dif, _, _ := CalculateMACD(c, a[0], a[1], a[2])
+		return dif
+	}},
+	"dea": {3, []int{6, 13, 5}, func(k []KlineData, c []float64, a []int) []float64 {
+		_, dea, _ := CalculateMACD(c, a[0], a[1], a[2])
+		return dea
+	}},
+	"hist": {3, []int{6, 13, 5}, func(k []KlineData, c []float64, a []int) []float64 {
+		_, _, hist := CalculateMACD(c, a[0], a[1], a[2])
+		return hist
+	}},
+}
+
+func extractField(klines []KlineData, field func(KlineData) float64) []float64 {
+	values := make([]float64, len(klines))
+	for i, k := range klines {
+		values[i] = field(k)
+	}
+	return values
+}
+
+// exprEnv 一次求值的环境，缓存已计算的序列。shift 为下标偏移，见 RuleInput.Shift
+type exprEnv struct {
+	klines []KlineData
+	closes []float64
+	shift  int
+	cache  map[string][]float64
+}
+
+func newExprEnv(klines []KlineData, closes []float64, shift int) *exprEnv {
+	return &exprEnv{
+		klines: klines,
+		closes: closes,
+		shift:  shift,
+		cache:  make(map[string][]float64),
+	}
+}
+
+func (env *exprEnv) series(n *seriesNode) ([]float64, error) {
+	if s, ok := env.cache[n.key]; ok {
+		return s, nil
+	}
+	s := exprSeriesSpecs[n.name].build(env.klines, env.closes, n.args)
+	if len(s) == 0 {
+		return nil, fmt.Errorf("%s 无数据", n.key)
+	}
+	env.cache[n.key] = s
+	return s, nil
+}
+
+// ---------- 对外接口 ----------
+
+// Expr 编译后的条件表达式
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// CompileExpr 编译条件表达式，结果必须为布尔类型
+func CompileExpr(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("表达式 %q: %v", src, err)
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("表达式 %q: %v", src, err)
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, fmt.Errorf("表达式 %q: 位置 %d: 多余的 %q", src, t.pos, t.val)
+	}
+	if root.kind() != kindBool {
+		return nil, fmt.Errorf("表达式 %q: 结果必须为布尔值", src)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String 返回表达式源码
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval 在给定K线上求值
+func (e *Expr) Eval(klines []KlineData, closePrices []float64) (bool, error) {
+	return e.evalIn(newExprEnv(klines, closePrices, 0))
+}
+
+func (e *Expr) evalIn(env *exprEnv) (bool, error) {
+	_, b, err := e.root.eval(env)
+	if err != nil {
+		return false, fmt.Errorf("表达式 %q: %v", e.src, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// exprCase 编译后的规则分支
+type exprCase struct {
+	status TrendStatus
+	expr   *Expr
+}
+
+// ExprRule 由表达式定义的趋势规则
+type ExprRule struct {
+	name     string
+	cases    []exprCase
+	fallback TrendStatus
+}
+
+// NewExprRule 编译表达式规则集
+func NewExprRule(set config.ExprRuleSet) (*ExprRule, error) {
+	if set.Name == "" {
+		return nil, fmt.Errorf("表达式规则集缺少名称")
+	}
+	if len(set.Cases) == 0 {
+		return nil, fmt.Errorf("表达式规则集 %s 没有任何条件", set.Name)
+	}
+
+	rule := &ExprRule{
+		name:     set.Name,
+		fallback: RANGE,
+	}
+	if set.Default != "" {
+		rule.fallback = TrendStatus(set.Default)
+	}
+
+	for i, c := range set.Cases {
+		if c.Status == "" {
+			return nil, fmt.Errorf("表达式规则集 %s 第 %d 条缺少 status", set.Name, i+1)
+		}
+		expr, err := CompileExpr(c.When)
+		if err != nil {
+			return nil, fmt.Errorf("表达式规则集 %s 第 %d 条: %v", set.Name, i+1, err)
+		}
+		rule.cases = append(rule.cases, exprCase{status: TrendStatus(c.Status), expr: expr})
+	}
+	return rule, nil
+}
+
+// Name 返回规则名称
+func (r *ExprRule) Name() string {
+	return r.name
+}
+
+// Evaluate 按顺序求值，返回第一个成立条件对应的状态
+func (r *ExprRule) Evaluate(in *RuleInput) TrendStatus {
+	env := newExprEnv(in.Klines, in.ClosePrices, in.Shift)
+	for _, c := range r.cases {
+		ok, err := c.expr.evalIn(env)
+		if err != nil {
+			log.Printf("规则 %s (%s %s) 求值失败: %v", r.name, in.Symbol, in.Interval, err)
+			continue
+		}
+		if ok {
+			return c.status
+		}
+	}
+	return r.fallback
+}
+
+// exprRuleFile 表达式规则文件格式
+type exprRuleFile struct {
+	Rules []config.ExprRuleSet `json:"rules"`
+}
+
+// LoadExprRuleFile 从 JSON 文件读取表达式规则集
+func LoadExprRuleFile(path string) ([]config.ExprRuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取规则文件失败: %v", err)
+	}
+
+	var file exprRuleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析规则文件 %s 失败: %v", path, err)
+	}
+	return file.Rules, nil
+}
+
+// 由配置注册的表达式规则名，热加载时整体替换
+var configuredExprRules []string
+
+// BuildExprRules 编译配置中的表达式规则（ExprRules 与 ExprRuleFile），不注册
+func BuildExprRules(cfg *config.Config) ([]*ExprRule, error) {
+	sets := append([]config.ExprRuleSet{}, cfg.ExprRules...)
+	if cfg.ExprRuleFile != "" {
+		fileSets, err := LoadExprRuleFile(cfg.ExprRuleFile)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, fileSets...)
+	}
+
+	rules := make([]*ExprRule, 0, len(sets))
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		rule, err := NewExprRule(set)
+		if err != nil {
+			return nil, err
+		}
+		if seen[rule.Name()] {
+			return nil, fmt.Errorf("表达式规则集重复: %s", rule.Name())
+		}
+		seen[rule.Name()] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// RegisterConfiguredExprRules 编译并注册配置中的表达式规则，替换之前由配置注册的规则
+func RegisterConfiguredExprRules(cfg *config.Config) error {
+	rules, err := BuildExprRules(cfg)
+	if err != nil {
+		return err
+	}
+	return registerExprRules(rules)
+}
+
+// registerExprRules 注册已编译的表达式规则，替换之前由配置注册的规则
+func registerExprRules(rules []*ExprRule) error {
+	ruleMu.Lock()
+	defer ruleMu.Unlock()
+
+	old := make(map[string]bool, len(configuredExprRules))
+	for _, name := range configuredExprRules {
+		old[name] = true
+	}
+	for _, rule := range rules {
+		if _, exists := ruleRegistry[rule.Name()]; exists && !old[rule.Name()] {
+			return fmt.Errorf("规则已存在: %s", rule.Name())
+		}
+	}
+
+	for name := range old {
+		delete(ruleRegistry, name)
+	}
+	configuredExprRules = configuredExprRules[:0]
+	for _, rule := range rules {
+		ruleRegistry[rule.Name()] = rule
+		configuredExprRules = append(configuredExprRules, rule.Name())
+		log.Printf("已注册表达式规则: %s (%d 条)", rule.Name(), len(rule.cases))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeKlines 由收盘价构造K线
+func closeKlines(closes ...float64) []KlineData {
+	klines := make([]KlineData, len(closes))
+	for i, c := range closes {
+		klines[i] = KlineData{OpenTime: int64(i) * 60000, Open: c, High: c, Low: c, Close: c, CloseTime: int64(i+1)*60000 - 1}
+	}
+	return klines
+}
+
+func TestExprEval(t *testing.T) {
+	klines := closeKlines(1, 2, 3, 4, 5)
+	closes := ExtractClosePrices(klines)
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		// 算术优先级与结合性
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"1 - 2 - 3 == -4", true},
+		{"8 / 4 / 2 == 1", true},
+		{"-2 * 3 == -6", true},
+		{"close - close[-2] * 2 == -3", true},
+		// 比较与逻辑：! 高于 &&，&& 高于 ||
+		{"1 > 2 || 2 > 1 && 3 > 2", true},
+		{"1 > 2 && 2 > 1 || 3 > 2", true},
+		{"(1 > 2 || 2 > 1) && 3 < 2", false},
+		{"!(1 > 2) && 2 >= 2", true},
+		{"!1 > 2", true}, // ! 作用于整个比较：!(1 > 2)
+		{"1 != 1 || 1 <= 0", false},
+		// 序列与下标
+		{"close == 5 && close[-1] == 5 && close[-5] == 1", true},
+		{"close > close[-2]", true},
+		{"ma(5) == 3", true},
+		{"high == close && low[-3] == 3", true},
+		// 短路求值不会触发右侧的除零
+		{"1 > 2 && close / 0 > 1", false},
+		{"2 > 1 || close / 0 > 1", true},
+	}
+	for _, tt := range tests {
+		e, err := CompileExpr(tt.src)
+		if err != nil {
+			t.Errorf("CompileExpr(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(klines, closes)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExprCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // 错误信息中应包含的内容
+	}{
+		// 类型错误
+		{"close", "结果必须为布尔值"},
+		{"1 + 2", "结果必须为布尔值"},
+		{"close + (1 > 0) > 1", "需要数值操作数"},
+		{"close && 1 > 0", "需要布尔操作数"},
+		{"!close", "需要布尔操作数"},
+		{"(1 > 0) > 1", "需要数值操作数"},
+		// 未知标识符与参数
+		{"foo > 1", "未知的序列"},
+		{"ema > 1", "需要 1 个参数"},
+		{"ema(1, 2) > 1", "需要 1 个参数"},
+		{"ma(0) > 1", "参数必须为正整数"},
+		{"close[1] > 0", "下标必须为负数"},
+		// 语法错误
+		{"close > ", "表达式不完整"},
+		{"close > 1)", "多余的"},
+		{"(close > 1", "期望"},
+		{"close > 1 $ 2", "无法识别的字符"},
+	}
+	for _, tt := range tests {
+		_, err := CompileExpr(tt.src)
+		if err == nil {
+			t.Errorf("CompileExpr(%q) succeeded, want error containing %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("CompileExpr(%q) error = %v, want containing %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	klines := closeKlines(1, 2, 3)
+	closes := ExtractClosePrices(klines)
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"close / (close - close) > 1", "除数为 0"},
+		{"1 / 0 > 1", "除数为 0"},
+		{"close[-4] > 0", "超出数据范围"},
+	}
+	for _, tt := range tests {
+		e, err := CompileExpr(tt.src)
+		if err != nil {
+			t.Errorf("CompileExpr(%q): %v", tt.src, err)
+			continue
+		}
+		_, err = e.Eval(klines, closes)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Eval(%q) error = %v, want containing %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// TestExprRuleMatchesBuiltin 用表达式写出的 macd_xstrong 与内置规则在每根K线上的结果一致
+func TestExprRuleMatchesBuiltin(t *testing.T) {
+	rule, err := NewExprRule(config.ExprRuleSet{
+		Name:    "expr_xstrong",
+		Default: string(RANGE),
+		Cases: []config.ExprCase{
+			{Status: string(BUYMACD), When: "hist[-2] > 0 && hist[-2] > hist[-3] && close > ma(60)"},
+			{Status: string(SELLMACD), When: "hist[-2] < 0 && hist[-2] < hist[-3] && close < ma(60)"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewExprRule: %v", err)
+	}
+	builtin, _ := GetTrendRule(RuleMACDXStrong)
+
+	all := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	seen := make(map[TrendStatus]int)
+	for end := 130; end <= len(all); end++ {
+		klines := all[:end]
+		// 对已收盘K线（偏移 1）和包含未收盘K线的序列（偏移 0）都一致
+		for shift := 0; shift <= 1; shift++ {
+			want := evaluateKlines("BTCUSDT", "1h", builtin, NewIndicators(), klines, shift).Status
+			got := evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), klines, shift).Status
+			if got != want {
+				t.Fatalf("end=%d shift=%d: expr rule %s, builtin %s", end, shift, got, want)
+			}
+			seen[want]++
+		}
+	}
+	// 夹具需要覆盖所有状态，比较才有意义
+	for _, status := range []TrendStatus{BUYMACD, SELLMACD, RANGE} {
+		if seen[status] == 0 {
+			t.Errorf("fixture never produced %s: %v", status, seen)
+		}
+	}
+}
+
+// TestExampleRuleFile 示例规则文件按 closed 写法编写：hist_strength 在已收盘K线上（偏移 0）
+// 与内置 live 规则 macd_xstrong（偏移 1）判断的是同一根K线
+func TestExampleRuleFile(t *testing.T) {
+	sets, err := LoadExprRuleFile("../config/rules.example.json")
+	if err != nil {
+		t.Fatalf("LoadExprRuleFile: %v", err)
+	}
+	var strength TrendRule
+	for _, set := range sets {
+		rule, err := NewExprRule(set)
+		if err != nil {
+			t.Fatalf("NewExprRule(%s): %v", set.Name, err)
+		}
+		if set.Name == "hist_strength" {
+			strength = rule
+		}
+	}
+	if strength == nil {
+		t.Fatal("hist_strength not found in example file")
+	}
+	builtin, _ := GetTrendRule(RuleMACDXStrong)
+
+	mapped := map[TrendStatus]TrendStatus{BUYMACD: XBUYMID, SELLMACD: XSELLMID, RANGE: RANGE}
+	all := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	for end := 130; end <= len(all); end++ {
+		klines := all[:end]
+		want := mapped[evaluateKlines("BTCUSDT", "1h", builtin, NewIndicators(), klines, 1).Status]
+		got := evaluateKlines("BTCUSDT", "1h", strength, NewIndicators(), klines, 0).Status
+		if got != want {
+			t.Fatalf("end=%d: hist_strength %s, want %s", end, got, want)
+		}
+	}
+}
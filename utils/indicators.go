@@ -70,9 +70,15 @@ func (ema *EMA) GetPeriod() int {
 
 // NewIndicators 创建所有需要的技术指标
 func NewIndicators() map[string]Indicator {
+	return NewIndicatorsFor(config.Get())
+}
+
+// NewIndicatorsFor 按给定配置的周期创建指标
+func NewIndicatorsFor(cfg *config.Config) map[string]Indicator {
 	return map[string]Indicator{
-		"EMA25": &EMA{Period: config.GlobalConfig.EMA25Period},
-		"EMA50": &EMA{Period: config.GlobalConfig.EMA50Period},
+		"EMA25":  &EMA{Period: cfg.EMA25Period},
+		"EMA50":  &EMA{Period: cfg.EMA50Period},
+		"EMA120": &EMA{Period: cfg.EMA120Period},
 	}
 }
 
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterKlineProvider("archive", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		if source.Path == "" {
+			return nil, fmt.Errorf("archive 提供方需要配置 path（归档目录）")
+		}
+		return &ArchiveProvider{Archive: NewKlineArchive(source.Path)}, nil
+	})
+}
+
+// archiveHeader 归档文件的表头，列与币安K线接口一致
+var archiveHeader = []string{"open_time", "open", "high", "low", "close", "volume", "close_time",
+	"quote_volume", "trades", "taker_buy_volume", "taker_buy_quote_volume"}
+
+// archiveMonthLayout 归档文件名中的月份格式
+const archiveMonthLayout = "2006-01"
+
+// KlineArchive 本地K线归档：<dir>/<symbol>/<interval>/<YYYY-MM>.csv.gz，
+// 每个币种周期每月一个 gzip 压缩的 CSV 文件，月份按开盘时间（UTC）划分
+type KlineArchive struct {
+	Dir string
+}
+
+// NewKlineArchive 创建归档，dir 为归档根目录
+func NewKlineArchive(dir string) *KlineArchive {
+	return &KlineArchive{Dir: dir}
+}
+
+// intervalDir 币种周期所在的目录。1M 与 1m 在不区分大小写的文件系统上会冲突，1M 记为 1mon
+func (a *KlineArchive) intervalDir(symbol, interval string) string {
+	if interval == "1M" {
+		interval = "1mon"
+	}
+	return filepath.Join(a.Dir, symbol, interval)
+}
+
+// monthPath 某个月的归档文件路径
+func (a *KlineArchive) monthPath(symbol, interval string, month time.Time) string {
+	return filepath.Join(a.intervalDir(symbol, interval), month.UTC().Format(archiveMonthLayout)+".csv.gz")
+}
+
+// Months 返回已归档的月份（升序），没有归档时返回空
+func (a *KlineArchive) Months(symbol, interval string) ([]time.Time, error) {
+	paths, err := filepath.Glob(filepath.Join(a.intervalDir(symbol, interval), "*.csv.gz"))
+	if err != nil {
+		return nil, err
+	}
+	months := make([]time.Time, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		month, err := time.Parse(archiveMonthLayout, name[:len(name)-len(".csv.gz")])
+		if err != nil {
+			continue
+		}
+		months = append(months, month)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+	return months, nil
+}
+
+// ReadMonth 读取某个月的K线，文件不存在时返回 os.ErrNotExist
+func (a *KlineArchive) ReadMonth(symbol, interval string, month time.Time) ([]KlineData, error) {
+	path := a.monthPath(symbol, interval, month)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("读取归档 %s 失败: %v", path, err)
+	}
+	defer gz.Close()
+
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = len(archiveHeader)
+	var klines []KlineData
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取归档 %s 失败: %v", path, err)
+		}
+		if line == 1 && row[0] == archiveHeader[0] {
+			continue
+		}
+		k, err := parseArchiveRow(len(klines), row)
+		if err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行: %w", path, line, err)
+		}
+		klines = append(klines, k)
+	}
+	if err := ValidateKlines(klines); err != nil {
+		return nil, fmt.Errorf("归档 %s 无效: %w", path, err)
+	}
+	return klines, nil
+}
+
+// parseArchiveRow 解析归档中的一行
+func parseArchiveRow(index int, row []string) (KlineData, error) {
+	var k KlineData
+	ints := []*int64{&k.OpenTime, &k.CloseTime, &k.NumberOfTrades}
+	for i, col := range []int{0, 6, 8} {
+		v, err := strconv.ParseInt(row[col], 10, 64)
+		if err != nil {
+			return KlineData{}, &KlineError{Index: index, Field: archiveHeader[col], Value: row[col], Reason: "应为整数"}
+		}
+		*ints[i] = v
+	}
+	floats := []*float64{&k.Open, &k.High, &k.Low, &k.Close, &k.Volume,
+		&k.QuoteAssetVolume, &k.TakerBuyBaseAssetVolume, &k.TakerBuyQuoteAssetVolume}
+	for i, col := range []int{1, 2, 3, 4, 5, 7, 9, 10} {
+		v, err := parseKlineFloat(row[col])
+		if err != nil {
+			return KlineData{}, &KlineError{Index: index, OpenTime: k.OpenTime, Field: archiveHeader[col], Value: row[col], Reason: err.Error()}
+		}
+		*floats[i] = v
+	}
+	return k, nil
+}
+
+// WriteMonth 覆盖写入某个月的K线，先写临时文件再重命名，避免中断时留下半个文件
+func (a *KlineArchive) WriteMonth(symbol, interval string, month time.Time, klines []KlineData) error {
+	path := a.monthPath(symbol, interval, month)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(f)
+	w := csv.NewWriter(gz)
+	w.Write(archiveHeader)
+	for _, k := range klines {
+		w.Write([]string{
+			strconv.FormatInt(k.OpenTime, 10),
+			formatKlineFloat(k.Open), formatKlineFloat(k.High), formatKlineFloat(k.Low),
+			formatKlineFloat(k.Close), formatKlineFloat(k.Volume),
+			strconv.FormatInt(k.CloseTime, 10),
+			formatKlineFloat(k.QuoteAssetVolume),
+			strconv.FormatInt(k.NumberOfTrades, 10),
+			formatKlineFloat(k.TakerBuyBaseAssetVolume), formatKlineFloat(k.TakerBuyQuoteAssetVolume),
+		})
+	}
+	w.Flush()
+	err = errors.Join(w.Error(), gz.Close(), f.Close())
+	if err != nil {
+		return fmt.Errorf("写入归档 %s 失败: %v", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// formatKlineFloat 以最短的精确形式输出价格和成交量
+func formatKlineFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// monthStart 返回 t 所在月份的第一天（UTC）
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ArchiveProvider 从本地归档读取K线，与交易所数据一样通过 KlineProvider 供分析和回测使用
+type ArchiveProvider struct {
+	Archive *KlineArchive
+}
+
+// Name 提供方名称
+func (p *ArchiveProvider) Name() string {
+	return "archive"
+}
+
+// GetKlines 返回归档中最后 limit 根K线，从最近的月份向前读取
+func (p *ArchiveProvider) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	months, err := p.Archive.Months(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	if len(months) == 0 {
+		return nil, fmt.Errorf("归档中没有 %s %s 的K线", symbol, interval)
+	}
+	var klines []KlineData
+	for i := len(months) - 1; i >= 0 && len(klines) < limit; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		month, err := p.Archive.ReadMonth(symbol, interval, months[i])
+		if err != nil {
+			return nil, err
+		}
+		klines = append(month, klines...)
+	}
+	if len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+// GetKlinesSince 返回开盘时间不早于 startTime 的前 limit 根K线
+func (p *ArchiveProvider) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	months, err := p.Archive.Months(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	first := monthStart(time.UnixMilli(startTime))
+	var klines []KlineData
+	for _, m := range months {
+		if m.Before(first) {
+			continue
+		}
+		if len(klines) >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		month, err := p.Archive.ReadMonth(symbol, interval, m)
+		if err != nil {
+			return nil, err
+		}
+		i := sort.Search(len(month), func(i int) bool { return month[i].OpenTime >= startTime })
+		klines = append(klines, month[i:]...)
+	}
+	if len(klines) > limit {
+		klines = klines[:limit]
+	}
+	return klines, nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import "sync"
+
+// KlineBuffer 按币种周期保存最近的K线，供实时推送模式下分析使用。
+// 最后一根可能是尚未收盘的K线，推送更新时原地替换。
+type KlineBuffer struct {
+	mu   sync.RWMutex
+	size int
+	data map[string][]KlineData
+}
+
+// NewKlineBuffer 创建K线缓冲区，size 为每个币种周期保留的最大根数
+func NewKlineBuffer(size int) *KlineBuffer {
+	return &KlineBuffer{
+		size: size,
+		data: make(map[string][]KlineData),
+	}
+}
+
+func bufferKey(symbol, interval string) string {
+	return symbol + "_" + interval
+}
+
+// Set 用完整的K线序列（如 REST 回补结果）替换缓冲区内容
+func (b *KlineBuffer) Set(symbol, interval string, klines []KlineData) {
+	cp := make([]KlineData, len(klines))
+	copy(cp, klines)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data[bufferKey(symbol, interval)] = b.trim(cp)
+}
+
+// Update 合并一根推送的K线：同一开盘时间则替换，紧接最后一根则追加，
+// 早于最后一根的过期推送被忽略。返回 true 表示与已有数据之间存在缺口，需要回补
+func (b *KlineBuffer) Update(symbol, interval string, k KlineData) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := bufferKey(symbol, interval)
+	klines := b.data[key]
+	if len(klines) == 0 {
+		b.data[key] = []KlineData{k}
+		return true
+	}
+
+	last := klines[len(klines)-1]
+	switch {
+	case k.OpenTime == last.OpenTime:
+		klines[len(klines)-1] = k
+		return false
+	case k.OpenTime < last.OpenTime:
+		return false
+	}
+
+	// 币安K线的下一根开盘时间等于上一根收盘时间 + 1ms
+	gap := k.OpenTime != last.CloseTime+1
+	b.data[key] = b.trim(append(klines, k))
+	return gap
+}
+
+// Klines 返回缓冲区中K线的副本
+func (b *KlineBuffer) Klines(symbol, interval string) []KlineData {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	klines := b.data[bufferKey(symbol, interval)]
+	cp := make([]KlineData, len(klines))
+	copy(cp, klines)
+	return cp
+}
+
+func (b *KlineBuffer) trim(klines []KlineData) []KlineData {
+	if b.size > 0 && len(klines) > b.size {
+		return klines[len(klines)-b.size:]
+	}
+	return klines
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KlineCache 按币种周期缓存K线，每次只通过 startTime 获取上次最后一根K线之后的数据，
+// 数据不连续时全量重新获取。dir 非空时缓存同时保存到磁盘，重启后继续增量获取。
+type KlineCache struct {
+	dir    string
+	buffer *KlineBuffer
+
+	mu     sync.Mutex
+	locks  map[string]*sync.Mutex
+	loaded map[string]bool
+}
+
+// NewKlineCache 创建K线缓存，size 为每个币种周期保留的根数，dir 为空时只缓存在内存中
+func NewKlineCache(dir string, size int) *KlineCache {
+	return &KlineCache{
+		dir:    dir,
+		buffer: NewKlineBuffer(size),
+		locks:  make(map[string]*sync.Mutex),
+		loaded: make(map[string]bool),
+	}
+}
+
+// lock 返回某个币种周期的锁，同一币种周期的获取和合并串行执行
+func (c *KlineCache) lock(key string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[key] = l
+	}
+	return l
+}
+
+// Klines 返回 provider 中 symbol 最近 limit 根K线，缓存足够且连续时只增量获取。
+// now 为交易所时间，用于计算增量获取的根数。缓存按提供方区分，同一交易对在不同交易所的K线互不影响
+func (c *KlineCache) Klines(ctx context.Context, provider KlineProvider, symbol, interval string, limit int, now time.Time) ([]KlineData, error) {
+	name := provider.Name()
+	key := bufferKey(name+":"+symbol, interval)
+	l := c.lock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	if c.dir != "" && !c.loaded[key] {
+		c.load(name, symbol, interval)
+		c.loaded[key] = true
+	}
+
+	ok, err := c.update(ctx, provider, symbol, interval, limit, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		klines, err := provider.GetKlines(ctx, symbol, interval, limit)
+		if err != nil {
+			return nil, err
+		}
+		// 无效数据不写入缓存，下次重新获取
+		if err := ValidateKlines(klines); err != nil {
+			return nil, err
+		}
+		c.buffer.Set(name+":"+symbol, interval, klines)
+	}
+
+	if c.dir != "" {
+		if err := c.save(name, symbol, interval); err != nil {
+			log.Printf("保存 %s %s K线缓存失败: %v", symbol, interval, err)
+		}
+	}
+
+	klines := c.buffer.Klines(name+":"+symbol, interval)
+	if len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+// update 增量获取并合并新K线，返回 false 表示缓存不足或存在缺口，需要全量获取
+func (c *KlineCache) update(ctx context.Context, provider KlineProvider, symbol, interval string, limit int, now time.Time) (bool, error) {
+	key := provider.Name() + ":" + symbol
+	cached := c.buffer.Klines(key, interval)
+	if len(cached) < limit {
+		return false, nil
+	}
+	if !continuous(cached) {
+		log.Printf("%s %s K线缓存不连续，重新全量获取", symbol, interval)
+		return false, nil
+	}
+
+	// 从最后一根开始获取，它在上次获取时可能尚未收盘。
+	// 只请求从它到当前K线所需的根数，多请求一根以容忍时钟偏差，通常为 2～3 根
+	last := cached[len(cached)-1]
+	bars, err := barsSince(last.OpenTime, interval, now, limit)
+	if err != nil {
+		return false, nil
+	}
+	need := bars + 1
+	if need > limit {
+		// 缺失的K线比全量获取还多
+		return false, nil
+	}
+	fresh, err := provider.GetKlinesSince(ctx, symbol, interval, last.OpenTime, need)
+	if err != nil {
+		return false, err
+	}
+	// 返回数量达到请求的根数时，之后可能还有K线没有取到
+	if len(fresh) >= need || len(fresh) == 0 || fresh[0].OpenTime != last.OpenTime {
+		return false, nil
+	}
+	if err := ValidateKlines(fresh); err != nil {
+		return false, err
+	}
+	if !continuous(fresh) {
+		log.Printf("%s %s 增量K线存在缺口，重新全量获取", symbol, interval)
+		return false, nil
+	}
+
+	for _, k := range fresh {
+		c.buffer.Update(key, interval, k)
+	}
+	return true, nil
+}
+
+// barsSince 返回从开盘时间为 openTime 的K线到 now 所在K线（含两端）的根数，最多数到 limit + 1
+func barsSince(openTime int64, interval string, now time.Time, limit int) (int, error) {
+	nowMs := now.UnixMilli()
+	n := 1
+	for open := openTime; n <= limit; n++ {
+		closeTime, err := candleCloseTime(open, interval)
+		if err != nil {
+			return 0, err
+		}
+		if closeTime >= nowMs {
+			break
+		}
+		open = closeTime + 1
+	}
+	return n, nil
+}
+
+// continuous 判断K线是否首尾相接
+func continuous(klines []KlineData) bool {
+	for i := 1; i < len(klines); i++ {
+		if klines[i].OpenTime != klines[i-1].CloseTime+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheFile 缓存文件路径
+func (c *KlineCache) cacheFile(provider, symbol, interval string) string {
+	return klineCachePath(c.dir, provider, symbol, interval)
+}
+
+// klineCachePath 缓存文件路径。1M 与 1m 在不区分大小写的文件系统上会冲突，1M 记为 1mon
+func klineCachePath(dir, provider, symbol, interval string) string {
+	if interval == "1M" {
+		interval = "1mon"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s.json", provider, symbol, interval))
+}
+
+// ReadCachedKlines 读取 dir 中保存的K线缓存（如用于回测），数据无效时返回错误
+func ReadCachedKlines(dir, provider, symbol, interval string) ([]KlineData, error) {
+	data, err := os.ReadFile(klineCachePath(dir, provider, symbol, interval))
+	if err != nil {
+		return nil, err
+	}
+	var klines []KlineData
+	if err := json.Unmarshal(data, &klines); err != nil {
+		return nil, fmt.Errorf("解析K线缓存失败: %v", err)
+	}
+	if err := ValidateKlines(klines); err != nil {
+		return nil, fmt.Errorf("K线缓存无效: %w", err)
+	}
+	return klines, nil
+}
+
+// load 从磁盘读取缓存，文件不存在或损坏时忽略
+func (c *KlineCache) load(provider, symbol, interval string) {
+	klines, err := ReadCachedKlines(c.dir, provider, symbol, interval)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取 %s %s K线缓存失败，将重新获取: %v", symbol, interval, err)
+		}
+		return
+	}
+	c.buffer.Set(provider+":"+symbol, interval, klines)
+}
+
+// save 将缓存写入磁盘，先写临时文件再重命名，避免中断时留下半个文件
+func (c *KlineCache) save(provider, symbol, interval string) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.buffer.Klines(provider+":"+symbol, interval))
+	if err != nil {
+		return err
+	}
+
+	path := c.cacheFile(provider, symbol, interval)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeKlineProvider 按 now 返回夹具中已经开盘的K线，记录每次请求
+type fakeKlineProvider struct {
+	klines []KlineData
+	now    time.Time
+	calls  []string
+	weight int
+}
+
+func (p *fakeKlineProvider) Name() string { return "fake" }
+
+// available 返回 now 时已经开盘的K线，最后一根可能尚未收盘
+func (p *fakeKlineProvider) available() []KlineData {
+	n := 0
+	for n < len(p.klines) && p.klines[n].OpenTime <= p.now.UnixMilli() {
+		n++
+	}
+	return p.klines[:n]
+}
+
+func (p *fakeKlineProvider) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	p.calls = append(p.calls, fmt.Sprintf("full:%d", limit))
+	p.weight += klineWeight(limit)
+	klines := p.available()
+	if len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+func (p *fakeKlineProvider) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	p.calls = append(p.calls, fmt.Sprintf("since:%d", limit))
+	p.weight += klineWeight(limit)
+	var klines []KlineData
+	for _, k := range p.available() {
+		if k.OpenTime >= startTime && len(klines) < limit {
+			klines = append(klines, k)
+		}
+	}
+	return klines, nil
+}
+
+// TestKlineCacheIncrementalLimit 增量获取只请求所需的根数，缺失较多时仍增量获取，超过全量根数时才全量获取
+func TestKlineCacheIncrementalLimit(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &fakeKlineProvider{klines: fixtureKlines(2000, start, time.Hour)}
+	cache := NewKlineCache("", klineHistoryLimit)
+	ctx := context.Background()
+
+	// at 返回第 i 根K线开盘半小时后的时间
+	at := func(i int) time.Time { return start.Add(time.Duration(i)*time.Hour + 30*time.Minute) }
+
+	tests := []struct {
+		name   string
+		bar    int    // 当前时间所在的K线
+		call   string // 本次发出的请求
+		weight int    // 本次请求的权重
+	}{
+		{"首次全量获取", 600, "full:499", 2},
+		{"同一根K线内", 600, "since:2", 1},
+		{"进入下一根K线", 601, "since:3", 1},
+		{"缺失 150 根仍增量获取", 751, "since:152", 2},
+		{"缺失超过全量根数", 1300, "full:499", 2},
+		{"全量获取后恢复增量", 1301, "since:3", 1},
+	}
+	total := 0
+	for _, tt := range tests {
+		p.now = at(tt.bar)
+		p.calls = nil
+		before := p.weight
+
+		klines, err := cache.Klines(ctx, p, "BTCUSDT", "1h", klineHistoryLimit, p.now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(p.calls) != 1 || p.calls[0] != tt.call {
+			t.Fatalf("%s: calls = %v, want [%s]", tt.name, p.calls, tt.call)
+		}
+		if got := p.weight - before; got != tt.weight {
+			t.Fatalf("%s: weight = %d, want %d", tt.name, got, tt.weight)
+		}
+		total += tt.weight
+
+		want := p.klines[tt.bar-klineHistoryLimit+1 : tt.bar+1]
+		if len(klines) != len(want) || klines[0].OpenTime != want[0].OpenTime ||
+			klines[len(klines)-1].OpenTime != want[len(want)-1].OpenTime || !continuous(klines) {
+			t.Fatalf("%s: got %d klines ending at %d, want %d ending at %d",
+				tt.name, len(klines), klines[len(klines)-1].OpenTime, len(want), want[len(want)-1].OpenTime)
+		}
+	}
+	if p.weight != total {
+		t.Fatalf("total weight = %d, want %d", p.weight, total)
+	}
+}
+
+func TestBarsSince(t *testing.T) {
+	open := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		interval string
+		now      time.Time
+		limit    int
+		want     int
+	}{
+		{"1h", open.Add(10 * time.Minute), 499, 1},
+		{"1h", open.Add(time.Hour), 499, 2},
+		{"1h", open.Add(5*time.Hour + time.Minute), 499, 6},
+		{"1h", open.Add(1000 * time.Hour), 499, 500},
+		{"1w", open.AddDate(0, 0, 15), 499, 3},
+		{"1M", time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), 499, 3},
+	}
+	for _, tt := range tests {
+		got, err := barsSince(open.UnixMilli(), tt.interval, tt.now, tt.limit)
+		if err != nil || got != tt.want {
+			t.Errorf("barsSince(%s, %v) = %d, %v, want %d", tt.interval, tt.now, got, err, tt.want)
+		}
+	}
+}
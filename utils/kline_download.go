@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// downloadPageSize 下载时单次请求的K线根数，币安各市场和 Bybit 都支持，OKX 会按自身上限截断
+const downloadPageSize = 1000
+
+// DownloadOptions 下载参数，时间按K线开盘时间计算
+type DownloadOptions struct {
+	// 归档中的币种名称
+	Symbol string
+	// 交易所中的交易对，留空与 Symbol 相同
+	ExchangeSymbol string
+	Interval       string
+	From           time.Time
+	// 为零时下载到最近一根已收盘的K线
+	To time.Time
+	// 单次请求的根数，默认 downloadPageSize
+	PageSize int
+	// 当前时间，只下载已收盘的K线，默认为本机时间
+	Now func() time.Time
+}
+
+// KlineGap 归档中缺失的一段K线，From/To 为缺失部分的开盘时间范围（毫秒）
+type KlineGap struct {
+	From int64
+	To   int64
+}
+
+// String 以 UTC 时间输出缺口
+func (g KlineGap) String() string {
+	return fmt.Sprintf("%s ~ %s", time.UnixMilli(g.From).UTC().Format("2006-01-02 15:04"),
+		time.UnixMilli(g.To).UTC().Format("2006-01-02 15:04"))
+}
+
+// DownloadReport 一次下载的结果
+type DownloadReport struct {
+	Months   int // 写入的月份数
+	Fetched  int // 新下载的K线根数
+	Requests int
+	Verify   *VerifyReport
+}
+
+// DownloadKlines 按月把 [From, To] 内的K线下载到归档。已归档的月份只补齐缺失的部分，
+// 因此中断后重新执行会从断点继续；ctx 取消时先保存当前月份已下载的数据再返回。
+// 请求通过 provider 发出，与实时分析共用同一个限速器。下载完成后检查连续性
+func DownloadKlines(ctx context.Context, provider KlineProvider, archive *KlineArchive, opts DownloadOptions) (*DownloadReport, error) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = downloadPageSize
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.ExchangeSymbol == "" {
+		opts.ExchangeSymbol = opts.Symbol
+	}
+	now := opts.Now().UnixMilli()
+	if opts.To.IsZero() || opts.To.UnixMilli() > now {
+		opts.To = time.UnixMilli(now)
+	}
+
+	// 起点对齐到K线开盘时间，避免把不足一根的部分当作缺口
+	from, err := NextCandleClose(opts.Interval, opts.From.Add(-time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	if from.After(opts.To) {
+		return nil, fmt.Errorf("下载区间为空: %s ~ %s", opts.From.Format(time.RFC3339), opts.To.Format(time.RFC3339))
+	}
+
+	d := &downloader{provider: provider, archive: archive, opts: opts, now: now}
+	report := &DownloadReport{}
+	for month := monthStart(from); !month.After(opts.To); month = month.AddDate(0, 1, 0) {
+		lo := max(from.UnixMilli(), month.UnixMilli())
+		hi := min(opts.To.UnixMilli(), month.AddDate(0, 1, 0).UnixMilli()-1)
+		fetched, err := d.month(ctx, month, lo, hi, report)
+		report.Fetched += fetched
+		if err != nil {
+			return report, err
+		}
+	}
+
+	verify, err := VerifyArchive(ctx, archive, opts.Symbol, opts.Interval, from, opts.To)
+	if err != nil {
+		return report, err
+	}
+	report.Verify = verify
+	return report, nil
+}
+
+// downloader 一次下载的状态
+type downloader struct {
+	provider KlineProvider
+	archive  *KlineArchive
+	opts     DownloadOptions
+	now      int64
+}
+
+// month 补齐某个月 [lo, hi] 内缺失的K线，有新数据时重写该月的归档文件
+func (d *downloader) month(ctx context.Context, month time.Time, lo, hi int64, report *DownloadReport) (int, error) {
+	symbol, interval := d.opts.Symbol, d.opts.Interval
+	existing, err := d.archive.ReadMonth(symbol, interval, month)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️ %v，重新下载该月", err)
+		existing = nil
+	}
+
+	var fetched []KlineData
+	var fetchErr error
+	for _, gap := range missingRanges(existing, lo, hi) {
+		var klines []KlineData
+		klines, fetchErr = d.fetchRange(ctx, gap.From, gap.To, report)
+		fetched = append(fetched, klines...)
+		if fetchErr != nil {
+			break
+		}
+	}
+
+	if len(fetched) > 0 {
+		merged := mergeKlines(existing, fetched)
+		if err := d.archive.WriteMonth(symbol, interval, month, merged); err != nil {
+			return 0, err
+		}
+		report.Months++
+		log.Printf("[Download] %s %s %s: 新增 %d 根，共 %d 根", symbol, interval,
+			month.Format(archiveMonthLayout), len(fetched), len(merged))
+	}
+	return len(fetched), fetchErr
+}
+
+// fetchRange 从 start 开始翻页下载开盘时间不晚于 end 的已收盘K线。
+// 交易所在某段时间没有数据（如上线前、停机）时返回的K线会跳过这段时间，没有数据时停止
+func (d *downloader) fetchRange(ctx context.Context, start, end int64, report *DownloadReport) ([]KlineData, error) {
+	var klines []KlineData
+	for start <= end {
+		page, err := d.provider.GetKlinesSince(ctx, d.opts.ExchangeSymbol, d.opts.Interval, start, d.opts.PageSize)
+		report.Requests++
+		if err != nil {
+			return klines, fmt.Errorf("下载 %s %s K线失败: %w", d.opts.Symbol, d.opts.Interval, err)
+		}
+		if err := ValidateKlines(page); err != nil {
+			return klines, err
+		}
+
+		kept := 0
+		for _, k := range page {
+			if k.OpenTime < start || k.OpenTime > end || k.CloseTime >= d.now {
+				continue
+			}
+			klines = append(klines, k)
+			kept++
+		}
+		if kept == 0 {
+			break
+		}
+		start = klines[len(klines)-1].CloseTime + 1
+	}
+	return klines, nil
+}
+
+// missingRanges 返回 [lo, hi] 内没有被 klines 覆盖的开盘时间范围
+func missingRanges(klines []KlineData, lo, hi int64) []KlineGap {
+	var gaps []KlineGap
+	next := lo
+	for _, k := range klines {
+		if k.OpenTime > hi {
+			break
+		}
+		if k.OpenTime > next {
+			gaps = append(gaps, KlineGap{From: next, To: k.OpenTime - 1})
+		}
+		next = max(next, k.CloseTime+1)
+	}
+	if next <= hi {
+		gaps = append(gaps, KlineGap{From: next, To: hi})
+	}
+	return gaps
+}
+
+// mergeKlines 合并两组K线，按开盘时间排序，同一开盘时间以 b 为准
+func mergeKlines(a, b []KlineData) []KlineData {
+	byOpen := make(map[int64]KlineData, len(a)+len(b))
+	for _, k := range a {
+		byOpen[k.OpenTime] = k
+	}
+	for _, k := range b {
+		byOpen[k.OpenTime] = k
+	}
+	merged := make([]KlineData, 0, len(byOpen))
+	for _, k := range byOpen {
+		merged = append(merged, k)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].OpenTime < merged[j].OpenTime })
+	return merged
+}
+
+// VerifyReport 归档连续性检查的结果
+type VerifyReport struct {
+	Klines int
+	First  time.Time // 第一根K线的开盘时间
+	Last   time.Time // 最后一根K线的开盘时间
+	Gaps   []KlineGap
+}
+
+// VerifyArchive 逐月读取 [from, to] 内的归档，检查每个文件是否有效、相邻K线是否首尾相接（包括跨月）。
+// 交易所本身缺失的数据（如停机）也会作为缺口列出
+func VerifyArchive(ctx context.Context, archive *KlineArchive, symbol, interval string, from, to time.Time) (*VerifyReport, error) {
+	months, err := archive.Months(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{}
+	var prev *KlineData
+	for _, month := range months {
+		if month.Before(monthStart(from)) || month.After(to) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		klines, err := archive.ReadMonth(symbol, interval, month)
+		if err != nil {
+			return nil, err
+		}
+		for i := range klines {
+			k := klines[i]
+			if k.OpenTime < from.UnixMilli() || k.OpenTime > to.UnixMilli() {
+				continue
+			}
+			if prev == nil {
+				report.First = time.UnixMilli(k.OpenTime)
+			} else if k.OpenTime != prev.CloseTime+1 {
+				report.Gaps = append(report.Gaps, KlineGap{From: prev.CloseTime + 1, To: k.OpenTime - 1})
+			}
+			report.Klines++
+			report.Last = time.UnixMilli(k.OpenTime)
+			prev = &k
+		}
+	}
+	return report, nil
+}
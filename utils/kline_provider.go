@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// KlineProvider K线数据来源。symbol 为交易所中的交易对，interval 使用币安的周期写法（如 1h、1d），
+// 由各实现转换为交易所的格式；返回的K线按开盘时间升序排列
+type KlineProvider interface {
+	// Name 提供方名称，如 binance_futures
+	Name() string
+	// GetKlines 获取最近的 limit 根K线
+	GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error)
+	// GetKlinesSince 获取开盘时间不早于 startTime（毫秒）的K线，最多 limit 根
+	GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error)
+}
+
+// KlineProviderFactory 根据配置创建K线提供方
+type KlineProviderFactory func(cfg *config.Config, source config.KlineSource) (KlineProvider, error)
+
+var (
+	providerMu        sync.RWMutex
+	providerFactories = make(map[string]KlineProviderFactory)
+)
+
+// RegisterKlineProvider 注册K线提供方，同名会 panic，供 init 使用
+func RegisterKlineProvider(name string, factory KlineProviderFactory) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if _, exists := providerFactories[name]; exists {
+		panic(fmt.Sprintf("K线提供方已存在: %s", name))
+	}
+	providerFactories[name] = factory
+}
+
+// KlineProviderNames 返回所有已注册提供方的名称（已排序）
+func KlineProviderNames() []string {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewKlineProvider 按来源配置创建K线提供方
+func NewKlineProvider(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+	providerMu.RLock()
+	factory, ok := providerFactories[source.Provider]
+	providerMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的K线提供方: %s（可用: %v）", source.Provider, KlineProviderNames())
+	}
+	return factory(cfg, source)
+}
+
+// ValidateKlineSources 检查所有币种的K线来源能否创建，供启动和热加载前使用
+func ValidateKlineSources(cfg *config.Config) error {
+	for _, symbol := range cfg.Symbols {
+		if _, err := NewKlineProvider(cfg, cfg.SourceFor(symbol)); err != nil {
+			return fmt.Errorf("%s 的K线来源配置错误: %v", symbol, err)
+		}
+	}
+	return nil
+}
+
+// KlineSourceBinding 币种绑定的提供方及其在交易所中的交易对
+type KlineSourceBinding struct {
+	Provider KlineProvider
+	Symbol   string
+}
+
+// NewKlineSourceBindings 为配置中的每个币种创建K线来源
+func NewKlineSourceBindings(cfg *config.Config) (map[string]KlineSourceBinding, error) {
+	bindings := make(map[string]KlineSourceBinding, len(cfg.Symbols))
+	for _, symbol := range cfg.Symbols {
+		source := cfg.SourceFor(symbol)
+		provider, err := NewKlineProvider(cfg, source)
+		if err != nil {
+			return nil, fmt.Errorf("%s 的K线来源配置错误: %v", symbol, err)
+		}
+		bindings[symbol] = KlineSourceBinding{Provider: provider, Symbol: source.Symbol}
+	}
+	return bindings, nil
+}
+
+// newProxyHTTPClient 创建 HTTP 客户端，proxy 为空时使用环境变量中的代理
+func newProxyHTTPClient(proxy string, timeout time.Duration) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("代理地址 %s 无效", config.RedactURL(proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// httpGetWithRetry 发出 GET 请求，网络错误、5xx 和 429 按指数退避重试（429 优先使用 Retry-After）。
+// 供没有单独限速器的交易所使用，ctx 取消时立即返回
+func httpGetWithRetry(ctx context.Context, client *http.Client, rawURL string, maxRetries int) ([]byte, error) {
+	b := &backoff.Backoff{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: true}
+
+	for attempt := 0; ; attempt++ {
+		body, delay, err := httpGetOnce(ctx, client, rawURL)
+		if err == nil {
+			return body, nil
+		}
+		if delay < 0 || attempt >= maxRetries || ctx.Err() != nil {
+			if attempt > 0 {
+				return nil, fmt.Errorf("请求K线数据失败(已重试%d次): %w", attempt, err)
+			}
+			return nil, err
+		}
+		if delay == 0 {
+			delay = b.Duration()
+		}
+		log.Printf("请求失败: %v，%v 后进行第%d次重试...", err, delay.Round(time.Millisecond), attempt+1)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext 等待 d，ctx 先取消时返回 ctx 的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// httpGetOnce 发出一次请求。失败时 delay < 0 表示不可重试，0 表示按退避等待，> 0 为服务端要求的等待时间
+func httpGetOnce(ctx context.Context, client *http.Client, rawURL string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, -1, fmt.Errorf("创建请求失败: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("请求K线数据失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("读取响应内容失败: %v", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header, 0)
+		return nil, retryAfter, &RateLimitError{RetryAfter: retryAfter}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, 0, &APIError{StatusCode: resp.StatusCode, Msg: string(body)}
+	default:
+		return nil, -1, &APIError{StatusCode: resp.StatusCode, Msg: string(body)}
+	}
+}
+
+// candleCloseTime 按周期计算K线的收盘时间（毫秒），与币安一致为下一根开盘时间 - 1。
+// 1M 按自然月计算，其余周期为固定时长
+func candleCloseTime(openTime int64, interval string) (int64, error) {
+	open := time.UnixMilli(openTime).UTC()
+	switch interval {
+	case "1M":
+		return open.AddDate(0, 1, 0).UnixMilli() - 1, nil
+	case "1w":
+		return open.AddDate(0, 0, 7).UnixMilli() - 1, nil
+	}
+
+	d, err := intervalDuration(interval)
+	if err != nil {
+		return 0, err
+	}
+	return openTime + d.Milliseconds() - 1, nil
+}
+
+// intervalDuration 返回固定时长周期（分钟、小时、天）的长度，1w 和 1M 需要按日历计算
+func intervalDuration(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("无法识别的周期: %s", interval)
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("无法识别的周期: %s", interval)
+	}
+	var unit time.Duration
+	switch interval[len(interval)-1] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("无法识别的周期: %s", interval)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// errUnsupportedInterval 交易所不支持该周期
+func errUnsupportedInterval(provider, interval string) error {
+	return fmt.Errorf("%s 不支持周期 %s", provider, interval)
+}
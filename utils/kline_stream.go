@@ -0,0 +1,409 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamReadTimeout 超过该时间没有收到任何消息视为连接失效并重连
+const streamReadTimeout = 5 * time.Minute
+
+// closedQueueSize 待分析的收盘K线队列长度，分析跟不上推送时读循环才会等待
+const closedQueueSize = 256
+
+// maxStreamsPerConn 币安合约单个 WebSocket 连接最多订阅的流数，超出时分多个连接订阅
+const maxStreamsPerConn = 200
+
+// KlineFetcher 通过 REST 获取K线，用于启动和断线后的回补
+type KlineFetcher func(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error)
+
+// KlineClosedHandler K线收盘时回调，klines 为包含刚收盘K线在内的缓冲区副本，ctx 为 Run 的 ctx
+type KlineClosedHandler func(ctx context.Context, symbol, interval string, klines []KlineData)
+
+// KlineStream 订阅币安合约K线 WebSocket 推送，维护每个币种周期的K线缓冲区，
+// 在收到收盘（x=true）推送时把K线放入队列，由单独的协程按顺序触发回调，分析和写库不阻塞读取推送。
+// 订阅按 maxStreamsPerConn 分到多个连接，每个连接断开后单独重连；每次（重新）连接后都会
+// 按当前配置订阅并通过 REST 回补该连接的币种周期，推送中发现缺口时也会回补。
+type KlineStream struct {
+	fetch    KlineFetcher
+	onClosed KlineClosedHandler
+	buffer   *KlineBuffer
+	closed   chan closedKlines
+	perConn  int
+
+	mu      sync.Mutex
+	restart context.CancelFunc
+}
+
+// NewKlineStream 创建K线推送订阅
+func NewKlineStream(fetch KlineFetcher, onClosed KlineClosedHandler) *KlineStream {
+	return &KlineStream{
+		fetch:    fetch,
+		onClosed: onClosed,
+		buffer:   NewKlineBuffer(config.Get().Stream.BufferSize),
+		closed:   make(chan closedKlines, closedQueueSize),
+		perConn:  maxStreamsPerConn,
+	}
+}
+
+// streamTarget 一个订阅的币种周期
+type streamTarget struct {
+	symbol   string // 配置中的币种
+	contract string // 合约交易对
+	interval string
+}
+
+// name 返回组合流中的流名称，如 btcusdt@kline_1h
+func (t streamTarget) name() string {
+	return fmt.Sprintf("%s@kline_%s", strings.ToLower(t.contract), t.interval)
+}
+
+// closedKlines 等待分析的收盘K线
+type closedKlines struct {
+	symbol   string
+	interval string
+	klines   []KlineData
+}
+
+// Buffer 返回K线缓冲区
+func (s *KlineStream) Buffer() *KlineBuffer {
+	return s.buffer
+}
+
+// klineEvent 组合流推送的K线消息
+type klineEvent struct {
+	Stream string `json:"stream"`
+	Data   struct {
+		EventType string `json:"e"`
+		Symbol    string `json:"s"`
+		Kline     struct {
+			OpenTime            int64  `json:"t"`
+			CloseTime           int64  `json:"T"`
+			Interval            string `json:"i"`
+			Open                string `json:"o"`
+			Close               string `json:"c"`
+			High                string `json:"h"`
+			Low                 string `json:"l"`
+			Volume              string `json:"v"`
+			NumberOfTrades      int64  `json:"n"`
+			Closed              bool   `json:"x"`
+			QuoteAssetVolume    string `json:"q"`
+			TakerBuyBaseVolume  string `json:"V"`
+			TakerBuyQuoteVolume string `json:"Q"`
+		} `json:"k"`
+	} `json:"data"`
+}
+
+// toKline 转换为 KlineData，数值字段无法解析时返回错误
+func (e *klineEvent) toKline() (KlineData, error) {
+	k := e.Data.Kline
+	var kline KlineData
+	fields := []struct {
+		name string
+		raw  string
+		dst  *float64
+	}{
+		{"o", k.Open, &kline.Open},
+		{"h", k.High, &kline.High},
+		{"l", k.Low, &kline.Low},
+		{"c", k.Close, &kline.Close},
+		{"v", k.Volume, &kline.Volume},
+		{"q", k.QuoteAssetVolume, &kline.QuoteAssetVolume},
+		{"V", k.TakerBuyBaseVolume, &kline.TakerBuyBaseAssetVolume},
+		{"Q", k.TakerBuyQuoteVolume, &kline.TakerBuyQuoteAssetVolume},
+	}
+	for _, f := range fields {
+		v, err := parseKlineFloat(f.raw)
+		if err != nil {
+			return KlineData{}, &KlineError{OpenTime: k.OpenTime, Field: f.name, Value: f.raw, Reason: err.Error()}
+		}
+		*f.dst = v
+	}
+
+	kline.OpenTime = k.OpenTime
+	kline.CloseTime = k.CloseTime
+	kline.NumberOfTrades = k.NumberOfTrades
+	return kline, nil
+}
+
+// streamSymbols 返回可以通过推送订阅的币种（K线来源为币安 U 本位合约），key 为合约交易对
+func streamSymbols(cfg *config.Config) map[string]string {
+	symbols := make(map[string]string)
+	for _, symbol := range cfg.Symbols {
+		if source := cfg.SourceFor(symbol); source.Provider == "binance_futures" {
+			symbols[source.Symbol] = symbol
+		}
+	}
+	return symbols
+}
+
+// UnstreamedSymbols 返回不能通过推送订阅、需要定时调度分析的币种，按配置顺序排列
+func UnstreamedSymbols(cfg *config.Config) []string {
+	var symbols []string
+	for _, symbol := range cfg.Symbols {
+		if cfg.SourceFor(symbol).Provider != "binance_futures" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// streamChunks 按配置顺序列出要订阅的币种周期，每 perConn 个分为一组，每组使用一个连接
+func streamChunks(cfg *config.Config, symbols map[string]string, perConn int) [][]streamTarget {
+	var targets []streamTarget
+	seen := make(map[string]bool)
+	for _, symbol := range cfg.Symbols {
+		source := cfg.SourceFor(symbol)
+		if _, ok := symbols[source.Symbol]; !ok || seen[source.Symbol] {
+			continue
+		}
+		seen[source.Symbol] = true
+		for _, interval := range cfg.Intervals {
+			targets = append(targets, streamTarget{symbol: symbols[source.Symbol], contract: source.Symbol, interval: interval})
+		}
+	}
+
+	var chunks [][]streamTarget
+	for len(targets) > 0 {
+		n := min(perConn, len(targets))
+		chunks = append(chunks, targets[:n])
+		targets = targets[n:]
+	}
+	return chunks
+}
+
+// streamURL 生成组合流地址，如 wss://fstream.binance.com/stream?streams=btcusdt@kline_1h/...
+func streamURL(cfg *config.Config, targets []streamTarget) string {
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.name()
+	}
+	return fmt.Sprintf("%s/stream?streams=%s", strings.TrimRight(cfg.Stream.WSURL, "/"), strings.Join(names, "/"))
+}
+
+// newDialer 创建 WebSocket 拨号器，代理设置与 REST 客户端一致
+func newDialer(cfg *config.Config) (*websocket.Dialer, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("代理地址 %s 无效", config.RedactURL(cfg.ProxyURL))
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+	return dialer, nil
+}
+
+// Run 按当前配置连接并持续接收推送，直到 ctx 取消；Reconnect 后按最新配置重新分组订阅。
+// 返回前等待正在执行的回调结束
+func (s *KlineStream) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.dispatch(ctx)
+	}()
+	defer wg.Wait()
+
+	for ctx.Err() == nil {
+		s.runSession(ctx)
+	}
+}
+
+// Reconnect 断开所有连接，Run 会按最新配置重新订阅，用于币种或周期变化后
+func (s *KlineStream) Reconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.restart != nil {
+		s.restart()
+	}
+}
+
+// runSession 按当前配置分组，每组一个连接，直到 ctx 取消或 Reconnect
+func (s *KlineStream) runSession(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.restart = cancel
+	s.mu.Unlock()
+
+	cfg := config.Get()
+	symbols := streamSymbols(cfg)
+	chunks := streamChunks(cfg, symbols, s.perConn)
+	if len(chunks) == 0 {
+		log.Printf("[Stream] 没有K线来源为 binance_futures 的币种，等待配置变化")
+		<-ctx.Done()
+		return
+	}
+	log.Printf("[Stream] 订阅 %d 个币种 × %d 个周期，共 %d 个连接", len(symbols), len(cfg.Intervals), len(chunks))
+	if len(symbols) < len(cfg.Symbols) {
+		log.Printf("[Stream] K线来源不是 binance_futures 的币种由定时调度分析")
+	}
+
+	var wg sync.WaitGroup
+	for i, targets := range chunks {
+		wg.Add(1)
+		go func(name string, targets []streamTarget) {
+			defer wg.Done()
+			s.keepConnected(ctx, name, targets, symbols)
+		}(fmt.Sprintf("%d/%d", i+1, len(chunks)), targets)
+	}
+	wg.Wait()
+}
+
+// keepConnected 保持一个连接，断线后按 stream.reconnect_delay 重连，直到 ctx 取消
+func (s *KlineStream) keepConnected(ctx context.Context, name string, targets []streamTarget, symbols map[string]string) {
+	for {
+		if err := s.runOnce(ctx, targets, symbols); err != nil {
+			log.Printf("[Stream] 连接 %s 中断: %v", name, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := time.Duration(config.Get().Stream.ReconnectDelay) * time.Second
+		log.Printf("[Stream] 连接 %s %v 后重连", name, delay)
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// runOnce 建立一次连接：订阅 targets、回补，然后读取推送直到出错或 ctx 取消
+func (s *KlineStream) runOnce(ctx context.Context, targets []streamTarget, symbols map[string]string) error {
+	cfg := config.Get()
+	dialer, err := newDialer(cfg)
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := dialer.DialContext(ctx, streamURL(cfg, targets), nil)
+	if err != nil {
+		return fmt.Errorf("连接 WebSocket 失败: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	// 先订阅再回补，保证回补结果与推送之间没有遗漏
+	for _, t := range targets {
+		s.backfill(ctx, t.symbol, t.interval)
+	}
+
+	// 收到 ping 或任何消息都延长读超时
+	conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		s.handleMessage(ctx, message, symbols)
+	}
+}
+
+// dispatch 按收到的顺序对收盘K线触发回调，直到 ctx 取消
+func (s *KlineStream) dispatch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-s.closed:
+			if s.onClosed != nil {
+				s.onClosed(ctx, c.symbol, c.interval, c.klines)
+			}
+		}
+	}
+}
+
+// backfill 通过 REST 回补某个币种周期的K线
+func (s *KlineStream) backfill(ctx context.Context, symbol, interval string) {
+	klines, err := s.fetch(ctx, symbol, interval, config.Get().Stream.BufferSize)
+	if err != nil {
+		log.Printf("[Stream] 回补 %s %s K线失败: %v", symbol, interval, err)
+		return
+	}
+	s.buffer.Set(symbol, interval, klines)
+}
+
+// handleMessage 处理一条推送消息，symbols 为合约交易对到币种的对应关系
+func (s *KlineStream) handleMessage(ctx context.Context, message []byte, symbols map[string]string) {
+	var event klineEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		log.Printf("[Stream] 解析推送消息失败: %v", err)
+		return
+	}
+	if event.Data.EventType != "kline" {
+		return
+	}
+
+	kline, err := event.toKline()
+	if err != nil {
+		log.Printf("[Stream] %s K线数据无效: %v", event.Stream, err)
+		return
+	}
+
+	symbol, ok := symbols[event.Data.Symbol]
+	if !ok {
+		return
+	}
+	interval := event.Data.Kline.Interval
+	if s.buffer.Update(symbol, interval, kline) {
+		log.Printf("[Stream] %s %s K线存在缺口，重新回补", symbol, interval)
+		s.backfill(ctx, symbol, interval)
+		// 回补结果可能还不包含这根K线，以推送为准
+		s.buffer.Update(symbol, interval, kline)
+	}
+
+	// 是否收盘以推送的 x 标记为准，不按本地时钟判断
+	if !event.Data.Kline.Closed || s.onClosed == nil {
+		return
+	}
+
+	// 只分析到刚收盘的这根K线为止
+	klines := s.buffer.Klines(symbol, interval)
+	for len(klines) > 0 && klines[len(klines)-1].OpenTime > kline.OpenTime {
+		klines = klines[:len(klines)-1]
+	}
+
+	c := closedKlines{symbol: symbol, interval: interval, klines: klines}
+	select {
+	case s.closed <- c:
+		return
+	default:
+	}
+	log.Printf("[Stream] 待分析队列已满（%d），等待分析完成", cap(s.closed))
+	select {
+	case s.closed <- c:
+	case <-ctx.Done():
+	}
+}
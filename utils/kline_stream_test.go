@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// klineMessage 生成组合流推送消息
+func klineMessage(k KlineData, closed bool) string {
+	return fmt.Sprintf(`{"stream":"btcusdt@kline_1m","data":{"e":"kline","s":"BTCUSDT","k":{`+
+		`"t":%d,"T":%d,"i":"1m","o":"%g","c":"%g","h":"%g","l":"%g","v":"%g","n":1,"x":%v,"q":"0","V":"0","Q":"0"}}}`,
+		k.OpenTime, k.CloseTime, k.Open, k.Close, k.High, k.Low, k.Volume, closed)
+}
+
+// fakeKlineServer 模拟币安组合流：第 i 次连接依次发送 scripts[i] 中的消息，
+// 不是最后一个脚本时随后断开连接，触发重连
+type fakeKlineServer struct {
+	*httptest.Server
+	scripts [][]string
+
+	mu    sync.Mutex
+	conns int
+	paths []string
+}
+
+func newFakeKlineServer(t *testing.T, scripts ...[]string) *fakeKlineServer {
+	f := &fakeKlineServer{scripts: scripts}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		f.mu.Lock()
+		i := f.conns
+		f.conns++
+		f.paths = append(f.paths, r.URL.RequestURI())
+		f.mu.Unlock()
+		if i >= len(f.scripts) {
+			i = len(f.scripts) - 1
+		}
+
+		for _, msg := range f.scripts[i] {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				return
+			}
+		}
+		if i < len(f.scripts)-1 {
+			return
+		}
+		// 最后一个连接保持到客户端断开
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// TestKlineStream 覆盖连接后回补、x 标记判断收盘、缺口回补、断线重连，且回调不阻塞读取推送
+func TestKlineStream(t *testing.T) {
+	// 夹具K线的收盘时间都早于当前时间，未收盘与否只能由 x 标记决定
+	base := fixtureKlines(20, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Minute)
+	srv := newFakeKlineServer(t,
+		[]string{
+			klineMessage(base[5], false),
+			klineMessage(base[5], true),
+			klineMessage(base[8], true), // 6、7 缺失，需要回补
+		},
+		[]string{
+			klineMessage(base[10], true),
+		},
+	)
+
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.Symbols = []string{"BTCUSDT"}
+	cfg.Intervals = []string{"1m"}
+	cfg.Stream.WSURL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	cfg.Stream.BufferSize = 100
+	cfg.Stream.ReconnectDelay = 0
+	setTestConfig(t, cfg)
+
+	// 第 1 次为连接后回补，第 2 次为缺口回补（还不包含推送的那根），第 3 次为重连后回补
+	backfills := []int{5, 8, 10}
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	fetched := make(chan int, 10)
+	fetch := func(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+		if symbol != "BTCUSDT" || interval != "1m" || limit != 100 {
+			t.Errorf("fetch(%s, %s, %d)", symbol, interval, limit)
+		}
+		mu.Lock()
+		n := backfills[min(fetches, len(backfills)-1)]
+		fetches++
+		mu.Unlock()
+		fetched <- fetches
+		return base[:n], nil
+	}
+
+	type call struct {
+		last   int64
+		n      int
+		gapped bool
+	}
+	calls := make(chan call, 10)
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := NewKlineStream(fetch, func(ctx context.Context, symbol, interval string, klines []KlineData) {
+		c := call{last: klines[len(klines)-1].OpenTime, n: len(klines)}
+		for i := 1; i < len(klines); i++ {
+			if klines[i].OpenTime != klines[i-1].CloseTime+1 {
+				c.gapped = true
+			}
+		}
+		calls <- c
+		<-release
+	})
+	done := make(chan struct{})
+	go func() {
+		stream.Run(ctx)
+		close(done)
+	}()
+
+	wait := func(what string) call {
+		t.Helper()
+		select {
+		case c := <-calls:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		return call{}
+	}
+	waitFetch := func(want int) {
+		t.Helper()
+		for {
+			select {
+			case n := <-fetched:
+				if n >= want {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for fetch #%d", want)
+			}
+		}
+	}
+
+	// x=false 的推送不触发回调，x=true 时回调包含回补的K线和刚收盘的K线
+	first := wait("first closed kline")
+	if first.last != base[5].OpenTime || first.n != 6 || first.gapped {
+		t.Fatalf("first callback = %+v, want last=%d n=6", first, base[5].OpenTime)
+	}
+	// 回调仍在执行时读循环继续处理推送：发现缺口并回补
+	waitFetch(2)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		got := stream.Buffer().Klines("BTCUSDT", "1m")
+		if got[len(got)-1].OpenTime == base[8].OpenTime && len(got) == 9 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("buffer after gap backfill ends at %d with %d klines", got[len(got)-1].OpenTime, len(got))
+		}
+	}
+	release <- struct{}{}
+
+	second := wait("kline after gap")
+	if second.last != base[8].OpenTime || second.n != 9 || second.gapped {
+		t.Fatalf("second callback = %+v, want last=%d n=9 without gaps", second, base[8].OpenTime)
+	}
+	release <- struct{}{}
+
+	// 服务端断开后重连，重新订阅并回补
+	third := wait("kline after reconnect")
+	if third.last != base[10].OpenTime || third.n != 11 || third.gapped {
+		t.Fatalf("third callback = %+v, want last=%d n=11", third, base[10].OpenTime)
+	}
+	close(release)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.conns != 2 {
+		t.Fatalf("connections = %d, want 2", srv.conns)
+	}
+	for _, path := range srv.paths {
+		if path != "/stream?streams=btcusdt@kline_1m" {
+			t.Fatalf("subscribed %q", path)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 3 {
+		t.Fatalf("fetches = %d, want 3", fetches)
+	}
+}
+
+// TestStreamChunks 订阅按配置顺序分组，每个连接不超过 maxStreamsPerConn 个流，非币安合约的币种不订阅
+func TestStreamChunks(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Symbols = nil
+	for i := 0; i < 41; i++ {
+		cfg.Symbols = append(cfg.Symbols, fmt.Sprintf("C%02dUSDT", i))
+	}
+	cfg.Symbols = append(cfg.Symbols, "OKXUSDT", "ALIASUSDT")
+	cfg.Intervals = []string{"5m", "15m", "1h", "4h", "1d"}
+	cfg.KlineSources = map[string]config.KlineSource{
+		"OKXUSDT":   {Provider: "okx", Symbol: "BTC-USDT-SWAP"},
+		"ALIASUSDT": {Symbol: "C00USDT"}, // 与已订阅的合约相同
+	}
+	cfg.Stream.WSURL = "wss://example.com/"
+
+	if got := UnstreamedSymbols(cfg); len(got) != 1 || got[0] != "OKXUSDT" {
+		t.Fatalf("UnstreamedSymbols = %v", got)
+	}
+
+	chunks := streamChunks(cfg, streamSymbols(cfg), maxStreamsPerConn)
+	if len(chunks) != 2 || len(chunks[0]) != 200 || len(chunks[1]) != 5 {
+		t.Fatalf("chunk sizes = %d", len(chunks))
+	}
+	if first := chunks[0][0]; first.name() != "c00usdt@kline_5m" {
+		t.Fatalf("first stream = %s", first.name())
+	}
+	last := chunks[1]
+	for i, interval := range cfg.Intervals {
+		if last[i].symbol != "C40USDT" || last[i].interval != interval {
+			t.Fatalf("second chunk[%d] = %+v", i, last[i])
+		}
+	}
+	want := "wss://example.com/stream?streams=c40usdt@kline_5m/c40usdt@kline_15m/c40usdt@kline_1h/c40usdt@kline_4h/c40usdt@kline_1d"
+	if got := streamURL(cfg, last); got != want {
+		t.Fatalf("streamURL = %s", got)
+	}
+}
+
+// TestKlineStreamSplitsConnections 超过单连接上限时分多个连接订阅并分别回补，Reconnect 后全部重新订阅
+func TestKlineStreamSplitsConnections(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		mu.Lock()
+		paths = append(paths, r.URL.RequestURI())
+		mu.Unlock()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.Symbols = []string{"BTCUSDT", "ETHUSDT"}
+	cfg.Intervals = []string{"1m"}
+	cfg.Stream.WSURL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	cfg.Stream.ReconnectDelay = 0
+	setTestConfig(t, cfg)
+
+	fetched := make(chan string, 10)
+	stream := NewKlineStream(func(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+		fetched <- symbol
+		return nil, nil
+	}, nil)
+	stream.perConn = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stream.Run(ctx)
+		close(done)
+	}()
+	waitFetches := func(n int) map[string]int {
+		t.Helper()
+		got := make(map[string]int)
+		for i := 0; i < n; i++ {
+			select {
+			case symbol := <-fetched:
+				got[symbol]++
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for backfill, got %v", got)
+			}
+		}
+		return got
+	}
+
+	if got := waitFetches(2); got["BTCUSDT"] != 1 || got["ETHUSDT"] != 1 {
+		t.Fatalf("backfills = %v", got)
+	}
+	stream.Reconnect()
+	if got := waitFetches(2); got["BTCUSDT"] != 1 || got["ETHUSDT"] != 1 {
+		t.Fatalf("backfills after reconnect = %v", got)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	// 服务端在握手完成后才记录，等待记录完成
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(paths)
+		mu.Unlock()
+		if n >= 4 || time.Now().After(deadline) {
+			break
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	count := make(map[string]int)
+	for _, p := range paths {
+		count[p]++
+	}
+	if len(paths) != 4 || count["/stream?streams=btcusdt@kline_1m"] != 2 || count["/stream?streams=ethusdt@kline_1m"] != 2 {
+		t.Fatalf("subscriptions = %v", paths)
+	}
+}
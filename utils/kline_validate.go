@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// KlineError K线数据无效，指出出错的行和字段。Index 为在响应或序列中的位置（从 0 开始）
+type KlineError struct {
+	Index    int
+	OpenTime int64 // 已解析出时为该K线的开盘时间（毫秒）
+	Field    string
+	Value    string
+	Reason   string
+}
+
+func (e *KlineError) Error() string {
+	msg := fmt.Sprintf("K线数据无效: 第 %d 根", e.Index)
+	if e.OpenTime > 0 {
+		msg += fmt.Sprintf("(开盘时间 %s)", time.UnixMilli(e.OpenTime).Format("2006-01-02 15:04"))
+	}
+	if e.Field != "" {
+		msg += fmt.Sprintf(" 字段 %s=%s", e.Field, e.Value)
+	}
+	return msg + ": " + e.Reason
+}
+
+// binanceKlineFields 币安K线数组中各位置的字段名
+var binanceKlineFields = []string{
+	"open_time", "open", "high", "low", "close", "volume", "close_time",
+	"quote_asset_volume", "number_of_trades", "taker_buy_base_volume", "taker_buy_quote_volume",
+}
+
+// binanceKlineRow 币安K线数组的一行，按位置严格解码：时间和成交笔数必须为整数，价格和成交量必须为数字字符串
+type binanceKlineRow struct {
+	KlineData
+}
+
+// UnmarshalJSON 解码一行K线，出错时返回不带行号的 *KlineError，由调用方补充
+func (r *binanceKlineRow) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return &KlineError{Value: string(data), Reason: "不是数组"}
+	}
+	if len(raw) < len(binanceKlineFields) {
+		return &KlineError{Value: string(data), Reason: fmt.Sprintf("字段数量不足: %d/%d", len(raw), len(binanceKlineFields))}
+	}
+
+	k := &r.KlineData
+	ints := map[int]*int64{0: &k.OpenTime, 6: &k.CloseTime, 8: &k.NumberOfTrades}
+	floats := map[int]*float64{
+		1: &k.Open, 2: &k.High, 3: &k.Low, 4: &k.Close, 5: &k.Volume,
+		7: &k.QuoteAssetVolume, 9: &k.TakerBuyBaseAssetVolume, 10: &k.TakerBuyQuoteAssetVolume,
+	}
+	for i, field := range binanceKlineFields {
+		if dst, ok := ints[i]; ok {
+			if err := json.Unmarshal(raw[i], dst); err != nil {
+				return &KlineError{OpenTime: k.OpenTime, Field: field, Value: string(raw[i]), Reason: "应为整数"}
+			}
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(raw[i], &s); err != nil {
+			return &KlineError{OpenTime: k.OpenTime, Field: field, Value: string(raw[i]), Reason: "应为数字字符串"}
+		}
+		v, err := parseKlineFloat(s)
+		if err != nil {
+			return &KlineError{OpenTime: k.OpenTime, Field: field, Value: s, Reason: err.Error()}
+		}
+		*floats[i] = v
+	}
+	return nil
+}
+
+// parseBinanceKlines 严格解析币安K线响应
+func parseBinanceKlines(body []byte) ([]KlineData, error) {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("解析K线数据失败: %v", err)
+	}
+
+	klines := make([]KlineData, len(rows))
+	for i, data := range rows {
+		var row binanceKlineRow
+		if err := json.Unmarshal(data, &row); err != nil {
+			if kerr, ok := err.(*KlineError); ok {
+				kerr.Index = i
+				return nil, kerr
+			}
+			return nil, &KlineError{Index: i, Value: string(data), Reason: err.Error()}
+		}
+		klines[i] = row.KlineData
+	}
+	return klines, nil
+}
+
+// parseKlineFloat 解析价格或成交量，拒绝 NaN 和无穷大
+func parseKlineFloat(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("不是有效的数字")
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("不是有限的数字")
+	}
+	return v, nil
+}
+
+// ValidateKlines 检查K线序列：价格为正且 low ≤ open/close ≤ high，成交量非负，
+// 收盘时间晚于开盘时间，开盘时间严格递增（无重复）。返回第一个问题
+func ValidateKlines(klines []KlineData) error {
+	for i, k := range klines {
+		fail := func(field string, value float64, reason string) error {
+			return &KlineError{
+				Index:    i,
+				OpenTime: k.OpenTime,
+				Field:    field,
+				Value:    strconv.FormatFloat(value, 'f', -1, 64),
+				Reason:   reason,
+			}
+		}
+
+		if k.OpenTime <= 0 {
+			return &KlineError{Index: i, Field: "open_time", Value: strconv.FormatInt(k.OpenTime, 10), Reason: "开盘时间无效"}
+		}
+		if k.CloseTime <= k.OpenTime {
+			return &KlineError{Index: i, OpenTime: k.OpenTime, Field: "close_time", Value: strconv.FormatInt(k.CloseTime, 10), Reason: "收盘时间不晚于开盘时间"}
+		}
+		if i > 0 {
+			prev := klines[i-1].OpenTime
+			if k.OpenTime == prev {
+				return &KlineError{Index: i, OpenTime: k.OpenTime, Reason: "与上一根K线开盘时间重复"}
+			}
+			if k.OpenTime < prev {
+				return &KlineError{Index: i, OpenTime: k.OpenTime, Reason: "开盘时间早于上一根K线"}
+			}
+		}
+
+		if k.Low <= 0 {
+			return fail("low", k.Low, "价格必须为正数")
+		}
+		if k.High < k.Low {
+			return fail("high", k.High, fmt.Sprintf("低于最低价 %v", k.Low))
+		}
+		if k.Open < k.Low || k.Open > k.High {
+			return fail("open", k.Open, fmt.Sprintf("不在最低价 %v 和最高价 %v 之间", k.Low, k.High))
+		}
+		if k.Close < k.Low || k.Close > k.High {
+			return fail("close", k.Close, fmt.Sprintf("不在最低价 %v 和最高价 %v 之间", k.Low, k.High))
+		}
+		if k.Volume < 0 {
+			return fail("volume", k.Volume, "成交量不能为负数")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// binanceJSONRow 生成一行币安K线 JSON，open 为开盘时间（毫秒），prices 依次为 open/high/low/close
+func binanceJSONRow(open int64, prices ...string) string {
+	return fmt.Sprintf(`[%d,%s,"12.5",%d,"1000",42,"6","500","0"]`,
+		open, `"`+strings.Join(prices, `","`)+`"`, open+59999)
+}
+
+func TestParseBinanceKlines(t *testing.T) {
+	good := binanceJSONRow(60000, "100", "110", "90", "105")
+	parsed, err := parseBinanceKlines([]byte("[" + good + "," + binanceJSONRow(120000, "105", "106", "104", "104.5") + "]"))
+	if err != nil {
+		t.Fatalf("parseBinanceKlines: %v", err)
+	}
+	want := KlineData{
+		OpenTime: 60000, Open: 100, High: 110, Low: 90, Close: 105, Volume: 12.5, CloseTime: 119999,
+		QuoteAssetVolume: 1000, NumberOfTrades: 42, TakerBuyBaseAssetVolume: 6, TakerBuyQuoteAssetVolume: 500,
+	}
+	if len(parsed) != 2 || parsed[0] != want || parsed[1].Close != 104.5 {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		row   string
+		field string
+		value string
+	}{
+		{"numeric price", `[60000,100,"110","90","105","12.5",119999,"1000",42,"6","500","0"]`, "open", "100"},
+		{"null close", `[60000,"100","110","90",null,"12.5",119999,"1000",42,"6","500","0"]`, "close", ""},
+		{"NaN", binanceJSONRow(60000, "100", "NaN", "90", "105"), "high", "NaN"},
+		{"infinite", binanceJSONRow(60000, "100", "110", "-Inf", "105"), "low", "-Inf"},
+		{"not a number", binanceJSONRow(60000, "100", "110", "90", "1o5"), "close", "1o5"},
+		{"string open time", `["60000","100","110","90","105","12.5",119999,"1000",42,"6","500","0"]`, "open_time", `"60000"`},
+		{"fractional trades", `[60000,"100","110","90","105","12.5",119999,"1000",4.2,"6","500","0"]`, "number_of_trades", "4.2"},
+		{"short row", `[60000,"100","110","90","105","12.5"]`, "", ""},
+		{"not an array", `{"open_time":60000}`, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// 出问题的是第 2 行（下标 1）
+			_, err := parseBinanceKlines([]byte("[" + good + "," + tt.row + "]"))
+			var kerr *KlineError
+			if !errors.As(err, &kerr) {
+				t.Fatalf("err = %v, want *KlineError", err)
+			}
+			if kerr.Index != 1 || kerr.Field != tt.field {
+				t.Fatalf("index=%d field=%q, want 1 %q (%v)", kerr.Index, kerr.Field, tt.field, err)
+			}
+			if tt.value != "" && kerr.Value != tt.value {
+				t.Fatalf("value = %q, want %q", kerr.Value, tt.value)
+			}
+		})
+	}
+
+	if _, err := parseBinanceKlines([]byte(`{"code":-1121,"msg":"Invalid symbol."}`)); err == nil {
+		t.Fatal("parsed an error response")
+	}
+}
+
+func TestValidateKlines(t *testing.T) {
+	kline := func(open int64, o, h, l, c float64) KlineData {
+		return KlineData{OpenTime: open, Open: o, High: h, Low: l, Close: c, Volume: 1, CloseTime: open + 59999}
+	}
+	good := []KlineData{kline(60000, 100, 110, 90, 105), kline(120000, 105, 106, 104, 104.5)}
+	if err := ValidateKlines(good); err != nil {
+		t.Fatalf("valid klines: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		bad    KlineData
+		field  string
+		reason string
+	}{
+		{"duplicate open time", kline(120000, 105, 106, 104, 105), "", "重复"},
+		{"decreasing open time", kline(90000, 105, 106, 104, 105), "", "早于上一根"},
+		{"zero open time", kline(0, 105, 106, 104, 105), "open_time", "开盘时间无效"},
+		{"close before open", KlineData{OpenTime: 180000, Open: 105, High: 106, Low: 104, Close: 105, CloseTime: 180000}, "close_time", "收盘时间"},
+		{"close above high", kline(180000, 105, 106, 104, 107), "close", "不在最低价"},
+		{"close below low", kline(180000, 105, 106, 104, 103.9), "close", "不在最低价"},
+		{"open outside range", kline(180000, 103, 106, 104, 105), "open", "不在最低价"},
+		{"high below low", kline(180000, 105, 103, 104, 105), "high", "低于最低价"},
+		{"zero price", kline(180000, 0, 0, 0, 0), "low", "正数"},
+		{"negative volume", KlineData{OpenTime: 180000, Open: 105, High: 106, Low: 104, Close: 105, Volume: -1, CloseTime: 239999}, "volume", "负数"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// 问题K线放在第 3 根（下标 2），前面的K线有效
+			klines := append(append([]KlineData{}, good...), tt.bad)
+			err := ValidateKlines(klines)
+			var kerr *KlineError
+			if !errors.As(err, &kerr) {
+				t.Fatalf("err = %v, want *KlineError", err)
+			}
+			if kerr.Index != 2 || kerr.Field != tt.field || !strings.Contains(kerr.Reason, tt.reason) {
+				t.Fatalf("index=%d field=%q reason=%q, want 2 %q %q", kerr.Index, kerr.Field, kerr.Reason, tt.field, tt.reason)
+			}
+			if tt.bad.OpenTime > 0 && kerr.OpenTime != tt.bad.OpenTime {
+				t.Fatalf("open time = %d, want %d", kerr.OpenTime, tt.bad.OpenTime)
+			}
+		})
+	}
+}
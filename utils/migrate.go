@@ -0,0 +1,352 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// 迁移文件按方言和作用域组织：
+//
+//	migrations/<dialect>/interval/NNNN_name.up.sql   每个周期表各执行一次，{{.Table}} 为表名
+//	migrations/<dialect>/global/NNNN_name.up.sql     全局执行一次
+//
+// 每个作用域（周期表名或 _global）在 schema_migrations 中单独记录已执行的版本，
+// 配置中新增周期时只需对新表从头执行即可。
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+// GlobalMigrationScope 全局迁移在 schema_migrations 中的作用域名
+const GlobalMigrationScope = "_global"
+
+// Migration 单个版本的迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 某个作用域的迁移状态
+type MigrationStatus struct {
+	Scope   string
+	Version int
+	Name    string
+	Applied bool
+}
+
+// Migrator 执行数据库迁移
+type Migrator struct {
+	db       *sql.DB
+	dialect  string
+	interval []Migration
+	global   []Migration
+}
+
+// NewMigrator 为 SQL 存储创建迁移器
+func NewMigrator(store *SQLStore) (*Migrator, error) {
+	db := store.DB()
+	if db == nil {
+		return nil, ErrStoreUnavailable
+	}
+
+	intervalMigrations, err := loadMigrations(store.Dialect(), "interval")
+	if err != nil {
+		return nil, err
+	}
+	globalMigrations, err := loadMigrations(store.Dialect(), "global")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		db:       db,
+		dialect:  store.Dialect(),
+		interval: intervalMigrations,
+		global:   globalMigrations,
+	}
+	if err := m.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadMigrations 读取内嵌的迁移文件，按版本排序
+func loadMigrations(dialect, scope string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect, scope)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		// 该作用域没有迁移文件
+		return nil, nil
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("迁移文件名不合法: %s", name)
+		}
+
+		data, err := migrationFS.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("读取迁移文件失败: %v", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("迁移 %04d_%s 缺少 up 文件", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureVersionTable 创建迁移记录表
+func (m *Migrator) ensureVersionTable() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			scope VARCHAR(64) NOT NULL,
+			version INT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (scope, version)
+		)
+	`
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("创建 schema_migrations 失败: %v", err)
+	}
+	return nil
+}
+
+// appliedVersions 返回某个作用域已执行的版本
+func (m *Migrator) appliedVersions(scope string) (map[int]bool, error) {
+	rows, err := m.db.Query("SELECT version FROM schema_migrations WHERE scope = ?", scope)
+	if err != nil {
+		return nil, fmt.Errorf("查询迁移记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("读取迁移记录失败: %v", err)
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// scopes 返回需要迁移的作用域及对应的迁移列表
+func (m *Migrator) scopes(intervals []string) ([]string, map[string][]Migration, error) {
+	scopes := []string{GlobalMigrationScope}
+	byScope := map[string][]Migration{GlobalMigrationScope: m.global}
+	for _, interval := range intervals {
+		table, err := TrendTableName(interval)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := byScope[table]; ok {
+			continue
+		}
+		scopes = append(scopes, table)
+		byScope[table] = m.interval
+	}
+	return scopes, byScope, nil
+}
+
+// Up 对全局和各周期表执行所有未执行的迁移
+func (m *Migrator) Up(intervals []string) error {
+	scopes, byScope, err := m.scopes(intervals)
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range scopes {
+		applied, err := m.appliedVersions(scope)
+		if err != nil {
+			return err
+		}
+		for _, mig := range byScope[scope] {
+			if applied[mig.Version] {
+				continue
+			}
+			err := m.run(scope, mig.Up, "INSERT INTO schema_migrations (scope, version, name) VALUES (?, ?, ?)",
+				scope, mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("执行迁移 %s %04d_%s 失败: %v", scope, mig.Version, mig.Name, err)
+			}
+			log.Printf("[Migrate] %s: 已执行 %04d_%s", scope, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// Down 回滚 targets 中每个作用域最近的 steps 个迁移。targets 为周期名，
+// 全局作用域（评分表、事件表等）只有在 targets 中明确写出 _global 时才会回滚
+func (m *Migrator) Down(targets []string, steps int) error {
+	var intervals []string
+	global := false
+	for _, target := range targets {
+		if target == GlobalMigrationScope {
+			global = true
+			continue
+		}
+		intervals = append(intervals, target)
+	}
+	scopes, byScope, err := m.scopes(intervals)
+	if err != nil {
+		return err
+	}
+	if !global {
+		scopes = scopes[1:]
+	}
+
+	// 先回滚周期表，最后回滚全局
+	for i := len(scopes) - 1; i >= 0; i-- {
+		scope := scopes[i]
+		applied, err := m.appliedVersions(scope)
+		if err != nil {
+			return err
+		}
+
+		migrations := byScope[scope]
+		done := 0
+		for j := len(migrations) - 1; j >= 0 && done < steps; j-- {
+			mig := migrations[j]
+			if !applied[mig.Version] {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("迁移 %s %04d_%s 不支持回滚", scope, mig.Version, mig.Name)
+			}
+			err := m.run(scope, mig.Down, "DELETE FROM schema_migrations WHERE scope = ? AND version = ?",
+				scope, mig.Version)
+			if err != nil {
+				return fmt.Errorf("回滚迁移 %s %04d_%s 失败: %v", scope, mig.Version, mig.Name, err)
+			}
+			log.Printf("[Migrate] %s: 已回滚 %04d_%s", scope, mig.Version, mig.Name)
+			done++
+		}
+	}
+	return nil
+}
+
+// Status 返回各作用域每个迁移的执行状态
+func (m *Migrator) Status(intervals []string) ([]MigrationStatus, error) {
+	scopes, byScope, err := m.scopes(intervals)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, scope := range scopes {
+		applied, err := m.appliedVersions(scope)
+		if err != nil {
+			return nil, err
+		}
+		for _, mig := range byScope[scope] {
+			statuses = append(statuses, MigrationStatus{
+				Scope:   scope,
+				Version: mig.Version,
+				Name:    mig.Name,
+				Applied: applied[mig.Version],
+			})
+		}
+	}
+	return statuses, nil
+}
+
+// execer 可执行 SQL 的连接或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// run 执行一个迁移的 SQL，并执行 record 更新 schema_migrations。
+// SQLite 支持事务内的 DDL，迁移语句和版本记录在同一个事务中提交，中途失败时整体回滚，可直接重试；
+// MySQL 的 DDL 会隐式提交，无法放入事务，迁移中途失败时已执行的语句不会回滚，需按错误手动修复表结构后再执行 migrate up
+func (m *Migrator) run(scope, tmpl, record string, args ...interface{}) error {
+	if m.dialect != "sqlite" {
+		if err := m.exec(m.db, scope, tmpl); err != nil {
+			return err
+		}
+		if _, err := m.db.Exec(record, args...); err != nil {
+			return fmt.Errorf("更新迁移记录失败: %v", err)
+		}
+		return nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+	if err := m.exec(tx, scope, tmpl); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return fmt.Errorf("更新迁移记录失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败: %v", err)
+	}
+	return nil
+}
+
+// exec 渲染模板并逐条执行 SQL
+func (m *Migrator) exec(db execer, scope, tmpl string) error {
+	t, err := template.New(scope).Parse(tmpl)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, map[string]string{"Table": scope}); err != nil {
+		return err
+	}
+
+	for _, stmt := range strings.Split(buf.String(), ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestSQLite 在临时目录创建 SQLite 存储
+func openTestSQLite(t *testing.T) *SQLStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "trend.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// tableExists 判断 SQLite 中是否存在某张表
+func tableExists(t *testing.T, store *SQLStore, name string) bool {
+	t.Helper()
+	var n int
+	err := store.DB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+// TestMigrationRollsBackOnFailure SQLite 下迁移中途失败时，已执行的语句和版本记录一起回滚
+func TestMigrationRollsBackOnFailure(t *testing.T) {
+	store := openTestSQLite(t)
+	m, err := NewMigrator(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.global = nil
+	m.interval = []Migration{
+		{Version: 1, Name: "ok", Up: "CREATE TABLE {{.Table}} (id INTEGER)"},
+		{Version: 2, Name: "bad", Up: "CREATE TABLE {{.Table}}_extra (id INTEGER); INSERT INTO missing_table VALUES (1)"},
+	}
+
+	if err := m.Up([]string{"1h"}); err == nil {
+		t.Fatal("Up succeeded, want error from second migration")
+	}
+	if !tableExists(t, store, "symbol_1h") {
+		t.Fatal("first migration was not committed")
+	}
+	if tableExists(t, store, "symbol_1h_extra") {
+		t.Fatal("failed migration left its table behind")
+	}
+	applied, err := m.appliedVersions("symbol_1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied[1] || applied[2] {
+		t.Fatalf("applied versions = %v, want only 1", applied)
+	}
+
+	// 修复后重试，从失败的版本继续
+	m.interval[1].Up = "CREATE TABLE {{.Table}}_extra (id INTEGER)"
+	if err := m.Up([]string{"1h"}); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if !tableExists(t, store, "symbol_1h_extra") {
+		t.Fatal("retried migration not applied")
+	}
+}
+
+// TestQueriesDoNotMigrate 查询不执行 DDL，表未创建时返回空结果；写入时才迁移
+func TestQueriesDoNotMigrate(t *testing.T) {
+	store := openTestSQLite(t)
+
+	history, err := store.QueryHistory(HistoryQuery{Symbol: "BTCUSDT", Interval: "1h", Limit: 10})
+	if err != nil || len(history) != 0 {
+		t.Fatalf("QueryHistory = %v, %v", history, err)
+	}
+	if _, err := store.QueryConfluence("BTCUSDT", time.Time{}, time.Time{}, 10); err != nil {
+		t.Fatalf("QueryConfluence: %v", err)
+	}
+	if _, err := store.QueryTrendEvents(EventQuery{Symbol: "BTCUSDT"}); err != nil {
+		t.Fatalf("QueryTrendEvents: %v", err)
+	}
+	for _, table := range []string{"schema_migrations", "symbol_1h", "trend_confluence", "trend_events"} {
+		if tableExists(t, store, table) {
+			t.Fatalf("query created table %s", table)
+		}
+	}
+
+	result := &TrendResult{Symbol: "BTCUSDT", Interval: "1h", Status: RANGE, OpenTime: time.Unix(3600, 0), Time: time.Unix(3700, 0)}
+	if err := store.SaveTrendResult(context.Background(), result); err != nil {
+		t.Fatalf("SaveTrendResult: %v", err)
+	}
+	history, err = store.QueryHistory(HistoryQuery{Symbol: "BTCUSDT", Interval: "1h", Limit: 10})
+	if err != nil || len(history) != 1 {
+		t.Fatalf("QueryHistory after write = %v, %v", history, err)
+	}
+}
+
+// TestMigrateMarksScopes 启动时迁移过的周期，写入时不再执行迁移
+func TestMigrateMarksScopes(t *testing.T) {
+	store := openTestSQLite(t)
+	if err := store.Migrate([]string{"1h", "4h"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{GlobalMigrationScope, "1h", "4h"} {
+		if _, ok := store.migrated.Load(key); !ok {
+			t.Errorf("scope %s not marked as migrated", key)
+		}
+	}
+	for _, table := range []string{"symbol_1h", "symbol_4h", "trend_confluence", "trend_events"} {
+		if !tableExists(t, store, table) {
+			t.Errorf("table %s not created", table)
+		}
+	}
+}
+
+// TestDownSkipsGlobalUnlessNamed 回滚默认只作用于周期表，全局表需要明确指定 _global
+func TestDownSkipsGlobalUnlessNamed(t *testing.T) {
+	store := openTestSQLite(t)
+	m, err := NewMigrator(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up([]string{"1h", "4h"}); err != nil {
+		t.Fatal(err)
+	}
+	last := len(m.interval)
+
+	if err := m.Down([]string{"1h", "4h"}, last); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	for _, table := range []string{"symbol_1h", "symbol_4h"} {
+		if tableExists(t, store, table) {
+			t.Errorf("table %s not rolled back", table)
+		}
+	}
+	for _, table := range []string{"trend_confluence", "trend_events"} {
+		if !tableExists(t, store, table) {
+			t.Errorf("global table %s rolled back without -scope _global", table)
+		}
+	}
+
+	if err := m.Down([]string{GlobalMigrationScope}, len(m.global)); err != nil {
+		t.Fatalf("Down _global: %v", err)
+	}
+	for _, table := range []string{"trend_confluence", "trend_events"} {
+		if tableExists(t, store, table) {
+			t.Errorf("global table %s not rolled back", table)
+		}
+	}
+	applied, err := m.appliedVersions(GlobalMigrationScope)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("global versions after Down = %v, %v", applied, err)
+	}
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `trend_confluence` (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    symbol VARCHAR(32) NOT NULL,
+    timestamp BIGINT NOT NULL COMMENT '评分时间（秒）',
+    score DOUBLE NOT NULL,
+    label VARCHAR(16) NOT NULL,
+    vetoed TINYINT(1) NOT NULL DEFAULT 0,
+    veto_by VARCHAR(8) NOT NULL DEFAULT '',
+    coverage DOUBLE NOT NULL,
+    components TEXT NOT NULL COMMENT '各周期的状态和权重（JSON）',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_symbol_timestamp (symbol, timestamp)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `trend_events` (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    symbol VARCHAR(32) NOT NULL,
+    kline_interval VARCHAR(8) NOT NULL,
+    from_status VARCHAR(32) NOT NULL,
+    to_status VARCHAR(32) NOT NULL,
+    since BIGINT NOT NULL COMMENT '旧状态开始的K线开盘时间（毫秒）',
+    held_seconds BIGINT NOT NULL COMMENT '旧状态持续的时间（秒）',
+    price DOUBLE NOT NULL COMMENT '新状态所在K线的收盘价',
+    open_time BIGINT NOT NULL COMMENT '新状态所在K线的开盘时间（毫秒）',
+    detected_at BIGINT NOT NULL COMMENT '检测到变化的时间（秒）',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_symbol_interval_open_time (symbol, kline_interval, open_time),
+    KEY idx_open_time (open_time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
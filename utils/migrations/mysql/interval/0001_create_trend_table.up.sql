@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `{{.Table}}` (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    symbol VARCHAR(32) NOT NULL,
+    timestamp BIGINT NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_symbol_timestamp (symbol, timestamp)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+ALTER TABLE `{{.Table}}`
+    DROP COLUMN open_time,
+    DROP COLUMN close_time,
+    DROP COLUMN rule,
+    DROP COLUMN close_price,
+    DROP COLUMN ema25,
+    DROP COLUMN ema50,
+    DROP COLUMN ema120,
+    DROP COLUMN ma60,
+    DROP COLUMN dif,
+    DROP COLUMN dea,
+    DROP COLUMN histogram,
+    DROP COLUMN analyzed_at;
@@ -0,0 +1,13 @@
+ALTER TABLE `{{.Table}}`
+    ADD COLUMN open_time BIGINT NULL COMMENT 'K线开盘时间（毫秒）',
+    ADD COLUMN close_time BIGINT NULL COMMENT 'K线收盘时间（毫秒）',
+    ADD COLUMN rule VARCHAR(64) NULL,
+    ADD COLUMN close_price DOUBLE NULL,
+    ADD COLUMN ema25 DOUBLE NULL,
+    ADD COLUMN ema50 DOUBLE NULL,
+    ADD COLUMN ema120 DOUBLE NULL,
+    ADD COLUMN ma60 DOUBLE NULL,
+    ADD COLUMN dif DOUBLE NULL,
+    ADD COLUMN dea DOUBLE NULL,
+    ADD COLUMN histogram DOUBLE NULL,
+    ADD COLUMN analyzed_at BIGINT NULL COMMENT '分析时间（秒）';
@@ -0,0 +1,3 @@
+ALTER TABLE `{{.Table}}`
+    DROP COLUMN provisional_status,
+    DROP COLUMN candle_closed;
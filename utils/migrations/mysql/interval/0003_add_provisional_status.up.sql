@@ -0,0 +1,3 @@
+ALTER TABLE `{{.Table}}`
+    ADD COLUMN provisional_status VARCHAR(32) NULL COMMENT '包含未收盘K线的盘中状态',
+    ADD COLUMN candle_closed TINYINT(1) NULL COMMENT '状态所依据的K线是否已收盘';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS trend_confluence (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    symbol TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    score REAL NOT NULL,
+    label TEXT NOT NULL,
+    vetoed INTEGER NOT NULL DEFAULT 0,
+    veto_by TEXT NOT NULL DEFAULT '',
+    coverage REAL NOT NULL,
+    components TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (symbol, timestamp)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS trend_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    symbol TEXT NOT NULL,
+    kline_interval TEXT NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    since INTEGER NOT NULL,
+    held_seconds INTEGER NOT NULL,
+    price REAL NOT NULL,
+    open_time INTEGER NOT NULL,
+    detected_at INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (symbol, kline_interval, open_time)
+);
+CREATE INDEX IF NOT EXISTS idx_trend_events_open_time ON trend_events (open_time);
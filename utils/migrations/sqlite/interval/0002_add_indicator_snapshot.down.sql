@@ -0,0 +1,12 @@
+ALTER TABLE {{.Table}} DROP COLUMN open_time;
+ALTER TABLE {{.Table}} DROP COLUMN close_time;
+ALTER TABLE {{.Table}} DROP COLUMN rule;
+ALTER TABLE {{.Table}} DROP COLUMN close_price;
+ALTER TABLE {{.Table}} DROP COLUMN ema25;
+ALTER TABLE {{.Table}} DROP COLUMN ema50;
+ALTER TABLE {{.Table}} DROP COLUMN ema120;
+ALTER TABLE {{.Table}} DROP COLUMN ma60;
+ALTER TABLE {{.Table}} DROP COLUMN dif;
+ALTER TABLE {{.Table}} DROP COLUMN dea;
+ALTER TABLE {{.Table}} DROP COLUMN histogram;
+ALTER TABLE {{.Table}} DROP COLUMN analyzed_at;
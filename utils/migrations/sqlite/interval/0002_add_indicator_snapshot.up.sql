@@ -0,0 +1,12 @@
+ALTER TABLE {{.Table}} ADD COLUMN open_time INTEGER;
+ALTER TABLE {{.Table}} ADD COLUMN close_time INTEGER;
+ALTER TABLE {{.Table}} ADD COLUMN rule TEXT;
+ALTER TABLE {{.Table}} ADD COLUMN close_price REAL;
+ALTER TABLE {{.Table}} ADD COLUMN ema25 REAL;
+ALTER TABLE {{.Table}} ADD COLUMN ema50 REAL;
+ALTER TABLE {{.Table}} ADD COLUMN ema120 REAL;
+ALTER TABLE {{.Table}} ADD COLUMN ma60 REAL;
+ALTER TABLE {{.Table}} ADD COLUMN dif REAL;
+ALTER TABLE {{.Table}} ADD COLUMN dea REAL;
+ALTER TABLE {{.Table}} ADD COLUMN histogram REAL;
+ALTER TABLE {{.Table}} ADD COLUMN analyzed_at INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE {{.Table}} DROP COLUMN provisional_status;
+ALTER TABLE {{.Table}} DROP COLUMN candle_closed;
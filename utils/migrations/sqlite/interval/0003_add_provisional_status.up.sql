@@ -0,0 +1,2 @@
+ALTER TABLE {{.Table}} ADD COLUMN provisional_status TEXT;
+ALTER TABLE {{.Table}} ADD COLUMN candle_closed INTEGER;
@@ -44,9 +44,14 @@ func (o *OutputManager) LogTrendResults(results []*TrendResult) error {
 		fmt.Println("------------------------")
 
 		for _, result := range results {
-			fmt.Printf("%s %s: %s\n", result.Symbol, result.Interval, result.Status)
+			if result.Provisional != result.Status {
+				fmt.Printf("%s %s: %s（盘中: %s）\n", result.Symbol, result.Interval, result.Status, result.Provisional)
+			} else {
+				fmt.Printf("%s %s: %s\n", result.Symbol, result.Interval, result.Status)
+			}
 			fmt.Printf("  EMA25: %.2f\n", result.EMA25)
 			fmt.Printf("  EMA50: %.2f\n", result.EMA50)
+			fmt.Printf("  EMA120: %.2f\n", result.EMA120)
 			fmt.Println("------------------------")
 		}
 	}
@@ -78,6 +83,38 @@ func (o *OutputManager) LogTrendResults(results []*TrendResult) error {
 	return nil
 }
 
+// LogConfluence 记录多周期共振评分
+func (o *OutputManager) LogConfluence(results []*ConfluenceResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	if o.ConsoleLog {
+		fmt.Println("===== 多周期评分 =====")
+		for _, result := range results {
+			fmt.Printf("%s: %s\n", result.Symbol, result)
+		}
+		fmt.Println("------------------------")
+	}
+
+	if o.FileLog {
+		logFileName := fmt.Sprintf("trend_analysis_%s.log", time.Now().Format("20060102"))
+		logFilePath := filepath.Join(o.LogDir, logFileName)
+
+		f, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("打开日志文件失败: %v", err)
+		}
+		defer f.Close()
+
+		for _, result := range results {
+			f.WriteString(fmt.Sprintf("[%s] %s 多周期评分: %s\n", result.Time.Format("2006-01-02 15:04:05"), result.Symbol, result))
+		}
+	}
+
+	return nil
+}
+
 // LogError 记录错误信息
 func (o *OutputManager) LogError(err error) {
 	if o.ConsoleLog {
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BybitBaseURL = "https://api.bybit.com"
+	// bybitMaxLimit 单次请求最多返回的K线根数
+	bybitMaxLimit = 1000
+)
+
+// bybitIntervals 币安周期到 Bybit interval 的对应关系
+var bybitIntervals = map[string]string{
+	"1m": "1", "3m": "3", "5m": "5", "15m": "15", "30m": "30",
+	"1h": "60", "2h": "120", "4h": "240", "6h": "360", "12h": "720",
+	"1d": "D", "1w": "W", "1M": "M",
+}
+
+func init() {
+	RegisterKlineProvider("bybit", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		baseURL := source.BaseURL
+		if baseURL == "" {
+			baseURL = BybitBaseURL
+		}
+		client, err := newProxyHTTPClient(cfg.ProxyURL, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return &BybitProvider{BaseURL: baseURL, Category: "linear", HTTPClient: client, MaxRetries: cfg.RateLimit.MaxRetries}, nil
+	})
+}
+
+// BybitProvider Bybit v5 K线，默认为 USDT 永续（category=linear）
+type BybitProvider struct {
+	BaseURL    string
+	Category   string
+	HTTPClient *http.Client
+	MaxRetries int
+}
+
+// Name 提供方名称
+func (p *BybitProvider) Name() string {
+	return "bybit"
+}
+
+// GetKlines 获取最近的 limit 根K线
+func (p *BybitProvider) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	return p.fetch(ctx, symbol, interval, 0, limit)
+}
+
+// GetKlinesSince 获取开盘时间不早于 startTime 的K线
+func (p *BybitProvider) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	return p.fetch(ctx, symbol, interval, startTime, limit)
+}
+
+func (p *BybitProvider) fetch(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	bybitInterval, ok := bybitIntervals[interval]
+	if !ok {
+		return nil, errUnsupportedInterval(p.Name(), interval)
+	}
+	params := url.Values{}
+	params.Set("category", p.Category)
+	params.Set("symbol", symbol)
+	params.Set("interval", bybitInterval)
+	params.Set("limit", strconv.Itoa(min(limit, bybitMaxLimit)))
+	if startTime > 0 {
+		params.Set("start", strconv.FormatInt(startTime, 10))
+	}
+
+	body, err := httpGetWithRetry(ctx, p.HTTPClient, p.BaseURL+"/v5/market/kline?"+params.Encode(), p.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List [][]string `json:"list"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("解析K线数据失败: %v", err)
+	}
+	if payload.RetCode != 0 {
+		if strings.Contains(strings.ToLower(payload.RetMsg), "symbol") {
+			return nil, &InvalidSymbolError{Symbol: symbol}
+		}
+		return nil, &APIError{StatusCode: http.StatusOK, Code: payload.RetCode, Msg: payload.RetMsg}
+	}
+
+	// Bybit 按时间倒序返回：[startTime, open, high, low, close, volume, turnover]
+	list := payload.Result.List
+	klines := make([]KlineData, len(list))
+	for i, row := range list {
+		k, err := parseOHLCVRow(i, row, interval)
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 6 {
+			if k.QuoteAssetVolume, err = parseKlineFloat(row[6]); err != nil {
+				return nil, &KlineError{Index: i, OpenTime: k.OpenTime, Field: "turnover", Value: row[6], Reason: err.Error()}
+			}
+		}
+		klines[len(klines)-1-i] = k
+	}
+	return klines, nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterKlineProvider("csv", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		if source.Path == "" {
+			return nil, fmt.Errorf("csv 提供方需要配置 path")
+		}
+		return &CSVProvider{PathTemplate: source.Path}, nil
+	})
+}
+
+// CSVProvider 从本地 CSV 文件读取K线，用于离线分析和回放。
+// 每行为 open_time(ms), open, high, low, close, volume[, close_time]，首行为表头时自动跳过；
+// 文件路径中的 {symbol} 和 {interval} 会被替换
+type CSVProvider struct {
+	PathTemplate string
+}
+
+// Name 提供方名称
+func (p *CSVProvider) Name() string {
+	return "csv"
+}
+
+// GetKlines 返回文件中最后 limit 根K线
+func (p *CSVProvider) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	klines, err := p.read(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	if len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+// GetKlinesSince 返回开盘时间不早于 startTime 的前 limit 根K线
+func (p *CSVProvider) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	klines, err := p.read(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= startTime })
+	klines = klines[i:]
+	if len(klines) > limit {
+		klines = klines[:limit]
+	}
+	return klines, nil
+}
+
+// path 替换路径模板中的占位符
+func (p *CSVProvider) path(symbol, interval string) string {
+	return strings.NewReplacer("{symbol}", symbol, "{interval}", interval).Replace(p.PathTemplate)
+}
+
+// read 读取整个文件并按开盘时间排序
+func (p *CSVProvider) read(symbol, interval string) ([]KlineData, error) {
+	path := p.path(symbol, interval)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开K线文件失败: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var klines []KlineData
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取K线文件 %s 失败: %v", path, err)
+		}
+		// 表头
+		if line == 1 && len(row) > 0 {
+			if _, err := strconv.ParseInt(row[0], 10, 64); err != nil {
+				continue
+			}
+		}
+
+		k, err := parseOHLCVRow(line-1, row, interval)
+		if err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行: %w", path, line, err)
+		}
+		if len(row) > 6 && row[6] != "" {
+			closeTime, err := strconv.ParseInt(row[6], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s 第 %d 行: 收盘时间无效: %q", path, line, row[6])
+			}
+			k.CloseTime = closeTime
+		}
+		klines = append(klines, k)
+	}
+
+	sort.Slice(klines, func(i, j int) bool { return klines[i].OpenTime < klines[j].OpenTime })
+	return klines, nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	OKXBaseURL = "https://www.okx.com"
+	// okxMaxLimit 单次请求最多返回的K线根数
+	okxMaxLimit = 300
+	// okxCodeInvalidInstrument 交易对不存在
+	okxCodeInvalidInstrument = "51001"
+)
+
+// okxBars 币安周期到 OKX bar 的对应关系，6h 及以上使用 UTC 对齐的周期
+var okxBars = map[string]string{
+	"1m": "1m", "3m": "3m", "5m": "5m", "15m": "15m", "30m": "30m",
+	"1h": "1H", "2h": "2H", "4h": "4H", "6h": "6Hutc", "12h": "12Hutc",
+	"1d": "1Dutc", "3d": "3Dutc", "1w": "1Wutc", "1M": "1Mutc",
+}
+
+func init() {
+	RegisterKlineProvider("okx", func(cfg *config.Config, source config.KlineSource) (KlineProvider, error) {
+		baseURL := source.BaseURL
+		if baseURL == "" {
+			baseURL = OKXBaseURL
+		}
+		client, err := newProxyHTTPClient(cfg.ProxyURL, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return &OKXProvider{BaseURL: baseURL, HTTPClient: client, MaxRetries: cfg.RateLimit.MaxRetries}, nil
+	})
+}
+
+// OKXProvider OKX K线，symbol 为 instId，如 BTC-USDT-SWAP、BTC-USDT
+type OKXProvider struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	MaxRetries int
+}
+
+// Name 提供方名称
+func (p *OKXProvider) Name() string {
+	return "okx"
+}
+
+// GetKlines 获取最近的 limit 根K线，超过单次上限时按 after 向前翻页
+func (p *OKXProvider) GetKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	var klines []KlineData
+	var after int64
+	for len(klines) < limit {
+		params := url.Values{}
+		if after > 0 {
+			params.Set("after", strconv.FormatInt(after, 10))
+		}
+		page, err := p.fetch(ctx, symbol, interval, min(limit-len(klines), okxMaxLimit), params)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		klines = append(page, klines...)
+		after = page[0].OpenTime
+	}
+	return klines, nil
+}
+
+// GetKlinesSince 获取开盘时间不早于 startTime 的K线
+func (p *OKXProvider) GetKlinesSince(ctx context.Context, symbol, interval string, startTime int64, limit int) ([]KlineData, error) {
+	params := url.Values{}
+	params.Set("before", strconv.FormatInt(startTime-1, 10))
+	return p.fetch(ctx, symbol, interval, min(limit, okxMaxLimit), params)
+}
+
+// fetch 请求一页K线并转换为升序
+func (p *OKXProvider) fetch(ctx context.Context, symbol, interval string, limit int, params url.Values) ([]KlineData, error) {
+	bar, ok := okxBars[interval]
+	if !ok {
+		return nil, errUnsupportedInterval(p.Name(), interval)
+	}
+	params.Set("instId", symbol)
+	params.Set("bar", bar)
+	params.Set("limit", strconv.Itoa(limit))
+
+	body, err := httpGetWithRetry(ctx, p.HTTPClient, p.BaseURL+"/api/v5/market/candles?"+params.Encode(), p.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		Code string     `json:"code"`
+		Msg  string     `json:"msg"`
+		Data [][]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("解析K线数据失败: %v", err)
+	}
+	if payload.Code == okxCodeInvalidInstrument {
+		return nil, &InvalidSymbolError{Symbol: symbol}
+	}
+	if payload.Code != "0" {
+		code, _ := strconv.Atoi(payload.Code)
+		return nil, &APIError{StatusCode: http.StatusOK, Code: code, Msg: payload.Msg}
+	}
+
+	// OKX 按时间倒序返回：[ts, o, h, l, c, vol, volCcy, volCcyQuote, confirm]
+	klines := make([]KlineData, len(payload.Data))
+	for i, row := range payload.Data {
+		k, err := parseOHLCVRow(i, row, interval)
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 7 {
+			if k.QuoteAssetVolume, err = parseKlineFloat(row[7]); err != nil {
+				return nil, &KlineError{Index: i, OpenTime: k.OpenTime, Field: "volCcyQuote", Value: row[7], Reason: err.Error()}
+			}
+		}
+		klines[len(klines)-1-i] = k
+	}
+	return klines, nil
+}
+
+// parseOHLCVRow 解析 [开盘时间(ms), 开, 高, 低, 收, 成交量, ...] 格式的一行，index 用于错误定位
+func parseOHLCVRow(index int, row []string, interval string) (KlineData, error) {
+	if len(row) < 6 {
+		return KlineData{}, &KlineError{Index: index, Value: fmt.Sprint(row), Reason: fmt.Sprintf("字段数量不足: %d/6", len(row))}
+	}
+	openTime, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return KlineData{}, &KlineError{Index: index, Field: "open_time", Value: row[0], Reason: "应为整数"}
+	}
+	closeTime, err := candleCloseTime(openTime, interval)
+	if err != nil {
+		return KlineData{}, err
+	}
+
+	fields := []string{"open", "high", "low", "close", "volume"}
+	var values [5]float64
+	for i, field := range fields {
+		v, err := parseKlineFloat(row[i+1])
+		if err != nil {
+			return KlineData{}, &KlineError{Index: index, OpenTime: openTime, Field: field, Value: row[i+1], Reason: err.Error()}
+		}
+		values[i] = v
+	}
+	return KlineData{
+		OpenTime:  openTime,
+		Open:      values[0],
+		High:      values[1],
+		Low:       values[2],
+		Close:     values[3],
+		Volume:    values[4],
+		CloseTime: closeTime,
+	}, nil
+}
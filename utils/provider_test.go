@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// providerServer 按请求参数返回夹具的测试服务器，记录每次请求
+type providerServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*url.URL
+}
+
+func newProviderServer(t *testing.T, respond func(r *http.Request) interface{}) *providerServer {
+	s := &providerServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL)
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(respond(r))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTestProvider 通过注册的工厂创建指向测试服务器的提供方
+func newTestProvider(t *testing.T, provider, baseURL string) KlineProvider {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.RateLimit.MaxRetries = 0
+	p, err := NewKlineProvider(cfg, config.KlineSource{Provider: provider, BaseURL: baseURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+var providerStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// barOpen 第 i 根 1h K线的开盘时间（毫秒）
+func barOpen(i int) int64 {
+	return providerStart.Add(time.Duration(i) * time.Hour).UnixMilli()
+}
+
+// ohlcvRow 第 i 根K线的 [开盘时间, 开, 高, 低, 收, 成交量, extra...]，数值由 i 决定，便于检查字段对应关系
+func ohlcvRow(i int, extra ...string) []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	row := []string{strconv.FormatInt(barOpen(i), 10), f(float64(i) + 1), f(float64(i) + 2), f(float64(i) + 0.5), f(float64(i) + 1.5), f(float64(i) + 10)}
+	return append(row, extra...)
+}
+
+// checkOHLCV 检查K线按开盘时间升序、首尾相接，且字段与 ohlcvRow(first+j) 对应
+func checkOHLCV(t *testing.T, klines []KlineData, first, n int) {
+	t.Helper()
+	if len(klines) != n {
+		t.Fatalf("got %d klines, want %d", len(klines), n)
+	}
+	for j, k := range klines {
+		i := float64(first + j)
+		if k.OpenTime != barOpen(first+j) || k.CloseTime != barOpen(first+j+1)-1 {
+			t.Fatalf("kline %d: open=%d close=%d, want open=%d close=%d", j, k.OpenTime, k.CloseTime, barOpen(first+j), barOpen(first+j+1)-1)
+		}
+		if k.Open != i+1 || k.High != i+2 || k.Low != i+0.5 || k.Close != i+1.5 || k.Volume != i+10 {
+			t.Fatalf("kline %d: %+v, fields not mapped from row %d", j, k, first+j)
+		}
+	}
+}
+
+// descRows 返回第 from 到 to-1 根K线按时间倒序排列的行
+func descRows(from, to int, row func(i int) []string) [][]string {
+	var rows [][]string
+	for i := to - 1; i >= from; i-- {
+		rows = append(rows, row(i))
+	}
+	return rows
+}
+
+func TestOKXProvider(t *testing.T) {
+	const total = 400
+	okxRow := func(i int) []string { return ohlcvRow(i, "0", strconv.Itoa(100+i), "1") }
+	srv := newProviderServer(t, func(r *http.Request) interface{} {
+		q := r.URL.Query()
+		if q.Get("instId") == "NOPE-USDT-SWAP" {
+			return map[string]interface{}{"code": "51001", "msg": "Instrument ID does not exist", "data": [][]string{}}
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		end, from := total, 0
+		if after := q.Get("after"); after != "" {
+			// 早于 after 的K线
+			ts, _ := strconv.ParseInt(after, 10, 64)
+			end = int((ts - barOpen(0)) / time.Hour.Milliseconds())
+		}
+		if before := q.Get("before"); before != "" {
+			// 晚于 before 的K线，取最早的 limit 根
+			ts, _ := strconv.ParseInt(before, 10, 64)
+			from = int((ts-barOpen(0))/time.Hour.Milliseconds()) + 1
+			end = min(from+limit, total)
+		}
+		from = max(from, end-limit, 0)
+		return map[string]interface{}{"code": "0", "msg": "", "data": descRows(from, end, okxRow)}
+	})
+	p := newTestProvider(t, "okx", srv.URL)
+	ctx := context.Background()
+
+	// 超过单次上限时向前翻页，结果为升序
+	klines, err := p.GetKlines(ctx, "BTC-USDT-SWAP", "1h", total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 0, total)
+	if klines[7].QuoteAssetVolume != 107 {
+		t.Fatalf("quote volume = %v, want volCcyQuote 107", klines[7].QuoteAssetVolume)
+	}
+	if len(srv.requests) != 2 {
+		t.Fatalf("requests = %v, want 2 pages", srv.requests)
+	}
+	first, second := srv.requests[0].Query(), srv.requests[1].Query()
+	if first.Get("bar") != "1H" || first.Get("instId") != "BTC-USDT-SWAP" || first.Get("limit") != "300" || first.Has("after") {
+		t.Fatalf("first page query = %v", first)
+	}
+	if second.Get("after") != strconv.FormatInt(barOpen(100), 10) || second.Get("limit") != "100" {
+		t.Fatalf("second page query = %v", second)
+	}
+
+	// 增量获取包含 startTime 这根K线
+	klines, err = p.GetKlinesSince(ctx, "BTC-USDT-SWAP", "1h", barOpen(390), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 390, 3)
+	if q := srv.requests[2].Query(); q.Get("before") != strconv.FormatInt(barOpen(390)-1, 10) {
+		t.Fatalf("since query = %v", q)
+	}
+
+	// 日线使用 UTC 对齐的 bar
+	srv.requests = nil
+	if _, err := p.GetKlines(ctx, "BTC-USDT-SWAP", "1d", 1); err != nil {
+		t.Fatal(err)
+	}
+	if bar := srv.requests[0].Query().Get("bar"); bar != "1Dutc" {
+		t.Fatalf("1d bar = %s", bar)
+	}
+	if _, err := p.GetKlines(ctx, "BTC-USDT-SWAP", "8h", 1); err == nil {
+		t.Fatal("8h should be unsupported")
+	}
+
+	var invalid *InvalidSymbolError
+	if _, err := p.GetKlines(ctx, "NOPE-USDT-SWAP", "1h", 10); !errors.As(err, &invalid) {
+		t.Fatalf("invalid instrument error = %v", err)
+	}
+}
+
+func TestBybitProvider(t *testing.T) {
+	const total = 50
+	bybitRow := func(i int) []string { return ohlcvRow(i, strconv.Itoa(200+i)) }
+	srv := newProviderServer(t, func(r *http.Request) interface{} {
+		q := r.URL.Query()
+		if q.Get("symbol") == "NOPEUSDT" {
+			return map[string]interface{}{"retCode": 10001, "retMsg": "Not supported symbols", "result": map[string]interface{}{}}
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		from, end := max(total-limit, 0), total
+		if start := q.Get("start"); start != "" {
+			ts, _ := strconv.ParseInt(start, 10, 64)
+			from = int((ts - barOpen(0)) / time.Hour.Milliseconds())
+			end = min(from+limit, total)
+		}
+		return map[string]interface{}{"retCode": 0, "retMsg": "OK", "result": map[string]interface{}{"list": descRows(from, end, bybitRow)}}
+	})
+	p := newTestProvider(t, "bybit", srv.URL)
+	ctx := context.Background()
+
+	klines, err := p.GetKlines(ctx, "BTCUSDT", "1h", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 30, 20)
+	if klines[0].QuoteAssetVolume != 230 {
+		t.Fatalf("quote volume = %v, want turnover 230", klines[0].QuoteAssetVolume)
+	}
+	q := srv.requests[0].Query()
+	if q.Get("category") != "linear" || q.Get("interval") != "60" || q.Get("symbol") != "BTCUSDT" || q.Get("limit") != "20" || q.Has("start") {
+		t.Fatalf("query = %v", q)
+	}
+
+	klines, err = p.GetKlinesSince(ctx, "BTCUSDT", "1h", barOpen(45), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 45, 3)
+	if q := srv.requests[1].Query(); q.Get("start") != strconv.FormatInt(barOpen(45), 10) {
+		t.Fatalf("since query = %v", q)
+	}
+
+	// 超过单次上限时按上限请求
+	if _, err := p.GetKlines(ctx, "BTCUSDT", "1h", 1500); err != nil {
+		t.Fatal(err)
+	}
+	if limit := srv.requests[2].Query().Get("limit"); limit != "1000" {
+		t.Fatalf("limit = %s, want 1000", limit)
+	}
+
+	var invalid *InvalidSymbolError
+	if _, err := p.GetKlines(ctx, "NOPEUSDT", "1h", 10); !errors.As(err, &invalid) {
+		t.Fatalf("invalid symbol error = %v", err)
+	}
+}
+
+// binanceRow 第 i 根K线的币安格式：[开盘时间, 开, 高, 低, 收, 成交量, 收盘时间, 成交额, 笔数, 主动买入量, 主动买入额, 忽略]
+func binanceRow(i int) []interface{} {
+	r := ohlcvRow(i)
+	return []interface{}{barOpen(i), r[1], r[2], r[3], r[4], r[5], barOpen(i+1) - 1,
+		strconv.Itoa(300 + i), 40 + i, strconv.Itoa(50 + i), strconv.Itoa(60 + i), "0"}
+}
+
+func TestBinanceMarketProviders(t *testing.T) {
+	tests := []struct {
+		provider string
+		path     string
+		limit    int
+		weight   int
+	}{
+		{"binance_spot", "/api/v3/klines", 499, 2},
+		{"binance_spot", "/api/v3/klines", 50, 2},
+		{"binance_coinm", "/dapi/v1/klines", 499, 2},
+		{"binance_coinm", "/dapi/v1/klines", 50, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.provider, tt.limit), func(t *testing.T) {
+			srv := newProviderServer(t, func(r *http.Request) interface{} {
+				q := r.URL.Query()
+				limit, _ := strconv.Atoi(q.Get("limit"))
+				from := 1000 - limit
+				if start := q.Get("startTime"); start != "" {
+					ts, _ := strconv.ParseInt(start, 10, 64)
+					from = int((ts - barOpen(0)) / time.Hour.Milliseconds())
+				}
+				rows := make([][]interface{}, 0, limit)
+				for i := from; i < from+limit && i < 1000; i++ {
+					rows = append(rows, binanceRow(i))
+				}
+				return rows
+			})
+			p := newTestProvider(t, tt.provider, srv.URL)
+			client := p.(*BinanceClient)
+			// 使用单独的限速器，不受其他测试影响
+			client.Limiter = NewWeightLimiter(1200)
+			if p.Name() != tt.provider {
+				t.Fatalf("name = %s", p.Name())
+			}
+
+			klines, err := p.GetKlines(context.Background(), "BTCUSD_PERP", "1h", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOHLCV(t, klines, 1000-tt.limit, tt.limit)
+			k := klines[0]
+			i := 1000 - tt.limit
+			if k.QuoteAssetVolume != float64(300+i) || k.NumberOfTrades != int64(40+i) ||
+				k.TakerBuyBaseAssetVolume != float64(50+i) || k.TakerBuyQuoteAssetVolume != float64(60+i) {
+				t.Fatalf("kline %+v, extra fields not mapped", k)
+			}
+
+			req := srv.requests[0]
+			if req.Path != tt.path || req.Query().Get("symbol") != "BTCUSD_PERP" || req.Query().Get("interval") != "1h" {
+				t.Fatalf("request = %v", req)
+			}
+			if client.Limiter.used != tt.weight {
+				t.Fatalf("weight = %d, want %d", client.Limiter.used, tt.weight)
+			}
+
+			klines, err = p.GetKlinesSince(context.Background(), "BTCUSD_PERP", "1h", barOpen(997), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOHLCV(t, klines, 997, 2)
+			if q := srv.requests[1].Query(); q.Get("startTime") != strconv.FormatInt(barOpen(997), 10) || q.Get("limit") != "2" {
+				t.Fatalf("since query = %v", q)
+			}
+		})
+	}
+}
+
+// TestBinanceClientReusesProxyClient 客户端只在创建时按 proxy_url 建立一次，多次请求经同一个代理复用连接
+func TestBinanceClientReusesProxyClient(t *testing.T) {
+	var mu sync.Mutex
+	var hosts, remotes []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		remotes = append(remotes, r.RemoteAddr)
+		mu.Unlock()
+		json.NewEncoder(w).Encode([][]interface{}{binanceRow(0)})
+	}))
+	t.Cleanup(proxy.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = proxy.URL
+	cfg.RateLimit.MaxRetries = 0
+	p, err := NewKlineProvider(cfg, config.KlineSource{Provider: "binance_futures", BaseURL: "http://fapi.binance.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := p.(*BinanceClient)
+	client.Limiter = NewWeightLimiter(1200)
+	httpClient := client.HTTPClient
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.GetKlines(context.Background(), "BTCUSDT", "1h", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.HTTPClient != httpClient {
+		t.Fatal("HTTP client replaced between requests")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) != 3 || hosts[0] != "fapi.binance.test" {
+		t.Fatalf("proxy saw hosts %v, want 3 requests for fapi.binance.test", hosts)
+	}
+	if remotes[1] != remotes[0] || remotes[2] != remotes[0] {
+		t.Fatalf("requests used connections %v, want one reused connection", remotes)
+	}
+}
+
+func TestCSVProvider(t *testing.T) {
+	dir := t.TempDir()
+	// 表头、乱序、部分行带收盘时间
+	rows := []string{"open_time,open,high,low,close,volume,close_time"}
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		row := ohlcvRow(i)
+		if i == 4 {
+			row = append(row, strconv.FormatInt(barOpen(5)-1, 10))
+		}
+		rows = append(rows, strings.Join(row, ","))
+	}
+	content := strings.Join(rows, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "BTCUSDT_1h.csv"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	if _, err := NewKlineProvider(cfg, config.KlineSource{Provider: "csv"}); err == nil {
+		t.Fatal("csv provider without path should fail")
+	}
+	p, err := NewKlineProvider(cfg, config.KlineSource{Provider: "csv", Path: filepath.Join(dir, "{symbol}_{interval}.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	klines, err := p.GetKlines(ctx, "BTCUSDT", "1h", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 0, 5)
+
+	klines, err = p.GetKlines(ctx, "BTCUSDT", "1h", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 3, 2)
+
+	klines, err = p.GetKlinesSince(ctx, "BTCUSDT", "1h", barOpen(1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOHLCV(t, klines, 1, 2)
+
+	if _, err := p.GetKlines(ctx, "ETHUSDT", "1h", 10); err == nil {
+		t.Fatal("missing file should fail")
+	}
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WeightLimiter 按分钟统计请求权重。除了本进程记录的权重，还以响应头
+// X-MBX-USED-WEIGHT-1M 为准，这样共用同一出口 IP 的多个实例也不会超出预算。
+// 收到 429/418 时按 Retry-After 暂停所有请求。
+type WeightLimiter struct {
+	mu          sync.Mutex
+	budget      int
+	used        int
+	window      time.Time // 当前统计的分钟
+	pausedUntil time.Time
+}
+
+// NewWeightLimiter 创建限速器，budget 为每分钟最多使用的权重
+func NewWeightLimiter(budget int) *WeightLimiter {
+	return &WeightLimiter{budget: budget}
+}
+
+var (
+	sharedLimiterMu sync.Mutex
+	sharedLimiters  = make(map[string]*WeightLimiter)
+)
+
+// SharedWeightLimiter 返回进程内同一市场的客户端共用的限速器，key 为市场名称
+func SharedWeightLimiter(key string, budget int) *WeightLimiter {
+	sharedLimiterMu.Lock()
+	defer sharedLimiterMu.Unlock()
+
+	l, ok := sharedLimiters[key]
+	if !ok {
+		l = NewWeightLimiter(budget)
+		sharedLimiters[key] = l
+	}
+	l.SetBudget(budget)
+	return l
+}
+
+// SetBudget 调整每分钟的权重预算
+func (l *WeightLimiter) SetBudget(budget int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.budget = budget
+}
+
+// Wait 阻塞直到可以发出权重为 weight 的请求，并预先记入本分钟的用量；ctx 取消时返回其错误
+func (l *WeightLimiter) Wait(ctx context.Context, weight int) error {
+	for {
+		delay := l.reserve(weight, time.Now())
+		if delay <= 0 {
+			return nil
+		}
+		log.Printf("[RateLimit] 权重预算不足或处于暂停期，等待 %v", delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve 尝试记入权重，返回需要等待的时间，0 表示已记入
+func (l *WeightLimiter) reserve(weight int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	window := now.Truncate(time.Minute)
+	if !window.Equal(l.window) {
+		l.window = window
+		l.used = 0
+	}
+	if l.used > 0 && l.used+weight > l.budget {
+		return window.Add(time.Minute).Sub(now)
+	}
+	l.used += weight
+	return 0
+}
+
+// Observe 根据响应头更新本分钟已使用的权重
+func (l *WeightLimiter) Observe(header http.Header) {
+	used, err := strconv.Atoi(header.Get("X-MBX-USED-WEIGHT-1M"))
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if window := time.Now().Truncate(time.Minute); !window.Equal(l.window) {
+		l.window = window
+		l.used = 0
+	}
+	if used > l.used {
+		l.used = used
+	}
+}
+
+// Pause 在 d 时间内暂停所有请求
+func (l *WeightLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// parseRetryAfter 解析 Retry-After 响应头（秒），缺失时使用 fallback
+func parseRetryAfter(header http.Header, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+// klineWeight 合约K线接口按 limit 计算的请求权重
+func klineWeight(limit int) int {
+	switch {
+	case limit < 100:
+		return 1
+	case limit < 500:
+		return 2
+	case limit <= 1000:
+		return 5
+	default:
+		return 10
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// weekOffset 币安周线从周一 00:00 UTC 开始，Unix 纪元（1970-01-01）是周四
+const weekOffset = 4 * 24 * time.Hour
+
+// NextCandleClose 返回 now 之后该周期的下一个收盘时间（即下一根K线的开盘时间）。
+// 固定时长的周期（包括 3d）与币安一致按 Unix 纪元对齐，1w 对齐到周一，1M 对齐到自然月
+func NextCandleClose(interval string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	switch interval {
+	case "1M":
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC), nil
+	case "1w":
+		return nextBoundary(now, 7*24*time.Hour, weekOffset), nil
+	}
+
+	d, err := intervalDuration(interval)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return nextBoundary(now, d, 0), nil
+}
+
+// nextBoundary 返回 now 之后第一个满足 (t - offset) 是 d 整数倍的时间（以 Unix 纪元为起点）。
+// 不能用 time.Truncate，它以公元 1 年为起点，3d 等周期会与币安错位
+func nextBoundary(now time.Time, d, offset time.Duration) time.Time {
+	ms, step := now.UnixMilli()-offset.Milliseconds(), d.Milliseconds()
+	return time.UnixMilli((ms/step+1)*step + offset.Milliseconds()).UTC()
+}
+
+// CandleScheduler 按K线收盘时间调度各周期的分析，每个周期只在自己的K线收盘后执行一次
+type CandleScheduler struct {
+	// Settle 收盘后等待交易所生成K线的时间
+	Settle time.Duration
+
+	mu        sync.Mutex
+	intervals []string
+	next      map[string]time.Time // 各周期下一次收盘时间
+	pending   map[string]bool      // 上次执行失败、等待重试的周期
+}
+
+// NewCandleScheduler 创建调度器
+func NewCandleScheduler(settle time.Duration) *CandleScheduler {
+	return &CandleScheduler{
+		Settle:  settle,
+		next:    make(map[string]time.Time),
+		pending: make(map[string]bool),
+	}
+}
+
+// SetIntervals 设置要调度的周期，已在调度中的周期保留原来的收盘时间
+func (s *CandleScheduler) SetIntervals(intervals []string, now time.Time) error {
+	next := make(map[string]time.Time, len(intervals))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, interval := range intervals {
+		if t, ok := s.next[interval]; ok {
+			next[interval] = t
+			continue
+		}
+		t, err := NextCandleClose(interval, now)
+		if err != nil {
+			return err
+		}
+		next[interval] = t
+	}
+	for interval := range s.pending {
+		if _, ok := next[interval]; !ok {
+			delete(s.pending, interval)
+		}
+	}
+	s.intervals = append([]string(nil), intervals...)
+	s.next = next
+	return nil
+}
+
+// NextRun 返回最早需要执行的时间（已加上 Settle）；没有周期时返回零值
+func (s *CandleScheduler) NextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var earliest time.Time
+	for _, t := range s.next {
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	if earliest.IsZero() {
+		return earliest
+	}
+	return earliest.Add(s.Settle)
+}
+
+// Due 返回到 now 为止已收盘的周期以及等待重试的周期（按配置顺序），并推进它们的下一次收盘时间。
+// 错过了多个收盘时间（如系统休眠）的周期只执行一次
+func (s *CandleScheduler) Due(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for _, interval := range s.intervals {
+		closed := !now.Before(s.next[interval].Add(s.Settle))
+		if !closed && !s.pending[interval] {
+			continue
+		}
+		if closed {
+			// 周期在 SetIntervals 中已校验过
+			s.next[interval], _ = NextCandleClose(interval, now.Add(-s.Settle))
+		}
+		delete(s.pending, interval)
+		due = append(due, interval)
+	}
+	return due
+}
+
+// MarkFailed 标记周期本轮执行失败，在下一次唤醒（收盘或心跳）时重试
+func (s *CandleScheduler) MarkFailed(interval string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.next[interval]; ok {
+		s.pending[interval] = true
+	}
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestNextCandleClose(t *testing.T) {
+	shanghai := time.FixedZone("UTC+8", 8*3600)
+	for _, tt := range []struct {
+		interval string
+		now      time.Time
+		want     time.Time
+	}{
+		{"1m", utc(2024, 1, 1, 12, 0).Add(30 * time.Second), utc(2024, 1, 1, 12, 1)},
+		{"15m", utc(2024, 1, 1, 12, 14), utc(2024, 1, 1, 12, 15)},
+		// 恰好在收盘时刻时返回下一根
+		{"15m", utc(2024, 1, 1, 12, 15), utc(2024, 1, 1, 12, 30)},
+		{"1h", utc(2024, 1, 1, 12, 59), utc(2024, 1, 1, 13, 0)},
+		{"4h", utc(2024, 1, 1, 13, 0), utc(2024, 1, 1, 16, 0)},
+		{"12h", utc(2024, 1, 1, 12, 0), utc(2024, 1, 2, 0, 0)},
+		{"1d", utc(2024, 2, 28, 23, 59), utc(2024, 2, 29, 0, 0)},
+		// 3d 按 Unix 纪元对齐：2023-12-31 和 2024-01-03 开盘（纪元后第 19722、19725 天）
+		{"3d", utc(2024, 1, 1, 12, 0), utc(2024, 1, 3, 0, 0)},
+		{"3d", utc(2024, 1, 3, 0, 0), utc(2024, 1, 6, 0, 0)},
+		{"3d", utc(1970, 1, 1, 0, 0), utc(1970, 1, 4, 0, 0)},
+		// 周线从周一 00:00 UTC 开始
+		{"1w", utc(2024, 1, 3, 12, 0), utc(2024, 1, 8, 0, 0)},
+		{"1w", utc(2024, 1, 7, 23, 59), utc(2024, 1, 8, 0, 0)},
+		{"1w", utc(2024, 1, 8, 0, 0), utc(2024, 1, 15, 0, 0)},
+		// 月线按自然月，跨年
+		{"1M", utc(2024, 1, 31, 23, 59), utc(2024, 2, 1, 0, 0)},
+		{"1M", utc(2024, 2, 1, 0, 0), utc(2024, 3, 1, 0, 0)},
+		{"1M", utc(2024, 12, 15, 0, 0), utc(2025, 1, 1, 0, 0)},
+		// 本地时间先换算为 UTC：北京时间 2 月 1 日 07:00 仍属于 UTC 的 1 月
+		{"1M", time.Date(2024, 2, 1, 7, 0, 0, 0, shanghai), utc(2024, 2, 1, 0, 0)},
+		{"1d", time.Date(2024, 1, 2, 7, 59, 0, 0, shanghai), utc(2024, 1, 2, 0, 0)},
+	} {
+		got, err := NextCandleClose(tt.interval, tt.now)
+		if err != nil {
+			t.Errorf("%s at %v: %v", tt.interval, tt.now, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s at %v = %v, want %v", tt.interval, tt.now, got, tt.want)
+		}
+	}
+
+	if _, err := NextCandleClose("2w", utc(2024, 1, 1, 0, 0)); err == nil {
+		t.Error("unknown interval accepted")
+	}
+}
+
+func TestNextBoundary(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		now    time.Time
+		d      time.Duration
+		offset time.Duration
+		want   time.Time
+	}{
+		// 按 Unix 纪元对齐；time.Truncate 以公元 1 年为起点，会得到 2024-01-04
+		{utc(2024, 1, 1, 12, 0), 3 * day, 0, utc(2024, 1, 3, 0, 0)},
+		{utc(2024, 1, 6, 0, 0).Add(-time.Millisecond), 3 * day, 0, utc(2024, 1, 6, 0, 0)},
+		{utc(2024, 1, 3, 12, 0), 7 * day, weekOffset, utc(2024, 1, 8, 0, 0)},
+		{utc(2024, 1, 8, 0, 0).Add(-time.Millisecond), 7 * day, weekOffset, utc(2024, 1, 8, 0, 0)},
+		{utc(2024, 1, 1, 0, 0).Add(time.Millisecond), time.Hour, 0, utc(2024, 1, 1, 1, 0)},
+	} {
+		if got := nextBoundary(tt.now, tt.d, tt.offset); !got.Equal(tt.want) {
+			t.Errorf("nextBoundary(%v, %v, %v) = %v, want %v", tt.now, tt.d, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestCandleSchedulerDue(t *testing.T) {
+	settle := 3 * time.Second
+	s := NewCandleScheduler(settle)
+	start := utc(2024, 1, 1, 12, 2)
+	if err := s.SetIntervals([]string{"5m", "1h"}, start); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.NextRun(), utc(2024, 1, 1, 12, 5).Add(settle); !got.Equal(want) {
+		t.Fatalf("NextRun = %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		now  time.Time
+		want []string
+	}{
+		// 收盘后等待 settle 才执行，同一根K线只执行一次
+		{utc(2024, 1, 1, 12, 5).Add(2 * time.Second), nil},
+		{utc(2024, 1, 1, 12, 5).Add(settle), []string{"5m"}},
+		{utc(2024, 1, 1, 12, 6), nil},
+		// 1h 和 5m 同时收盘，按配置顺序返回
+		{utc(2024, 1, 1, 13, 0).Add(settle), []string{"5m", "1h"}},
+		// 休眠期间错过了多根K线：每个周期只执行一次，下一次收盘按当前时间重新计算
+		{utc(2024, 1, 1, 15, 17), []string{"5m", "1h"}},
+		{utc(2024, 1, 1, 15, 19), nil},
+		{utc(2024, 1, 1, 15, 20).Add(settle), []string{"5m"}},
+	} {
+		if got := s.Due(tt.now); !slices.Equal(got, tt.want) {
+			t.Fatalf("Due(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+	if got, want := s.NextRun(), utc(2024, 1, 1, 15, 25).Add(settle); !got.Equal(want) {
+		t.Fatalf("NextRun after sleep = %v, want %v", got, want)
+	}
+
+	// 执行失败的周期在下一次唤醒时重试，不改变下一次收盘时间
+	s.MarkFailed("1h")
+	s.MarkFailed("4h")
+	if got := s.Due(utc(2024, 1, 1, 15, 21)); !slices.Equal(got, []string{"1h"}) {
+		t.Fatalf("retry Due = %v, want [1h]", got)
+	}
+	if got := s.Due(utc(2024, 1, 1, 15, 22)); got != nil {
+		t.Fatalf("Due after retry = %v, want nil", got)
+	}
+	if got := s.Due(utc(2024, 1, 1, 16, 0).Add(settle)); !slices.Equal(got, []string{"5m", "1h"}) {
+		t.Fatalf("Due at 16:00 = %v", got)
+	}
+
+	// 重新设置周期时保留已有周期的收盘时间，新周期从当前时间计算
+	if err := s.SetIntervals([]string{"1d", "5m"}, utc(2024, 1, 1, 16, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Due(utc(2024, 1, 1, 16, 5).Add(settle)); !slices.Equal(got, []string{"5m"}) {
+		t.Fatalf("Due after SetIntervals = %v, want [5m]", got)
+	}
+	if got := s.Due(utc(2024, 1, 2, 0, 0).Add(settle)); !slices.Equal(got, []string{"1d", "5m"}) {
+		t.Fatalf("Due at the daily close = %v, want [1d 5m]", got)
+	}
+	if err := s.SetIntervals([]string{"1y"}, utc(2024, 1, 2, 0, 1)); err == nil {
+		t.Fatal("SetIntervals accepted an unknown interval")
+	}
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存存储，用于本地运行和测试，进程退出后数据丢失
+type MemoryStore struct {
+	mu      sync.RWMutex
+	limit   int
+	results map[string][]*TrendResult // key: SYMBOL_interval，按K线开盘时间升序
+
+	confluence map[string][]*ConfluenceResult // key: SYMBOL，按评分时间升序
+	events     []*TrendChanged                // 按保存顺序
+}
+
+// NewMemoryStore 创建内存存储，limit 为每个币种周期保留的最大条数（<=0 不限制）
+func NewMemoryStore(limit int) *MemoryStore {
+	return &MemoryStore{
+		limit:      limit,
+		results:    make(map[string][]*TrendResult),
+		confluence: make(map[string][]*ConfluenceResult),
+	}
+}
+
+func memoryKey(symbol, interval string) string {
+	return fmt.Sprintf("%s_%s", symbol, interval)
+}
+
+// SaveTrendResult 保存趋势结果，同一根K线的结果覆盖旧值
+func (m *MemoryStore) SaveTrendResult(ctx context.Context, result *TrendResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *result
+	key := memoryKey(result.Symbol, result.Interval)
+	list := m.results[key]
+	ts := cp.CandleTimestamp()
+
+	i := sort.Search(len(list), func(i int) bool { return list[i].CandleTimestamp() >= ts })
+	if i < len(list) && list[i].CandleTimestamp() == ts {
+		list[i] = &cp
+	} else {
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = &cp
+	}
+
+	if m.limit > 0 && len(list) > m.limit {
+		list = list[len(list)-m.limit:]
+	}
+	m.results[key] = list
+	return nil
+}
+
+// QueryHistory 按时间倒序查询历史结果
+func (m *MemoryStore) QueryHistory(q HistoryQuery) ([]*TrendResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.results[memoryKey(q.Symbol, q.Interval)]
+	results := make([]*TrendResult, 0)
+	skipped := 0
+	for i := len(list) - 1; i >= 0; i-- {
+		r := list[i]
+		if !q.From.IsZero() && r.CandleTimestamp() < q.From.Unix() {
+			break
+		}
+		if !q.To.IsZero() && r.CandleTimestamp() > q.To.Unix() {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		cp := *r
+		results = append(results, &cp)
+		if q.Limit > 0 && len(results) >= q.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// LatestTrendResult 返回最新的结果
+func (m *MemoryStore) LatestTrendResult(symbol, interval string) (*TrendResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.results[memoryKey(symbol, interval)]
+	if len(list) == 0 {
+		return nil, nil
+	}
+	cp := *list[len(list)-1]
+	return &cp, nil
+}
+
+// SaveConfluence 保存多周期共振评分，同一币种同一秒的评分覆盖旧值（与 SQL 存储一致）
+func (m *MemoryStore) SaveConfluence(ctx context.Context, result *ConfluenceResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *result
+	list := m.confluence[result.Symbol]
+	ts := cp.Time.Unix()
+
+	i := sort.Search(len(list), func(i int) bool { return list[i].Time.Unix() >= ts })
+	if i < len(list) && list[i].Time.Unix() == ts {
+		list[i] = &cp
+	} else {
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = &cp
+	}
+
+	if m.limit > 0 && len(list) > m.limit {
+		list = list[len(list)-m.limit:]
+	}
+	m.confluence[result.Symbol] = list
+	return nil
+}
+
+// QueryConfluence 按时间倒序查询币种的历史评分
+func (m *MemoryStore) QueryConfluence(symbol string, from, to time.Time, limit int) ([]*ConfluenceResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.confluence[symbol]
+	results := make([]*ConfluenceResult, 0)
+	for i := len(list) - 1; i >= 0; i-- {
+		r := list[i]
+		if !from.IsZero() && r.Time.Before(from) {
+			break
+		}
+		if !to.IsZero() && r.Time.After(to) {
+			continue
+		}
+		cp := *r
+		results = append(results, &cp)
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// SaveTrendEvent 保存状态变化事件，同一币种周期同一根K线已有事件时忽略
+func (m *MemoryStore) SaveTrendEvent(ctx context.Context, event *TrendChanged) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.events {
+		if e.Symbol == event.Symbol && e.Interval == event.Interval && e.OpenTime.Equal(event.OpenTime) {
+			return nil
+		}
+	}
+	cp := *event
+	m.events = append(m.events, &cp)
+	if m.limit > 0 && len(m.events) > m.limit {
+		m.events = m.events[len(m.events)-m.limit:]
+	}
+	return nil
+}
+
+// QueryTrendEvents 按新状态所在K线的开盘时间倒序查询事件
+func (m *MemoryStore) QueryTrendEvents(q EventQuery) ([]*TrendChanged, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]*TrendChanged, 0)
+	for _, e := range m.events {
+		if (q.Symbol != "" && e.Symbol != q.Symbol) || (q.Interval != "" && e.Interval != q.Interval) {
+			continue
+		}
+		if (!q.From.IsZero() && e.OpenTime.Before(q.From)) || (!q.To.IsZero() && e.OpenTime.After(q.To)) {
+			continue
+		}
+		cp := *e
+		events = append(events, &cp)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OpenTime.After(events[j].OpenTime) })
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+	return events, nil
+}
+
+// Close 内存存储无需释放资源
+func (m *MemoryStore) Close() error {
+	return nil
+}
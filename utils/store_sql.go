@@ -0,0 +1,493 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"crypto_trend_monitor/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore 基于 database/sql 的趋势存储，支持 MySQL 和 SQLite。
+// 每个周期一张表：symbol_<interval>
+type SQLStore struct {
+	dialect string
+	getDB   func() *sql.DB
+	closeFn func() error
+
+	migrated sync.Map   // 已完成迁移的周期
+	schemaMu sync.Mutex // 并发写入同一周期时只迁移一次
+}
+
+// NewMySQLStore 创建 MySQL 存储，getDB 返回 nil 时视为数据库不可用
+func NewMySQLStore(getDB func() *sql.DB) *SQLStore {
+	return &SQLStore{
+		dialect: "mysql",
+		getDB:   getDB,
+		closeFn: model.CloseDB,
+	}
+}
+
+// NewSQLiteStore 打开（或创建）SQLite 数据库文件，使用纯 Go 驱动，无需 CGO
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("打开 SQLite 数据库失败: %v", err)
+	}
+	// SQLite 同一时间只允许一个写连接
+	conn.SetMaxOpenConns(1)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("打开 SQLite 数据库失败: %v", err)
+	}
+
+	return &SQLStore{
+		dialect: "sqlite",
+		getDB:   func() *sql.DB { return conn },
+		closeFn: conn.Close,
+	}, nil
+}
+
+// Dialect 返回 SQL 方言：mysql 或 sqlite
+func (s *SQLStore) Dialect() string {
+	return s.dialect
+}
+
+// DB 返回当前可用的连接，不可用时返回 nil
+func (s *SQLStore) DB() *sql.DB {
+	return s.getDB()
+}
+
+// TrendTableName 根据 interval 返回表名：symbol_<interval>。
+// 1M（月线）与 1m（分钟线）在大小写不敏感的文件系统上会冲突，因此月线表名为 symbol_1mon。
+func TrendTableName(interval string) (string, error) {
+	if !config.IsKnownInterval(interval) {
+		return "", fmt.Errorf("不支持的 interval: %s", interval)
+	}
+	if interval == "1M" {
+		return "symbol_1mon", nil
+	}
+	return "symbol_" + interval, nil
+}
+
+// Migrate 对全局表和 intervals 对应的周期表执行迁移，成功后写入这些表时不再重复迁移
+func (s *SQLStore) Migrate(intervals []string) error {
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+
+	m, err := NewMigrator(s)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(intervals); err != nil {
+		return err
+	}
+	s.migrated.Store(GlobalMigrationScope, true)
+	for _, interval := range intervals {
+		s.migrated.Store(interval, true)
+	}
+	return nil
+}
+
+// ensureSchema 首次写入某个周期的表时执行迁移，配置中新增的周期会自动建表。
+// 只在写入路径调用，查询不执行 DDL
+func (s *SQLStore) ensureSchema(interval string) error {
+	return s.migrateOnce(interval, []string{interval})
+}
+
+// ensureGlobalSchema 首次写入全局表（如 trend_confluence）时执行全局迁移
+func (s *SQLStore) ensureGlobalSchema() error {
+	return s.migrateOnce(GlobalMigrationScope, nil)
+}
+
+// migrateOnce 对 intervals 执行迁移（全局迁移总会执行），同一个 key 只迁移一次
+func (s *SQLStore) migrateOnce(key string, intervals []string) error {
+	if _, ok := s.migrated.Load(key); ok {
+		return nil
+	}
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	if _, ok := s.migrated.Load(key); ok {
+		return nil
+	}
+
+	m, err := NewMigrator(s)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(intervals); err != nil {
+		return err
+	}
+	s.migrated.Store(key, true)
+	return nil
+}
+
+// SaveTrendResult 保存趋势结果：插入或更新
+func (s *SQLStore) SaveTrendResult(ctx context.Context, result *TrendResult) error {
+	db := s.getDB()
+	if db == nil {
+		return ErrStoreUnavailable
+	}
+
+	tableName, err := TrendTableName(result.Interval)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureSchema(result.Interval); err != nil {
+		return err
+	}
+
+	// timestamp 为所依据K线的开盘时间（秒），同一根K线的多次分析覆盖为一行
+	timestamp := result.CandleTimestamp()
+
+	var query string
+	if s.dialect == "sqlite" {
+		query = fmt.Sprintf(`
+			INSERT INTO %s (%s)
+			VALUES (%s)
+			ON CONFLICT(symbol, timestamp) DO UPDATE SET
+				%s,
+				updated_at = CURRENT_TIMESTAMP
+		`, tableName, trendColumns, placeholders(), upsertAssignments("excluded.%s"))
+	} else {
+		query = fmt.Sprintf(`
+			INSERT INTO %s (%s)
+			VALUES (%s)
+			ON DUPLICATE KEY UPDATE
+				%s,
+				updated_at = CURRENT_TIMESTAMP
+		`, tableName, trendColumns, placeholders(), upsertAssignments("VALUES(%s)"))
+	}
+
+	_, err = db.ExecContext(ctx, query,
+		result.Symbol, timestamp, result.Status,
+		result.OpenTime.UnixMilli(), result.CloseTime.UnixMilli(), result.Rule,
+		result.Price, result.EMA25, result.EMA50, result.EMA120, result.MA60,
+		result.DIF, result.DEA, result.Histogram, result.Time.Unix(),
+		result.Provisional, result.Closed,
+	)
+	if err != nil {
+		return fmt.Errorf("保存到数据库失败: %w", err)
+	}
+	return nil
+}
+
+// trendColumns 写入的列，顺序与 SaveTrendResult 的参数一致
+const trendColumns = "symbol, timestamp, status, open_time, close_time, rule, close_price, " +
+	"ema25, ema50, ema120, ma60, dif, dea, histogram, analyzed_at, provisional_status, candle_closed"
+
+// placeholders 生成与 trendColumns 数量一致的占位符
+func placeholders() string {
+	n := len(strings.Split(trendColumns, ", "))
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// upsertAssignments 生成冲突时更新的赋值语句，format 为取新值的写法
+func upsertAssignments(format string) string {
+	cols := strings.Split(trendColumns, ", ")
+	assigns := make([]string, 0, len(cols))
+	for _, col := range cols[2:] {
+		assigns = append(assigns, fmt.Sprintf("%s = "+format, col, col))
+	}
+	return strings.Join(assigns, ", ")
+}
+
+// trendSelectColumns 查询的列，兼容迁移前写入、指标列为空的旧数据
+const trendSelectColumns = "symbol, timestamp, status, " +
+	"COALESCE(open_time, timestamp * 1000), COALESCE(close_time, 0), COALESCE(rule, ''), " +
+	"COALESCE(close_price, 0), COALESCE(ema25, 0), COALESCE(ema50, 0), COALESCE(ema120, 0), " +
+	"COALESCE(ma60, 0), COALESCE(dif, 0), COALESCE(dea, 0), COALESCE(histogram, 0), " +
+	"COALESCE(analyzed_at, timestamp), COALESCE(provisional_status, status), COALESCE(candle_closed, 1)"
+
+// QueryHistory 按时间倒序查询历史结果
+func (s *SQLStore) QueryHistory(q HistoryQuery) ([]*TrendResult, error) {
+	db := s.getDB()
+	if db == nil {
+		return nil, ErrStoreUnavailable
+	}
+
+	tableName, err := TrendTableName(q.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	conds := []string{"symbol = ?"}
+	args := []interface{}{q.Symbol}
+	if !q.From.IsZero() {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, q.From.Unix())
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "timestamp <= ?")
+		args = append(args, q.To.Unix())
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY timestamp DESC",
+		trendSelectColumns, tableName, strings.Join(conds, " AND "))
+	if q.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil && isMissingTable(err) {
+		// 尚未写入过，表还未创建
+		return make([]*TrendResult, 0), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询历史趋势失败: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*TrendResult, 0)
+	for rows.Next() {
+		var (
+			r                              TrendResult
+			timestamp, openTime, closeTime int64
+			analyzedAt                     int64
+			status, provisional            string
+		)
+		err := rows.Scan(&r.Symbol, &timestamp, &status, &openTime, &closeTime, &r.Rule,
+			&r.Price, &r.EMA25, &r.EMA50, &r.EMA120, &r.MA60, &r.DIF, &r.DEA, &r.Histogram, &analyzedAt,
+			&provisional, &r.Closed)
+		if err != nil {
+			return nil, fmt.Errorf("读取历史趋势失败: %v", err)
+		}
+		r.Interval = q.Interval
+		r.Status = TrendStatus(status)
+		r.Provisional = TrendStatus(provisional)
+		r.OpenTime = time.UnixMilli(openTime)
+		if closeTime > 0 {
+			r.CloseTime = time.UnixMilli(closeTime)
+		}
+		r.Time = time.Unix(analyzedAt, 0)
+		results = append(results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取历史趋势失败: %v", err)
+	}
+	return results, nil
+}
+
+// LatestTrendResult 返回最新的结果
+func (s *SQLStore) LatestTrendResult(symbol, interval string) (*TrendResult, error) {
+	results, err := s.QueryHistory(HistoryQuery{Symbol: symbol, Interval: interval, Limit: 1})
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// SaveConfluence 保存多周期共振评分，同一币种同一秒的评分覆盖为一行
+func (s *SQLStore) SaveConfluence(ctx context.Context, result *ConfluenceResult) error {
+	db := s.getDB()
+	if db == nil {
+		return ErrStoreUnavailable
+	}
+	if err := s.ensureGlobalSchema(); err != nil {
+		return err
+	}
+
+	components, err := json.Marshal(result.Components)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO trend_confluence (symbol, timestamp, score, label, vetoed, veto_by, coverage, components)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if s.dialect == "sqlite" {
+		query += `ON CONFLICT(symbol, timestamp) DO UPDATE SET
+			score = excluded.score, label = excluded.label, vetoed = excluded.vetoed,
+			veto_by = excluded.veto_by, coverage = excluded.coverage, components = excluded.components`
+	} else {
+		query += `ON DUPLICATE KEY UPDATE
+			score = VALUES(score), label = VALUES(label), vetoed = VALUES(vetoed),
+			veto_by = VALUES(veto_by), coverage = VALUES(coverage), components = VALUES(components)`
+	}
+
+	_, err = db.ExecContext(ctx, query, result.Symbol, result.Time.Unix(), result.Score, result.Label,
+		result.Vetoed, result.VetoBy, result.Coverage, string(components))
+	if err != nil {
+		return fmt.Errorf("保存多周期评分失败: %w", err)
+	}
+	return nil
+}
+
+// QueryConfluence 按时间倒序查询币种的历史评分
+func (s *SQLStore) QueryConfluence(symbol string, from, to time.Time, limit int) ([]*ConfluenceResult, error) {
+	db := s.getDB()
+	if db == nil {
+		return nil, ErrStoreUnavailable
+	}
+
+	conds := []string{"symbol = ?"}
+	args := []interface{}{symbol}
+	if !from.IsZero() {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, from.Unix())
+	}
+	if !to.IsZero() {
+		conds = append(conds, "timestamp <= ?")
+		args = append(args, to.Unix())
+	}
+	query := "SELECT symbol, timestamp, score, label, vetoed, veto_by, coverage, components FROM trend_confluence WHERE " +
+		strings.Join(conds, " AND ") + " ORDER BY timestamp DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil && isMissingTable(err) {
+		// 尚未写入过，表还未创建
+		return make([]*ConfluenceResult, 0), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询多周期评分失败: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*ConfluenceResult, 0)
+	for rows.Next() {
+		var (
+			r          ConfluenceResult
+			timestamp  int64
+			label      string
+			components string
+		)
+		if err := rows.Scan(&r.Symbol, &timestamp, &r.Score, &label, &r.Vetoed, &r.VetoBy, &r.Coverage, &components); err != nil {
+			return nil, fmt.Errorf("读取多周期评分失败: %v", err)
+		}
+		if err := json.Unmarshal([]byte(components), &r.Components); err != nil {
+			return nil, fmt.Errorf("解析多周期评分失败: %v", err)
+		}
+		r.Label = ConfluenceLabel(label)
+		r.Time = time.Unix(timestamp, 0)
+		results = append(results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取多周期评分失败: %v", err)
+	}
+	return results, nil
+}
+
+// SaveTrendEvent 保存状态变化事件，同一币种周期同一根K线已有事件时忽略
+func (s *SQLStore) SaveTrendEvent(ctx context.Context, event *TrendChanged) error {
+	db := s.getDB()
+	if db == nil {
+		return ErrStoreUnavailable
+	}
+	if err := s.ensureGlobalSchema(); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO trend_events (symbol, kline_interval, from_status, to_status, since, held_seconds, price, open_time, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if s.dialect == "sqlite" {
+		query += "ON CONFLICT(symbol, kline_interval, open_time) DO NOTHING"
+	} else {
+		query += "ON DUPLICATE KEY UPDATE id = id"
+	}
+
+	_, err := db.ExecContext(ctx, query, event.Symbol, event.Interval, event.From, event.To,
+		event.Since.UnixMilli(), int64(event.Held.Seconds()), event.Price, event.OpenTime.UnixMilli(), event.Time.Unix())
+	if err != nil {
+		return fmt.Errorf("保存状态变化事件失败: %w", err)
+	}
+	return nil
+}
+
+// QueryTrendEvents 按新状态所在K线的开盘时间倒序查询事件
+func (s *SQLStore) QueryTrendEvents(q EventQuery) ([]*TrendChanged, error) {
+	db := s.getDB()
+	if db == nil {
+		return nil, ErrStoreUnavailable
+	}
+
+	conds := []string{"1 = 1"}
+	var args []interface{}
+	if q.Symbol != "" {
+		conds = append(conds, "symbol = ?")
+		args = append(args, q.Symbol)
+	}
+	if q.Interval != "" {
+		conds = append(conds, "kline_interval = ?")
+		args = append(args, q.Interval)
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "open_time >= ?")
+		args = append(args, q.From.UnixMilli())
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "open_time <= ?")
+		args = append(args, q.To.UnixMilli())
+	}
+	query := "SELECT symbol, kline_interval, from_status, to_status, since, held_seconds, price, open_time, detected_at " +
+		"FROM trend_events WHERE " + strings.Join(conds, " AND ") + " ORDER BY open_time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil && isMissingTable(err) {
+		// 尚未写入过，表还未创建
+		return make([]*TrendChanged, 0), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询状态变化事件失败: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]*TrendChanged, 0)
+	for rows.Next() {
+		var (
+			e                               TrendChanged
+			from, to                        string
+			since, held, openTime, detected int64
+		)
+		if err := rows.Scan(&e.Symbol, &e.Interval, &from, &to, &since, &held, &e.Price, &openTime, &detected); err != nil {
+			return nil, fmt.Errorf("读取状态变化事件失败: %v", err)
+		}
+		e.From = TrendStatus(from)
+		e.To = TrendStatus(to)
+		e.Since = time.UnixMilli(since)
+		e.Held = time.Duration(held) * time.Second
+		e.OpenTime = time.UnixMilli(openTime)
+		e.Time = time.Unix(detected, 0)
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取状态变化事件失败: %v", err)
+	}
+	return events, nil
+}
+
+// isMissingTable 判断查询失败是否因为表尚未创建（还没有执行迁移）
+func isMissingTable(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1146 // ER_NO_SUCH_TABLE
+	}
+	return strings.Contains(err.Error(), "no such table")
+}
+
+// Close 关闭数据库连接
+func (s *SQLStore) Close() error {
+	if s.closeFn == nil {
+		return nil
+	}
+	return s.closeFn()
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testStore 同时实现结果、评分和事件存储的存储
+type testStore interface {
+	TrendStore
+	ConfluenceStore
+	EventStore
+}
+
+// forEachStore 对内存存储和 SQLite 存储分别运行同一组检查，两者的行为应一致
+func forEachStore(t *testing.T, check func(t *testing.T, store testStore)) {
+	t.Run("memory", func(t *testing.T) { check(t, NewMemoryStore(0)) })
+	t.Run("sqlite", func(t *testing.T) { check(t, openTestSQLite(t)) })
+}
+
+func TestStoreTrendResults(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store testStore) {
+		ctx := context.Background()
+		base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		save := func(bar int, status TrendStatus, price float64) {
+			t.Helper()
+			open := base.Add(time.Duration(bar) * time.Hour)
+			err := store.SaveTrendResult(ctx, &TrendResult{
+				Symbol: "BTCUSDT", Interval: "1h", Status: status, Provisional: status, Closed: true, Rule: RuleMACDXStrong,
+				Price: price, OpenTime: open, CloseTime: open.Add(time.Hour - time.Millisecond), Time: open.Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if latest, err := store.LatestTrendResult("BTCUSDT", "1h"); err != nil || latest != nil {
+			t.Fatalf("LatestTrendResult on empty store = %v, %v", latest, err)
+		}
+		// 乱序写入，同一根K线的再次分析覆盖旧值
+		save(2, RANGE, 102)
+		save(0, BUYMACD, 100)
+		save(1, RANGE, 101)
+		save(2, SELLMACD, 102.5)
+		save(0, BUYMACD, 100) // 其他周期和币种互不影响
+		if err := store.SaveTrendResult(ctx, &TrendResult{Symbol: "ETHUSDT", Interval: "1h", Status: RANGE, OpenTime: base}); err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := store.QueryHistory(HistoryQuery{Symbol: "BTCUSDT", Interval: "1h"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 3 {
+			t.Fatalf("got %d results, want 3", len(history))
+		}
+		for i, want := range []struct {
+			bar    int
+			status TrendStatus
+			price  float64
+		}{{2, SELLMACD, 102.5}, {1, RANGE, 101}, {0, BUYMACD, 100}} {
+			r := history[i]
+			if !r.OpenTime.Equal(base.Add(time.Duration(want.bar)*time.Hour)) || r.Status != want.status || r.Price != want.price ||
+				r.Provisional != want.status || !r.Closed || r.Rule != RuleMACDXStrong {
+				t.Errorf("history[%d] = %+v, want bar %d %s %v", i, r, want.bar, want.status, want.price)
+			}
+		}
+
+		latest, err := store.LatestTrendResult("BTCUSDT", "1h")
+		if err != nil || latest == nil || latest.Status != SELLMACD {
+			t.Fatalf("LatestTrendResult = %+v, %v", latest, err)
+		}
+
+		// 分页和时间范围
+		page, err := store.QueryHistory(HistoryQuery{Symbol: "BTCUSDT", Interval: "1h", Limit: 1, Offset: 1})
+		if err != nil || len(page) != 1 || page[0].Status != RANGE {
+			t.Fatalf("page = %v, %v", page, err)
+		}
+		ranged, err := store.QueryHistory(HistoryQuery{Symbol: "BTCUSDT", Interval: "1h", From: base, To: base.Add(time.Hour)})
+		if err != nil || len(ranged) != 2 || !ranged[0].OpenTime.Equal(base.Add(time.Hour)) {
+			t.Fatalf("ranged = %v, %v", ranged, err)
+		}
+	})
+}
+
+func TestStoreConfluence(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store testStore) {
+		ctx := context.Background()
+		base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		save := func(at time.Time, score float64, label ConfluenceLabel) {
+			t.Helper()
+			err := store.SaveConfluence(ctx, &ConfluenceResult{
+				Symbol: "BTCUSDT", Score: score, Label: label, Coverage: 1, Time: at,
+				Components: []ConfluenceComponent{{Interval: "1h", Status: BUYMACD, Direction: 1, Weight: 2}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 同一秒内的多次评分只保留最后一次，乱序写入按时间排序
+		save(base.Add(2*time.Second), -0.5, BEAR)
+		save(base, 0.1, MIXED)
+		save(base.Add(300*time.Millisecond), 0.4, BULL)
+		save(base.Add(time.Second), 0.8, STRONG_BULL)
+
+		all, err := store.QueryConfluence("BTCUSDT", time.Time{}, time.Time{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 {
+			t.Fatalf("got %d results, want 3 (one per second): %v", len(all), all)
+		}
+		for i, want := range []ConfluenceLabel{BEAR, STRONG_BULL, BULL} {
+			if all[i].Label != want {
+				t.Errorf("results[%d] = %s, want %s", i, all[i].Label, want)
+			}
+		}
+		if r := all[2]; r.Score != 0.4 || r.Time.Unix() != base.Unix() || len(r.Components) != 1 || r.Components[0].Weight != 2 {
+			t.Errorf("upserted result = %+v", r)
+		}
+
+		latest, err := store.QueryConfluence("BTCUSDT", time.Time{}, time.Time{}, 1)
+		if err != nil || len(latest) != 1 || latest[0].Label != BEAR {
+			t.Fatalf("latest = %v, %v", latest, err)
+		}
+		ranged, err := store.QueryConfluence("BTCUSDT", base.Add(time.Second), base.Add(time.Second), 0)
+		if err != nil || len(ranged) != 1 || ranged[0].Label != STRONG_BULL {
+			t.Fatalf("ranged = %v, %v", ranged, err)
+		}
+		if other, err := store.QueryConfluence("ETHUSDT", time.Time{}, time.Time{}, 0); err != nil || len(other) != 0 {
+			t.Fatalf("other symbol = %v, %v", other, err)
+		}
+	})
+}
+
+func TestStoreTrendEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store testStore) {
+		ctx := context.Background()
+		base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		event := func(symbol, interval string, bar int, to TrendStatus) *TrendChanged {
+			open := base.Add(time.Duration(bar) * time.Hour)
+			return &TrendChanged{
+				Symbol: symbol, Interval: interval, From: RANGE, To: to, Price: 100 + float64(bar),
+				Since: base, Held: time.Duration(bar) * time.Hour, OpenTime: open, Time: open.Add(time.Hour),
+			}
+		}
+		for _, e := range []*TrendChanged{
+			event("BTCUSDT", "1h", 3, BUYMACD),
+			event("BTCUSDT", "1h", 1, SELLMACD),
+			event("BTCUSDT", "1h", 3, SELLMACD), // 同一根K线已有事件，忽略
+			event("BTCUSDT", "4h", 2, BUYMACD),
+			event("ETHUSDT", "1h", 5, BUYMACD),
+		} {
+			if err := store.SaveTrendEvent(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		events, err := store.QueryTrendEvents(EventQuery{Symbol: "BTCUSDT", Interval: "1h"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 || events[0].To != BUYMACD || !events[0].OpenTime.Equal(base.Add(3*time.Hour)) ||
+			events[0].Held != 3*time.Hour || !events[0].Since.Equal(base) || events[1].To != SELLMACD {
+			t.Fatalf("events = %+v", events)
+		}
+
+		all, err := store.QueryTrendEvents(EventQuery{Limit: 2})
+		if err != nil || len(all) != 2 || all[0].Symbol != "ETHUSDT" || all[1].OpenTime.Unix() != base.Add(3*time.Hour).Unix() {
+			t.Fatalf("all = %+v, %v", all, err)
+		}
+		ranged, err := store.QueryTrendEvents(EventQuery{Symbol: "BTCUSDT", From: base.Add(2 * time.Hour), To: base.Add(2 * time.Hour)})
+		if err != nil || len(ranged) != 1 || ranged[0].Interval != "4h" {
+			t.Fatalf("ranged = %+v, %v", ranged, err)
+		}
+	})
+}
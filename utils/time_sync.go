@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeSync 与交易所服务器时间同步，记录本机时钟的偏差和漂移。
+// Now 返回按偏差校正后的交易所时间，判断K线是否收盘和调度都应使用它。
+type TimeSync struct {
+	URL string
+	// HTTPClient 请求服务器时间的客户端，创建后复用；代理变化时由 SetProxy 替换
+	HTTPClient *http.Client
+	// LocalClock 本机时钟，可替换为模拟时钟
+	LocalClock func() time.Time
+	// MaxSkew 偏差超过该值时告警
+	MaxSkew time.Duration
+
+	mu       sync.RWMutex
+	offset   time.Duration // 交易所时间 - 本机时间
+	drift    float64       // 偏差变化速度（毫秒/小时）
+	rtt      time.Duration
+	lastSync time.Time // 上次成功同步的本机时间
+	synced   bool
+	lastErr  error
+}
+
+// TimeSyncStatus 时间同步状态，用于 API 输出
+type TimeSyncStatus struct {
+	Synced    bool    `json:"synced"`
+	OffsetMs  int64   `json:"offset_ms"`
+	DriftMsH  float64 `json:"drift_ms_per_hour"`
+	RTTMs     int64   `json:"rtt_ms"`
+	LastSync  string  `json:"last_sync"`
+	ServerNow string  `json:"server_now"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// NewTimeSync 按配置创建时间同步，服务器时间接口为 api_base_url + time_sync.endpoint
+func NewTimeSync() (*TimeSync, error) {
+	cfg := config.Get()
+	t := &TimeSync{
+		URL:        cfg.APIBaseURL + cfg.TimeSync.Endpoint,
+		LocalClock: time.Now,
+		MaxSkew:    time.Duration(cfg.TimeSync.MaxSkew) * time.Millisecond,
+	}
+	if err := t.SetProxy(cfg.ProxyURL); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetProxy 按代理地址重新创建 HTTP 客户端，proxy 为空时使用环境变量中的代理。热加载修改 proxy_url 时调用
+func (t *TimeSync) SetProxy(proxy string) error {
+	client, err := newProxyHTTPClient(proxy, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.HTTPClient = client
+	t.mu.Unlock()
+	return nil
+}
+
+// Now 返回校正后的交易所时间，未同步时为本机时间
+func (t *TimeSync) Now() time.Time {
+	t.mu.RLock()
+	offset := t.offset
+	t.mu.RUnlock()
+	return t.LocalClock().Add(offset)
+}
+
+// Offset 返回当前偏差（交易所时间 - 本机时间）
+func (t *TimeSync) Offset() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.offset
+}
+
+// Sync 请求一次服务器时间，按往返时间的一半估算偏差
+func (t *TimeSync) Sync() error {
+	t.mu.RLock()
+	client := t.HTTPClient
+	t.mu.RUnlock()
+
+	start := t.LocalClock()
+	serverTime, err := fetchServerTime(client, t.URL)
+	end := t.LocalClock()
+	if err != nil {
+		t.mu.Lock()
+		t.lastErr = err
+		t.mu.Unlock()
+		return err
+	}
+
+	rtt := end.Sub(start)
+	offset := time.UnixMilli(serverTime).Add(rtt / 2).Sub(end)
+
+	t.mu.Lock()
+	if t.synced {
+		if hours := end.Sub(t.lastSync).Hours(); hours > 0 {
+			t.drift = float64((offset - t.offset).Milliseconds()) / hours
+		}
+	}
+	t.offset = offset
+	t.rtt = rtt
+	t.lastSync = end
+	t.synced = true
+	t.lastErr = nil
+	t.mu.Unlock()
+
+	if abs := offset.Abs(); t.MaxSkew > 0 && abs > t.MaxSkew {
+		log.Printf("⚠️ [TimeSync] 本机时钟与交易所相差 %v（往返 %v），已按交易所时间校正", offset, rtt)
+	}
+	return nil
+}
+
+// fetchServerTime 请求服务器时间（毫秒）
+func fetchServerTime(client *http.Client, url string) (int64, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("请求服务器时间失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("读取服务器时间失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("请求服务器时间失败: 状态码 %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ServerTime <= 0 {
+		return 0, fmt.Errorf("解析服务器时间失败: %s", body)
+	}
+	return payload.ServerTime, nil
+}
+
+// Run 按 interval 定期同步，直到 stop 关闭
+func (t *TimeSync) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Sync(); err != nil {
+				log.Printf("[TimeSync] 同步失败，继续使用上次的偏差: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Status 返回当前同步状态
+func (t *TimeSync) Status() TimeSyncStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status := TimeSyncStatus{
+		Synced:    t.synced,
+		OffsetMs:  t.offset.Milliseconds(),
+		DriftMsH:  t.drift,
+		RTTMs:     t.rtt.Milliseconds(),
+		LastSync:  formatAPITime(t.lastSync),
+		ServerNow: t.LocalClock().Add(t.offset).Format("2006-01-02 15:04:05.000"),
+	}
+	if t.lastErr != nil {
+		status.Error = t.lastErr.Error()
+	}
+	return status
+}
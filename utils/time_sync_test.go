@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 可手动推进的本机时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeTimeServer 模拟 /fapi/v1/time：服务器时间 = 本机时钟 + offset，请求和响应各耗时 rtt/2
+type fakeTimeServer struct {
+	*httptest.Server
+	clock *fakeClock
+
+	mu     sync.Mutex
+	offset time.Duration
+	rtt    time.Duration
+	status int
+	body   string
+}
+
+func newFakeTimeServer(t *testing.T, clock *fakeClock) *fakeTimeServer {
+	f := &fakeTimeServer{clock: clock, status: http.StatusOK}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fapi/v1/time" {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		offset, rtt, status, body := f.offset, f.rtt, f.status, f.body
+		f.mu.Unlock()
+
+		f.clock.Advance(rtt / 2)
+		if body == "" {
+			body = fmt.Sprintf(`{"serverTime":%d}`, f.clock.Now().Add(offset).UnixMilli())
+		}
+		f.clock.Advance(rtt / 2)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTimeServer) set(offset, rtt time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offset, f.rtt = offset, rtt
+}
+
+func TestTimeSyncOffsetAndDrift(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	srv := newFakeTimeServer(t, clock)
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.APIBaseURL = srv.URL
+	setTestConfig(t, cfg)
+	// 使用默认的 time_sync.endpoint
+	ts, err := NewTimeSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.LocalClock = clock.Now
+
+	if !ts.Now().Equal(clock.Now()) || ts.Status().Synced {
+		t.Fatal("unsynced TimeSync should use the local clock")
+	}
+
+	// 交易所快 1.5 秒，往返 400ms：偏差按 RTT/2 校正后应为 1.5 秒整
+	srv.set(1500*time.Millisecond, 400*time.Millisecond)
+	if err := ts.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.Offset(); got != 1500*time.Millisecond {
+		t.Fatalf("offset = %v, want 1.5s", got)
+	}
+	if got := ts.Now().Sub(clock.Now()); got != 1500*time.Millisecond {
+		t.Fatalf("Now - local = %v, want 1.5s", got)
+	}
+	status := ts.Status()
+	if !status.Synced || status.RTTMs != 400 || status.OffsetMs != 1500 || status.DriftMsH != 0 {
+		t.Fatalf("status after first sync = %+v", status)
+	}
+
+	// 两次同步结束相隔 2 小时，交易所快 1.52 秒（往返变为 100ms）：漂移为 10ms/小时
+	clock.Advance(2*time.Hour - 100*time.Millisecond)
+	srv.set(1520*time.Millisecond, 100*time.Millisecond)
+	if err := ts.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	status = ts.Status()
+	if status.OffsetMs != 1520 || status.RTTMs != 100 || status.DriftMsH != 10 {
+		t.Fatalf("status after second sync = %+v, want offset 1520 rtt 100 drift 10", status)
+	}
+
+	// 本机时钟快于交易所时偏差为负
+	clock.Advance(time.Hour - 60*time.Millisecond)
+	srv.set(-800*time.Millisecond, 60*time.Millisecond)
+	if err := ts.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.Offset(); got != -800*time.Millisecond {
+		t.Fatalf("offset = %v, want -800ms", got)
+	}
+	if status := ts.Status(); status.DriftMsH != -2320 {
+		t.Fatalf("drift = %v, want -2320ms/h", status.DriftMsH)
+	}
+}
+
+func TestTimeSyncErrorsKeepOffset(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	srv := newFakeTimeServer(t, clock)
+	ts := &TimeSync{URL: srv.URL + "/fapi/v1/time", HTTPClient: srv.Client(), LocalClock: clock.Now}
+
+	srv.set(250*time.Millisecond, 20*time.Millisecond)
+	if err := ts.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusInternalServerError, "oops", "状态码 500"},
+		{http.StatusOK, `{"code":-1}`, "解析服务器时间失败"},
+		{http.StatusOK, "not json", "解析服务器时间失败"},
+	}
+	for _, tt := range tests {
+		srv.mu.Lock()
+		srv.status, srv.body = tt.status, tt.body
+		srv.mu.Unlock()
+
+		err := ts.Sync()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("Sync with %d %q: error = %v, want containing %q", tt.status, tt.body, err, tt.want)
+		}
+		if ts.Offset() != 250*time.Millisecond {
+			t.Fatalf("failed sync changed offset to %v", ts.Offset())
+		}
+		if status := ts.Status(); !status.Synced || status.Error == "" {
+			t.Fatalf("status after failed sync = %+v", status)
+		}
+	}
+
+	// 恢复后清除错误
+	srv.mu.Lock()
+	srv.status, srv.body = http.StatusOK, ""
+	srv.mu.Unlock()
+	if err := ts.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if status := ts.Status(); status.Error != "" {
+		t.Fatalf("error not cleared: %+v", status)
+	}
+}
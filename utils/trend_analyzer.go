@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"context"
 	"crypto_trend_monitor/config"
-	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,158 +18,433 @@ const (
 	RANGE    TrendStatus = "RANGE"
 	BUYMACD  TrendStatus = "BUYMACD"
 	SELLMACD TrendStatus = "SELLMACD"
+	XBUYMID  TrendStatus = "XBUYMID"
+	XSELLMID TrendStatus = "XSELLMID"
 )
 
-// TrendResult 趋势分析结果
+// Direction 返回状态的方向：名称含 BUY 为看多（1），含 SELL 为看空（-1），其余为中性（0）。
+// 表达式规则的自定义状态按同样的约定命名即可参与回测和多周期汇总
+func (s TrendStatus) Direction() int {
+	upper := strings.ToUpper(string(s))
+	switch {
+	case strings.Contains(upper, "BUY"):
+		return 1
+	case strings.Contains(upper, "SELL"):
+		return -1
+	}
+	return 0
+}
+
+// TrendResult 趋势分析结果，包含得出状态时使用的K线和全部指标值
 type TrendResult struct {
-	Symbol   string
-	Interval string
-	Status   TrendStatus
-	EMA25    float64
-	EMA50    float64
-	Time     time.Time
+	Symbol      string
+	Interval    string
+	Status      TrendStatus // 已收盘K线确认的状态
+	Provisional TrendStatus // 包含未收盘K线的盘中状态，没有未收盘K线时与 Status 相同
+	Closed      bool        // Status 所依据的K线是否已收盘，新结果始终为 true，早期 live 模式的历史记录可能为 false
+	Rule        string
+	OpenTime    time.Time // 所依据K线的开盘时间，同一根K线的结果在数据库中覆盖更新
+	CloseTime   time.Time // 所依据K线的收盘时间
+	Price       float64   // 所依据K线的收盘价
+	EMA25       float64
+	EMA50       float64
+	EMA120      float64
+	MA60        float64
+	DIF         float64
+	DEA         float64
+	Histogram   float64
+	Time        time.Time // 分析时间
+}
+
+// CandleTimestamp 返回结果所属K线的开盘时间（秒），没有K线信息时使用分析时间
+func (r *TrendResult) CandleTimestamp() int64 {
+	if !r.OpenTime.IsZero() {
+		return r.OpenTime.Unix()
+	}
+	return r.Time.Unix()
+}
+
+// klineHistoryLimit 每次分析使用的K线根数
+const klineHistoryLimit = 499
+
+// analyzerState 分析使用的配置、规则、K线来源和指标，热加载时整体替换，保证一次分析内相互一致
+type analyzerState struct {
+	cfg        *config.Config
+	rules      map[string]TrendRule
+	exprRules  []*ExprRule
+	sources    map[string]KlineSourceBinding
+	indicators map[string]Indicator
+}
+
+// newAnalyzerState 按配置编译规则、创建K线来源和指标，任何一项失败都返回错误
+func newAnalyzerState(cfg *config.Config) (*analyzerState, error) {
+	rules, exprRules, err := buildRuleSet(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := NewKlineSourceBindings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &analyzerState{
+		cfg:        cfg,
+		rules:      rules,
+		exprRules:  exprRules,
+		sources:    sources,
+		indicators: NewIndicatorsFor(cfg),
+	}, nil
 }
 
 // TrendAnalyzer 趋势分析器
 type TrendAnalyzer struct {
-	client     *BinanceClient
-	indicators map[string]Indicator
+	mu    sync.RWMutex
+	state *analyzerState
+	store TrendStore
+	cache *KlineCache
+	clock func() time.Time
+
+	pendingMu sync.Mutex
+	pending   []*TrendResult // 保存失败、等待重试的结果
+
+	confluence    confluenceTracker
+	transitions   transitionTracker
+	eventHandlers []func(ctx context.Context, event *TrendChanged)
+	// 多周期评分标签变化的回调
+	confluenceHandlers []func(ctx context.Context, event *ConfluenceChanged)
 }
 
-// NewTrendAnalyzer 创建趋势分析器
-func NewTrendAnalyzer() *TrendAnalyzer {
-	return &TrendAnalyzer{
-		client:     NewBinanceClient(),
-		indicators: NewIndicators(),
+// maxPendingWrites 最多暂存的待写入结果数，超出时丢弃最旧的
+const maxPendingWrites = 10000
+
+// NewTrendAnalyzer 创建趋势分析器，store 为 nil 时不持久化
+func NewTrendAnalyzer(store TrendStore) *TrendAnalyzer {
+	cfg := config.Get()
+	state, err := newAnalyzerState(cfg)
+	if err != nil {
+		// 启动时已校验过配置，这里只在测试等场景下出现；保留已注册的规则，分析时再报告具体错误
+		log.Printf("初始化分析配置失败: %v", err)
+		state = &analyzerState{cfg: cfg, rules: registeredRules(), indicators: NewIndicatorsFor(cfg)}
+		if sources, err := NewKlineSourceBindings(cfg); err == nil {
+			state.sources = sources
+		}
 	}
+	a := &TrendAnalyzer{
+		state: state,
+		store: store,
+		clock: time.Now,
+	}
+	if cfg := cfg.KlineCache; cfg.Enabled {
+		a.cache = NewKlineCache(cfg.Dir, klineHistoryLimit)
+	}
+	return a
+}
+
+// Store 返回分析器使用的存储，可能为 nil
+func (a *TrendAnalyzer) Store() TrendStore {
+	return a.store
+}
+
+// Now 返回判断K线是否收盘所用的当前时间
+func (a *TrendAnalyzer) Now() time.Time {
+	a.mu.RLock()
+	clock := a.clock
+	a.mu.RUnlock()
+	return clock()
+}
+
+// SetClock 设置判断K线是否收盘所用的时钟，如 TimeSync.Now
+func (a *TrendAnalyzer) SetClock(clock func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock = clock
+}
+
+// PrepareConfig 按新配置编译规则、创建K线来源和指标，返回的 apply 函数把它们与配置一起替换，
+// 并把表达式规则注册到全局注册表。供热加载在发布新配置前调用，出错时不影响当前配置
+func (a *TrendAnalyzer) PrepareConfig(cfg *config.Config) (apply func(), err error) {
+	state, err := newAnalyzerState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := registerExprRules(state.exprRules); err != nil {
+			log.Printf("[Config] 注册表达式规则失败: %v", err)
+		}
+		a.mu.Lock()
+		a.state = state
+		a.mu.Unlock()
+	}, nil
+}
+
+// snapshot 返回当前的配置、规则、K线来源和指标，保证一次分析内参数一致
+func (a *TrendAnalyzer) snapshot() *analyzerState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.state
+}
+
+// source 返回币种的K线来源
+func (a *TrendAnalyzer) source(symbol string) (KlineSourceBinding, error) {
+	binding, ok := a.snapshot().sources[symbol]
+	if !ok {
+		return KlineSourceBinding{}, fmt.Errorf("%s 没有可用的K线来源", symbol)
+	}
+	return binding, nil
+}
+
+// FetchKlines 从币种配置的来源获取最近的K线
+func (a *TrendAnalyzer) FetchKlines(ctx context.Context, symbol, interval string, limit int) ([]KlineData, error) {
+	binding, err := a.source(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return binding.Provider.GetKlines(ctx, binding.Symbol, interval, limit)
 }
 
 // AnalyzeTrend 分析特定币种和时间周期的趋势
-func (a *TrendAnalyzer) AnalyzeTrend(symbol, interval string, db *sql.DB) (*TrendResult, error) {
-	// 获取最大周期值，并多获取一些数据以确保足够
-	maxPeriod := GetMaxPeriod(a.indicators)
-	limit := 499
+func (a *TrendAnalyzer) AnalyzeTrend(ctx context.Context, symbol, interval string) (*TrendResult, error) {
+	// 获取K线数据，启用缓存时只增量获取
+	var klines []KlineData
+	var err error
+	if a.cache != nil {
+		var binding KlineSourceBinding
+		if binding, err = a.source(symbol); err == nil {
+			klines, err = a.cache.Klines(ctx, binding.Provider, binding.Symbol, interval, klineHistoryLimit, a.Now())
+		}
+	} else {
+		klines, err = a.FetchKlines(ctx, symbol, interval, klineHistoryLimit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取K线数据失败: %w", err)
+	}
+	return a.AnalyzeKlines(ctx, symbol, interval, klines)
+}
+
+// AnalyzeKlines 基于给定的K线分析趋势，收盘时间早于当前时间的K线视为已收盘。
+// Status 只依据已收盘K线，Provisional 为包含未收盘K线的盘中状态
+func (a *TrendAnalyzer) AnalyzeKlines(ctx context.Context, symbol, interval string, klines []KlineData) (*TrendResult, error) {
+	return a.analyzeKlines(ctx, symbol, interval, klines, len(ClosedKlines(klines, a.Now())))
+}
+
+// AnalyzeClosedKlines 分析全部已收盘的K线，如实时推送中收到 x=true 后截止到该K线的缓冲区。
+// 是否收盘以交易所推送的标记为准，不受本地时钟偏差影响；结果与轮询时对同一根收盘K线的 Status 一致
+func (a *TrendAnalyzer) AnalyzeClosedKlines(ctx context.Context, symbol, interval string, klines []KlineData) (*TrendResult, error) {
+	return a.analyzeKlines(ctx, symbol, interval, klines, len(klines))
+}
+
+// analyzeKlines 分析趋势，klines 的前 closedN 根为已收盘K线
+func (a *TrendAnalyzer) analyzeKlines(ctx context.Context, symbol, interval string, klines []KlineData, closedN int) (*TrendResult, error) {
+	state := a.snapshot()
+	indicators := state.indicators
+
+	// 数据有问题时宁可不给出状态，也不能让错误的价格进入指标计算
+	if err := ValidateKlines(klines); err != nil {
+		return nil, fmt.Errorf("%s %s K线校验失败，不输出趋势: %w", symbol, interval, err)
+	}
 
-	// 获取K线数据
-	klines, err := a.client.GetKlines(symbol, interval, limit)
+	// 按配置选择趋势规则
+	rule, err := resolveRule(state.cfg, state.rules, symbol, interval)
 	if err != nil {
-		return nil, fmt.Errorf("获取K线数据失败: %v", err)
+		return nil, err
 	}
 
-	if len(klines) < maxPeriod {
-		return nil, fmt.Errorf("获取的K线数据不足: %d/%d", len(klines), maxPeriod)
+	// 获取最大周期值，确保数据足够
+	closed := klines[:closedN]
+	maxPeriod := GetMaxPeriod(indicators)
+	if len(closed) < maxPeriod {
+		return nil, fmt.Errorf("获取的K线数据不足: %d/%d", len(closed), maxPeriod)
 	}
 
-	// 提取收盘价
-	closePrices := ExtractClosePrices(klines)
+	// 确认状态只依据已收盘K线，live 模式的规则按下标偏移取最近收盘的K线；
+	// 盘中状态把未收盘K线当作最后一根，按规则原本的下标求值
+	res := evaluateKlines(symbol, interval, rule, indicators, closed, closedShift(state.cfg, rule))
+	res.Closed = true
+	res.Provisional = res.Status
+	if len(klines) > len(closed) {
+		res.Provisional = evaluateKlines(symbol, interval, rule, indicators, klines, 0).Status
+	}
 
-	// 计算指标
-	price := closePrices[len(closePrices)-1]
-	ema25 := a.indicators["EMA25"].Calculate(closePrices)
-	ema50 := a.indicators["EMA50"].Calculate(closePrices)
-	ma60 := CalculateMA(closePrices, 60)
+	// 先与已保存的上一次状态比较，再保存本次结果
+	a.detectTransition(ctx, res)
 
-	var BuyMACD, SellMACD, Range, XBUYMID, XSELLMID bool
-	if interval == "1h" || interval == "3d" {
-		DIFUP := IsDIFUP(closePrices, 6, 13, 5)
-		DIFDOWN := IsDIFDOWN(closePrices, 6, 13, 5)
-		if price > ema25 && price > ma60 && DIFUP {
-			BuyMACD = true
-		} else if price < ema25 && price < ma60 && DIFDOWN {
-			SellMACD = true
-		} else {
-			Range = true
-		}
-	} else if interval == "15m" || interval == "1d" {
-		DIFUP := IsDIFUP(closePrices, 6, 13, 5)
-		DIFDOWN := IsDIFDOWN(closePrices, 6, 13, 5)
-		if price > ema25 && price > ma60 && DIFUP {
-			BuyMACD = true
-		} else if price < ema25 && price < ma60 && DIFDOWN {
-			SellMACD = true
-		} else {
-			Range = true
+	// 存储不可用时只暂存，不影响分析结果
+	a.save(ctx, res)
+	return res, nil
+}
+
+// save 保存结果，失败（包括存储不可用和退出时被取消）时暂存，由 FlushPending 重试
+func (a *TrendAnalyzer) save(ctx context.Context, res *TrendResult) {
+	if a.store == nil {
+		return
+	}
+	err := a.store.SaveTrendResult(ctx, res)
+	if err == nil {
+		// 存储已恢复，顺便补写之前暂存的结果
+		if a.PendingWrites() > 0 {
+			n, err := a.FlushPending(ctx)
+			if n > 0 {
+				log.Printf("已补写 %d 条暂存的趋势结果", n)
+			}
+			if err != nil && !errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil {
+				log.Printf("%v", err)
+			}
 		}
+		return
+	}
+	if !errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil {
+		log.Printf("保存 %s %s 趋势结果失败，稍后重试: %v", res.Symbol, res.Interval, err)
+	}
+
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	a.pending = append(a.pending, res)
+	if len(a.pending) > maxPendingWrites {
+		a.pending = a.pending[len(a.pending)-maxPendingWrites:]
+	}
+}
 
-		if XSTRONGUP(closePrices, 6, 13, 5) && price > ma60 {
-			XBUYMID = true
-			BuyMACD = false
-			SellMACD = false
-			Range = false
+// FlushPending 写入暂存的结果，返回成功写入的条数。
+// 存储仍不可用或 ctx 取消时停止，未写入的结果继续暂存
+func (a *TrendAnalyzer) FlushPending(ctx context.Context) (int, error) {
+	a.pendingMu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.pendingMu.Unlock()
+	if a.store == nil || len(pending) == 0 {
+		return 0, nil
+	}
+
+	var err error
+	written := 0
+	for ; written < len(pending); written++ {
+		if err = ctx.Err(); err != nil {
+			break
 		}
-		if XSTRONGDOWN(closePrices, 6, 13, 5) && price < ma60 {
-			XSELLMID = true
-			BuyMACD = false
-			SellMACD = false
-			Range = false
+		if err = a.store.SaveTrendResult(ctx, pending[written]); err != nil {
+			break
 		}
-	} else {
-		if XSTRONGUP(closePrices, 6, 13, 5) && price > ma60 {
-			BuyMACD = true
-		} else if XSTRONGDOWN(closePrices, 6, 13, 5) && price < ma60 {
-			SellMACD = true
-		} else {
-			Range = true
+	}
+
+	if rest := pending[written:]; len(rest) > 0 {
+		a.pendingMu.Lock()
+		a.pending = append(rest, a.pending...)
+		if len(a.pending) > maxPendingWrites {
+			a.pending = a.pending[len(a.pending)-maxPendingWrites:]
 		}
+		a.pendingMu.Unlock()
+	}
+	if err != nil {
+		return written, fmt.Errorf("写入暂存的趋势结果失败（剩余 %d 条）: %w", len(pending)-written, err)
 	}
+	return written, nil
+}
 
-	// 判断趋势
-	var status TrendStatus
+// PendingWrites 返回暂存的待写入结果数
+func (a *TrendAnalyzer) PendingWrites() int {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	return len(a.pending)
+}
 
-	if Range {
-		status = RANGE
-	} else if BuyMACD {
-		status = BUYMACD
-	} else if SellMACD {
-		status = SELLMACD
-	} else if XBUYMID {
-		status = "XBUYMID"
-	} else if XSELLMID {
-		status = "XSELLMID"
+// ClosedKlines 返回已收盘的K线，即去掉末尾收盘时间不早于 now 的K线
+func ClosedKlines(klines []KlineData, now time.Time) []KlineData {
+	nowMs := now.UnixMilli()
+	n := len(klines)
+	for n > 0 && klines[n-1].CloseTime >= nowMs {
+		n--
 	}
+	return klines[:n]
+}
 
-	res := &TrendResult{
-		Symbol:   symbol,
-		Interval: interval,
-		Status:   status,
-		EMA25:    ema25,
-		EMA50:    ema50,
-		Time:     time.Now(),
-	}
+// evaluateKlines 计算指标并用规则判断最后一根K线的状态，shift 为规则的下标偏移
+func evaluateKlines(symbol, interval string, rule TrendRule, indicators map[string]Indicator, klines []KlineData, shift int) *TrendResult {
+	// 提取收盘价
+	closePrices := ExtractClosePrices(klines)
 
-	if err := SaveTrendResult(db, res); err != nil {
-		return nil, err
+	// 计算指标
+	price := closePrices[len(closePrices)-1]
+	ema25 := indicators["EMA25"].Calculate(closePrices)
+	ema50 := indicators["EMA50"].Calculate(closePrices)
+	ema120 := indicators["EMA120"].Calculate(closePrices)
+	ma60 := CalculateMA(closePrices, 60)
+	dif, dea, histogram := CalculateMACD(closePrices, 6, 13, 5)
+	last := klines[len(klines)-1]
+
+	status := rule.Evaluate(&RuleInput{
+		Symbol:      symbol,
+		Interval:    interval,
+		Klines:      klines,
+		ClosePrices: closePrices,
+		Price:       price,
+		EMA25:       ema25,
+		EMA50:       ema50,
+		EMA120:      ema120,
+		MA60:        ma60,
+		DIF:         dif[len(dif)-1],
+		DEA:         dea[len(dea)-1],
+		Histogram:   histogram[len(histogram)-1],
+		Shift:       shift,
+	})
+
+	return &TrendResult{
+		Symbol:    symbol,
+		Interval:  interval,
+		Status:    status,
+		Rule:      rule.Name(),
+		OpenTime:  time.UnixMilli(last.OpenTime),
+		CloseTime: time.UnixMilli(last.CloseTime),
+		Price:     price,
+		EMA25:     ema25,
+		EMA50:     ema50,
+		EMA120:    ema120,
+		MA60:      ma60,
+		DIF:       dif[len(dif)-1],
+		DEA:       dea[len(dea)-1],
+		Histogram: histogram[len(histogram)-1],
+		Time:      time.Now(),
 	}
-	return res, nil
 }
 
 // AnalyzeAllTrends 分析所有配置的币种和时间周期的趋势
-func (a *TrendAnalyzer) AnalyzeAllTrends(db *sql.DB) []*TrendResult {
-	results := make([]*TrendResult, 0)
-
-	for _, symbol := range config.GlobalConfig.Symbols {
-		for _, interval := range config.GlobalConfig.Intervals {
-			result, err := a.AnalyzeTrend(symbol, interval, db)
-			if err != nil {
-				fmt.Printf("分析 %s %s 趋势失败: %v\n", symbol, interval, err)
-				continue
-			}
-			results = append(results, result)
+func (a *TrendAnalyzer) AnalyzeAllTrends(ctx context.Context) *AnalysisReport {
+	return a.AnalyzeIntervals(ctx, config.Get().Intervals)
+}
+
+// AnalyzeIntervals 并发分析所有配置的币种在指定周期的趋势，结果按 币种×周期 的配置顺序排列
+func (a *TrendAnalyzer) AnalyzeIntervals(ctx context.Context, intervals []string) *AnalysisReport {
+	return a.AnalyzeSymbols(ctx, config.Get().Symbols, intervals)
+}
+
+// AnalyzeSymbols 并发分析指定币种在指定周期的趋势，结果按 币种×周期 的顺序排列
+func (a *TrendAnalyzer) AnalyzeSymbols(ctx context.Context, symbols, intervals []string) *AnalysisReport {
+	jobs := make([]AnalysisJob, 0, len(symbols)*len(intervals))
+	for _, symbol := range symbols {
+		for _, interval := range intervals {
+			jobs = append(jobs, AnalysisJob{Symbol: symbol, Interval: interval})
 		}
 	}
-
-	return results
+	report := a.AnalyzeJobs(ctx, jobs)
+	report.Confluence = a.UpdateConfluence(ctx, report.Results)
+	return report
 }
 
 // FormatTrendResult 格式化趋势结果为字符串
 func FormatTrendResult(result *TrendResult) string {
 	return fmt.Sprintf(
-		"[%s] %s %s: 当前价格=%.2f, EMA25=%.2f, EMA50=%.2f, 趋势=%s",
+		"[%s] %s %s: K线=%s, 当前价格=%.2f, EMA25=%.2f, EMA50=%.2f, EMA120=%.2f, MA60=%.2f, DIF=%.4f, DEA=%.4f, HIST=%.4f, 趋势=%s, 盘中=%s",
 		result.Time.Format("2006-01-02 15:04:05"),
 		result.Symbol,
 		result.Interval,
+		result.OpenTime.Format("2006-01-02 15:04"),
+		result.Price,
 		result.EMA25,
 		result.EMA50,
+		result.EMA120,
+		result.MA60,
+		result.DIF,
+		result.DEA,
+		result.Histogram,
 		result.Status,
+		result.Provisional,
 	)
 }
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixtureKlines 生成确定的K线序列：正弦波动叠加缓慢上涨，用于比较规则输出
+func fixtureKlines(n int, start time.Time, step time.Duration) []KlineData {
+	klines := make([]KlineData, n)
+	prev := 100.0
+	for i := range klines {
+		open := start.Add(time.Duration(i) * step)
+		c := 100 + 8*math.Sin(float64(i)/6) + 3*math.Sin(float64(i)/2.3) + float64(i)*0.05
+		klines[i] = KlineData{
+			OpenTime:  open.UnixMilli(),
+			Open:      prev,
+			High:      math.Max(prev, c) + 0.5,
+			Low:       math.Min(prev, c) - 0.5,
+			Close:     c,
+			Volume:    10,
+			CloseTime: open.Add(step).UnixMilli() - 1,
+		}
+		prev = c
+	}
+	return klines
+}
+
+// setTestConfig 替换全局配置，测试结束时恢复
+func setTestConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	old := config.Get()
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(old) })
+}
+
+// TestBuiltinRulesConfirmOnClosedKlines 内置规则的确认状态只依据已收盘K线（下标后移一根），
+// 盘中状态与原来对包含未收盘K线的完整序列求值的结果一致
+func TestBuiltinRulesConfirmOnClosedKlines(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	all := fixtureKlines(320, start, time.Hour)
+	ctx := context.Background()
+
+	for _, name := range []string{RuleMACDDIF, RuleMACDDIFXMid, RuleMACDXStrong} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.KlineCache.Enabled = false
+			cfg.RuleBindings = map[string]string{"1h": name}
+			setTestConfig(t, cfg)
+
+			rule, _ := GetTrendRule(name)
+			a := NewTrendAnalyzer(nil)
+			differs := 0
+			for end := 200; end <= len(all); end++ {
+				klines := all[:end]
+				// 时钟停在最后一根K线中间，最后一根为未收盘K线
+				live := klines[len(klines)-1]
+				last := klines[len(klines)-2]
+				a.SetClock(func() time.Time { return time.UnixMilli(live.OpenTime + 30*60*1000) })
+
+				got, err := a.AnalyzeKlines(ctx, "BTCUSDT", "1h", klines)
+				if err != nil {
+					t.Fatalf("AnalyzeKlines: %v", err)
+				}
+				want := evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), klines[:end-1], 1)
+				if got.Status != want.Status || got.Price != last.Close || !got.Closed ||
+					!got.OpenTime.Equal(time.UnixMilli(last.OpenTime)) {
+					t.Fatalf("end=%d: got %s price=%v closed=%v, want %s price=%v closed=true",
+						end, got.Status, got.Price, got.Closed, want.Status, last.Close)
+				}
+				// 原来的算法：对包含未收盘K线的完整序列求值
+				old := evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), klines, 0)
+				if got.Provisional != old.Status {
+					t.Fatalf("end=%d: provisional %s, want %s", end, got.Provisional, old.Status)
+				}
+				if got.Status != got.Provisional {
+					differs++
+				}
+			}
+			// 夹具中存在确认状态与盘中状态不同的位置，确保上面的比较是有效的
+			if differs == 0 {
+				t.Fatalf("fixture never distinguishes closed and live series")
+			}
+		})
+	}
+}
+
+// TestBuiltinShiftReadsLastClosedHistogram live 模式对已收盘K线求值时，柱子取最近收盘的K线与前一根，
+// 与原 XSTRONGUP/XSTRONGDOWN 在其后追加任意一根未收盘K线时的结果相同
+func TestBuiltinShiftReadsLastClosedHistogram(t *testing.T) {
+	all := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	seen := make(map[int]int)
+	for end := 60; end < len(all); end++ {
+		closes := ExtractClosePrices(all[:end])
+		forming := append(append([]float64{}, closes...), closes[len(closes)-1]*1.5)
+		want := 0
+		if XSTRONGUP(forming, 6, 13, 5) {
+			want = 1
+		} else if XSTRONGDOWN(forming, 6, 13, 5) {
+			want = -1
+		}
+		if got := xstrong(&RuleInput{ClosePrices: closes, Shift: 1}); got != want {
+			t.Fatalf("end=%d: xstrong = %d, want %d", end, got, want)
+		}
+		if got := xstrong(&RuleInput{ClosePrices: forming}); got != want {
+			t.Fatalf("end=%d: unshifted xstrong = %d, want %d", end, got, want)
+		}
+		seen[want]++
+	}
+	if seen[1] == 0 || seen[-1] == 0 {
+		t.Fatalf("fixture never produced both directions: %v", seen)
+	}
+}
+
+// TestClosedCandleModeOverride 显式配置 closed 时规则下标不偏移，盘中状态依据完整序列
+func TestClosedCandleModeOverride(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	klines := fixtureKlines(300, start, time.Hour)
+	cfg := config.DefaultConfig()
+	cfg.KlineCache.Enabled = false
+	cfg.RuleBindings = map[string]string{"1h": RuleMACDXStrong}
+	cfg.RuleCandleModes = map[string]string{RuleMACDXStrong: config.CandleModeClosed}
+	setTestConfig(t, cfg)
+
+	live := klines[len(klines)-1]
+	a := NewTrendAnalyzer(nil)
+	a.SetClock(func() time.Time { return time.UnixMilli(live.OpenTime + 1) })
+	res, err := a.AnalyzeKlines(context.Background(), "BTCUSDT", "1h", klines)
+	if err != nil {
+		t.Fatalf("AnalyzeKlines: %v", err)
+	}
+	rule, _ := GetTrendRule(RuleMACDXStrong)
+	closed := evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), klines[:len(klines)-1], 0)
+	full := evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), klines, 0)
+	if !res.Closed || res.Price != klines[len(klines)-2].Close || res.Status != closed.Status {
+		t.Fatalf("got %s price=%v closed=%v, want %s price=%v closed=true",
+			res.Status, res.Price, res.Closed, closed.Status, klines[len(klines)-2].Close)
+	}
+	if res.Provisional != full.Status {
+		t.Fatalf("provisional %s, want %s", res.Provisional, full.Status)
+	}
+}
+
+// TestAnalyzeClosedKlinesIgnoresClock 推送确认收盘的K线，即使本地时钟落后于收盘时间也按已收盘分析
+func TestAnalyzeClosedKlinesIgnoresClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	klines := fixtureKlines(300, start, time.Hour)
+	cfg := config.DefaultConfig()
+	cfg.KlineCache.Enabled = false
+	cfg.RuleBindings = map[string]string{"1h": RuleMACDXStrong}
+	cfg.RuleCandleModes = map[string]string{RuleMACDXStrong: config.CandleModeClosed}
+	setTestConfig(t, cfg)
+
+	last := klines[len(klines)-1]
+	a := NewTrendAnalyzer(nil)
+	// 本地时钟比交易所慢 2 秒，收到 x=true 时还没到收盘时间
+	a.SetClock(func() time.Time { return time.UnixMilli(last.CloseTime - 2000) })
+
+	res, err := a.AnalyzeClosedKlines(context.Background(), "BTCUSDT", "1h", klines)
+	if err != nil {
+		t.Fatalf("AnalyzeClosedKlines: %v", err)
+	}
+	if !res.Closed || res.Price != last.Close || !res.OpenTime.Equal(time.UnixMilli(last.OpenTime)) {
+		t.Fatalf("got price=%v open=%v closed=%v, want the pushed closed kline", res.Price, res.OpenTime, res.Closed)
+	}
+
+	// 按本地时钟判断时最后一根会被当作未收盘
+	res, err = a.AnalyzeKlines(context.Background(), "BTCUSDT", "1h", klines)
+	if err != nil {
+		t.Fatalf("AnalyzeKlines: %v", err)
+	}
+	if res.Price != klines[len(klines)-2].Close {
+		t.Fatalf("AnalyzeKlines price=%v, want previous close", res.Price)
+	}
+}
+
+// TestStreamMatchesScheduler 实时推送（收盘后只有已收盘K线）与轮询（包含下一根未收盘K线）
+// 对同一根收盘K线得出相同的确认状态和指标
+func TestStreamMatchesScheduler(t *testing.T) {
+	all := fixtureKlines(320, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	ctx := context.Background()
+
+	for _, mode := range []string{config.CandleModeLive, config.CandleModeClosed} {
+		for _, name := range []string{RuleMACDDIF, RuleMACDDIFXMid, RuleMACDXStrong} {
+			t.Run(mode+"/"+name, func(t *testing.T) {
+				cfg := config.DefaultConfig()
+				cfg.KlineCache.Enabled = false
+				cfg.RuleBindings = map[string]string{"1h": name}
+				cfg.RuleCandleModes = map[string]string{name: mode}
+				setTestConfig(t, cfg)
+
+				stream := NewTrendAnalyzer(nil)
+				poll := NewTrendAnalyzer(nil)
+				statuses := make(map[TrendStatus]int)
+				for end := 200; end < len(all); end++ {
+					closedBar, forming := all[end-1], all[end]
+					// 推送：x=true 到达时本地时钟可能还没到收盘时间
+					stream.SetClock(func() time.Time { return time.UnixMilli(closedBar.CloseTime - 500) })
+					pushed, err := stream.AnalyzeClosedKlines(ctx, "BTCUSDT", "1h", all[:end])
+					if err != nil {
+						t.Fatalf("AnalyzeClosedKlines: %v", err)
+					}
+					// 轮询：收盘后 2 秒获取，结果包含刚开始的下一根K线
+					poll.SetClock(func() time.Time { return time.UnixMilli(forming.OpenTime + 2000) })
+					polled, err := poll.AnalyzeKlines(ctx, "BTCUSDT", "1h", all[:end+1])
+					if err != nil {
+						t.Fatalf("AnalyzeKlines: %v", err)
+					}
+
+					if pushed.Status != polled.Status || !pushed.OpenTime.Equal(polled.OpenTime) ||
+						pushed.Price != polled.Price || pushed.Histogram != polled.Histogram || pushed.DIF != polled.DIF {
+						t.Fatalf("end=%d: stream %s %v price=%v, scheduler %s %v price=%v",
+							end, pushed.Status, pushed.OpenTime, pushed.Price, polled.Status, polled.OpenTime, polled.Price)
+					}
+					if !pushed.OpenTime.Equal(time.UnixMilli(closedBar.OpenTime)) {
+						t.Fatalf("end=%d: analyzed candle %v, want %v", end, pushed.OpenTime, time.UnixMilli(closedBar.OpenTime))
+					}
+					statuses[pushed.Status]++
+				}
+				if len(statuses) < 2 {
+					t.Fatalf("fixture produced a single status: %v", statuses)
+				}
+			})
+		}
+	}
+}
+
+// TestReloadPreparesRulesBeforePublishing 热加载新增并绑定表达式规则：规则与配置一起生效，
+// 准备失败时配置和分析使用的规则都保持不变
+func TestReloadPreparesRulesBeforePublishing(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ""
+	cfg.KlineCache.Enabled = false
+	setTestConfig(t, cfg)
+	t.Cleanup(func() { registerExprRules(nil) })
+
+	a := NewTrendAnalyzer(nil)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := `proxy_url: ""
+kline_cache:
+  enabled: false
+expr_rules:
+  - name: reload_buy
+    cases:
+      - status: BUYMACD
+        when: "close > 0"
+`
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(base+"rule_bindings:\n  \"1h\": reload_buy\n", mtime)
+
+	reloader := config.NewReloader(path)
+	reloader.SetPrepare(func(c *config.Config) (func(), error) {
+		apply, err := a.PrepareConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			// 发布新配置前规则必须已经注册
+			if config.Get().RuleBindings["1h"] == "reload_buy" {
+				t.Error("config published before its rules were applied")
+			}
+			apply()
+			if _, ok := GetTrendRule("reload_buy"); !ok {
+				t.Error("rule not registered by apply")
+			}
+		}, nil
+	})
+	if changed, err := reloader.Reload(); err != nil || !changed {
+		t.Fatalf("Reload = %v, %v", changed, err)
+	}
+
+	klines := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	res, err := a.AnalyzeClosedKlines(context.Background(), "BTCUSDT", "1h", klines)
+	if err != nil {
+		t.Fatalf("AnalyzeClosedKlines: %v", err)
+	}
+	if res.Rule != "reload_buy" || res.Status != BUYMACD {
+		t.Fatalf("got rule=%s status=%s, want reload_buy BUYMACD", res.Rule, res.Status)
+	}
+
+	// 绑定不存在的规则：准备失败，保留旧配置和旧规则
+	writeFile(base+"rule_bindings:\n  \"1h\": missing_rule\n", mtime.Add(time.Minute))
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("Reload with an unknown rule succeeded")
+	}
+	if got := config.Get().RuleBindings["1h"]; got != "reload_buy" {
+		t.Fatalf("binding = %s after failed reload, want reload_buy", got)
+	}
+	if res, err := a.AnalyzeClosedKlines(context.Background(), "BTCUSDT", "1h", klines); err != nil || res.Rule != "reload_buy" {
+		t.Fatalf("after failed reload: %v, %v", res, err)
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// TrendChanged 某个币种周期的状态发生变化
+type TrendChanged struct {
+	Symbol   string
+	Interval string
+	From     TrendStatus
+	To       TrendStatus
+	// 旧状态开始的K线开盘时间
+	Since time.Time
+	// 旧状态持续的时间：从 Since 到新状态所在K线的开盘时间
+	Held time.Duration
+	// 新状态所在K线的收盘价
+	Price float64
+	// 新状态所在K线的开盘时间，同一币种周期的同一根K线只记录一次
+	OpenTime time.Time
+	// 检测到变化的时间
+	Time time.Time
+}
+
+// String 输出事件摘要，如 "BTCUSDT 1h: RANGE -> BUYMACD（持续 5h0m0s，价格 65000.00）"
+func (e *TrendChanged) String() string {
+	return fmt.Sprintf("%s %s: %s -> %s（持续 %v，价格 %.2f）", e.Symbol, e.Interval, e.From, e.To, e.Held, e.Price)
+}
+
+// EventQuery 状态变化事件的查询条件，按新状态所在K线的开盘时间过滤，
+// Symbol/Interval 为空或 From/To 为零值时不限制
+type EventQuery struct {
+	Symbol   string
+	Interval string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+// EventStore 可以保存状态变化事件的存储，TrendStore 的实现可选择实现
+type EventStore interface {
+	// SaveTrendEvent 保存事件，同一币种周期同一根K线的事件只保留一条
+	SaveTrendEvent(ctx context.Context, event *TrendChanged) error
+	// QueryTrendEvents 按时间倒序查询事件
+	QueryTrendEvents(q EventQuery) ([]*TrendChanged, error)
+}
+
+// transitionLookback 重启后从历史结果恢复当前状态时最多回看的条数
+const transitionLookback = 500
+
+// transitionState 某个币种周期当前的状态
+type transitionState struct {
+	status   TrendStatus
+	since    time.Time // 当前状态开始的K线开盘时间
+	openTime time.Time // 最近一次结果的K线开盘时间
+}
+
+// transitionTracker 比较每个新结果与上一次的确认状态（已收盘K线得出的 Status），状态不同时产生 TrendChanged。
+// 盘中状态不参与比较；每根K线只在第一次得出结果时比较，同一根K线重复分析不会产生事件，
+// 与 trend_events 每根K线只保留一条事件一致。
+// 某个币种周期首次出现时从存储的历史结果恢复上一次的状态，因此重启后不会重复产生已发生过的变化；
+// 没有历史时以首个结果为起点，不产生事件
+type transitionTracker struct {
+	mu     sync.Mutex
+	states map[string]*transitionState
+}
+
+// observe 记录新的结果，状态变化时返回事件。store 可以为 nil
+func (t *transitionTracker) observe(store TrendStore, res *TrendResult) *TrendChanged {
+	key := bufferKey(res.Symbol, res.Interval)
+	t.mu.Lock()
+	st, ok := t.states[key]
+	t.mu.Unlock()
+	if !ok {
+		// 在锁外读取存储，避免阻塞其他币种周期
+		st = loadTransitionState(store, res.Symbol, res.Interval)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.states == nil {
+		t.states = make(map[string]*transitionState)
+	}
+	if cur, ok := t.states[key]; ok {
+		st = cur
+	}
+	if !res.Closed {
+		return nil
+	}
+	if st == nil {
+		t.states[key] = &transitionState{status: res.Status, since: res.OpenTime, openTime: res.OpenTime}
+		return nil
+	}
+	t.states[key] = st
+
+	// 乱序到达的旧K线和重复分析的同一根K线不影响当前状态
+	if !res.OpenTime.After(st.openTime) {
+		return nil
+	}
+	st.openTime = res.OpenTime
+	if res.Status == st.status {
+		return nil
+	}
+
+	event := &TrendChanged{
+		Symbol:   res.Symbol,
+		Interval: res.Interval,
+		From:     st.status,
+		To:       res.Status,
+		Since:    st.since,
+		Held:     res.OpenTime.Sub(st.since),
+		Price:    res.Price,
+		OpenTime: res.OpenTime,
+		Time:     res.Time,
+	}
+	st.status = res.Status
+	st.since = res.OpenTime
+	return event
+}
+
+// loadTransitionState 从存储的历史结果恢复当前状态及其开始时间，没有历史或存储不可用时返回 nil
+func loadTransitionState(store TrendStore, symbol, interval string) *transitionState {
+	if store == nil {
+		return nil
+	}
+	history, err := store.QueryHistory(HistoryQuery{Symbol: symbol, Interval: interval, Limit: transitionLookback})
+	if err != nil {
+		if !errors.Is(err, ErrStoreUnavailable) {
+			log.Printf("读取 %s %s 历史状态失败，以本次结果为起点: %v", symbol, interval, err)
+		}
+		return nil
+	}
+	// 早期版本保存的未收盘结果不代表确认状态，跳过
+	var st *transitionState
+	for _, r := range history {
+		if !r.Closed {
+			continue
+		}
+		if st == nil {
+			st = &transitionState{status: r.Status, since: r.OpenTime, openTime: r.OpenTime}
+			continue
+		}
+		if r.Status != st.status {
+			break
+		}
+		st.since = r.OpenTime
+	}
+	return st
+}
+
+// OnTrendChanged 注册状态变化的回调，在分析所在的 goroutine 中同步调用，耗时的处理应自行异步执行
+func (a *TrendAnalyzer) OnTrendChanged(fn func(ctx context.Context, event *TrendChanged)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.eventHandlers = append(a.eventHandlers, fn)
+}
+
+// detectTransition 检查结果的状态是否变化，变化时保存事件并通知回调
+func (a *TrendAnalyzer) detectTransition(ctx context.Context, res *TrendResult) {
+	event := a.transitions.observe(a.store, res)
+	if event == nil {
+		return
+	}
+
+	if store, ok := a.store.(EventStore); ok {
+		err := store.SaveTrendEvent(ctx, event)
+		if err != nil && !errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil {
+			log.Printf("保存 %s %s 状态变化事件失败: %v", event.Symbol, event.Interval, err)
+		}
+	}
+
+	a.mu.RLock()
+	handlers := a.eventHandlers
+	a.mu.RUnlock()
+	for _, fn := range handlers {
+		fn(ctx, event)
+	}
+}
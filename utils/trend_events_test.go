@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"testing"
+	"time"
+)
+
+// eventRecorder 收集分析器产生的状态变化事件
+func eventRecorder(a *TrendAnalyzer) *[]*TrendChanged {
+	var events []*TrendChanged
+	a.OnTrendChanged(func(ctx context.Context, event *TrendChanged) {
+		events = append(events, event)
+	})
+	return &events
+}
+
+// TestTransitionsUseConfirmedStatus 盘中状态在一根K线内来回变化时不产生事件，
+// 事件与已收盘K线的确认状态变化一一对应，且与 trend_events 中保存的一致
+func TestTransitionsUseConfirmedStatus(t *testing.T) {
+	all := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	cfg := config.DefaultConfig()
+	cfg.KlineCache.Enabled = false
+	cfg.RuleBindings = map[string]string{"1h": RuleMACDXStrong}
+	setTestConfig(t, cfg)
+
+	store := NewMemoryStore(0)
+	a := NewTrendAnalyzer(store)
+	events := eventRecorder(a)
+	rule, _ := GetTrendRule(RuleMACDXStrong)
+	ctx := context.Background()
+
+	var want []TrendStatus
+	flips := 0
+	for end := 200; end < len(all); end++ {
+		closed := all[:end]
+		want = append(want, evaluateKlines("BTCUSDT", "1h", rule, NewIndicators(), closed, 1).Status)
+
+		// 同一根未收盘K线轮询多次，价格大幅上下波动
+		for i, move := range []float64{1.08, 0.92, 1.08} {
+			forming := all[end]
+			forming.Close = closed[len(closed)-1].Close * move
+			forming.High = max(forming.Open, forming.Close) + 0.5
+			forming.Low = min(forming.Open, forming.Close) - 0.5
+			klines := append(append([]KlineData{}, closed...), forming)
+			a.SetClock(func() time.Time { return time.UnixMilli(forming.OpenTime + int64(i+1)*60000) })
+
+			res, err := a.AnalyzeKlines(ctx, "BTCUSDT", "1h", klines)
+			if err != nil {
+				t.Fatalf("AnalyzeKlines: %v", err)
+			}
+			if res.Provisional != res.Status {
+				flips++
+			}
+		}
+	}
+	if flips == 0 {
+		t.Fatal("fixture never moved the provisional status")
+	}
+
+	var transitions []TrendStatus
+	for i := 1; i < len(want); i++ {
+		if want[i] != want[i-1] {
+			transitions = append(transitions, want[i])
+		}
+	}
+	if len(transitions) == 0 {
+		t.Fatal("fixture has no confirmed transition")
+	}
+	if len(*events) != len(transitions) {
+		t.Fatalf("events = %d, want %d confirmed transitions", len(*events), len(transitions))
+	}
+	for i, e := range *events {
+		if e.To != transitions[i] {
+			t.Fatalf("event %d: %s -> %s, want -> %s", i, e.From, e.To, transitions[i])
+		}
+	}
+	stored, err := store.QueryTrendEvents(EventQuery{Symbol: "BTCUSDT", Interval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(*events) {
+		t.Fatalf("stored %d events, emitted %d", len(stored), len(*events))
+	}
+}
+
+// TestTransitionTracker 按K线开盘时间跟踪确认状态：乱序到达的旧K线、同一根K线的重复分析和未收盘结果不产生事件，
+// Held 为旧状态开始的K线到新状态所在K线的时间；重启后从存储的历史恢复状态，不重复产生已发生的变化
+func TestTransitionTracker(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bar := func(i int) time.Time { return base.Add(time.Duration(i) * time.Hour) }
+	store := NewMemoryStore(0)
+	ctx := context.Background()
+
+	type event struct {
+		from, to TrendStatus
+		since    int
+		held     time.Duration
+	}
+	steps := []struct {
+		restart bool // 换用新的 tracker，模拟进程重启
+		bar     int
+		status  TrendStatus
+		open    bool // 未收盘的结果（早期版本）
+		want    *event
+	}{
+		{bar: 0, status: RANGE},
+		{bar: 1, status: RANGE},
+		{bar: 3, status: BUYMACD, want: &event{RANGE, BUYMACD, 0, 3 * time.Hour}},
+		{bar: 2, status: SELLMACD}, // 乱序到达的旧K线
+		{bar: 3, status: SELLMACD}, // 同一根K线重复分析
+		{bar: 4, status: BUYMACD},
+		{bar: 5, status: SELLMACD, open: true},
+		{bar: 6, status: SELLMACD, want: &event{BUYMACD, SELLMACD, 3, 3 * time.Hour}},
+		{restart: true, bar: 6, status: SELLMACD},
+		{bar: 5, status: RANGE},
+		{bar: 7, status: SELLMACD},
+		{bar: 8, status: RANGE, want: &event{SELLMACD, RANGE, 6, 2 * time.Hour}},
+		{restart: true, bar: 9, status: RANGE},
+		{bar: 10, status: BUYMACD, want: &event{RANGE, BUYMACD, 8, 2 * time.Hour}},
+	}
+
+	tracker := &transitionTracker{}
+	for i, step := range steps {
+		if step.restart {
+			tracker = &transitionTracker{}
+		}
+		res := &TrendResult{
+			Symbol: "BTCUSDT", Interval: "1h", Status: step.status, Provisional: step.status, Closed: !step.open,
+			Price: 100 + float64(step.bar), OpenTime: bar(step.bar), Time: bar(step.bar + 1),
+		}
+		// 与分析器一致：先比较，再保存结果
+		got := tracker.observe(store, res)
+		if err := store.SaveTrendResult(ctx, res); err != nil {
+			t.Fatal(err)
+		}
+
+		if step.want == nil {
+			if got != nil {
+				t.Errorf("step %d (bar %d %s): unexpected event %v", i, step.bar, step.status, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("step %d (bar %d %s): no event, want %s -> %s", i, step.bar, step.status, step.want.from, step.want.to)
+			continue
+		}
+		w := step.want
+		if got.From != w.from || got.To != w.to || !got.Since.Equal(bar(w.since)) || got.Held != w.held ||
+			!got.OpenTime.Equal(res.OpenTime) || got.Price != res.Price || !got.Time.Equal(res.Time) {
+			t.Errorf("step %d: event %+v, want %s -> %s since bar %d held %v", i, got, w.from, w.to, w.since, w.held)
+		}
+	}
+}
+
+// TestTransitionsSurviveRestart 分析器在任意一根K线后重启（新的分析器、同一个存储），
+// 重启后重复分析最后一根K线不会再次产生事件，之后的事件与不中断运行时完全一致
+func TestTransitionsSurviveRestart(t *testing.T) {
+	all := fixtureKlines(300, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour)
+	cfg := config.DefaultConfig()
+	cfg.KlineCache.Enabled = false
+	cfg.RuleBindings = map[string]string{"1h": RuleMACDXStrong}
+	setTestConfig(t, cfg)
+	ctx := context.Background()
+
+	analyze := func(a *TrendAnalyzer, from, to int) {
+		t.Helper()
+		for end := from; end <= to; end++ {
+			if _, err := a.AnalyzeClosedKlines(ctx, "BTCUSDT", "1h", all[:end]); err != nil {
+				t.Fatalf("AnalyzeClosedKlines: %v", err)
+			}
+		}
+	}
+
+	reference := NewTrendAnalyzer(NewMemoryStore(0))
+	want := eventRecorder(reference)
+	analyze(reference, 200, len(all))
+	if len(*want) < 2 {
+		t.Fatalf("fixture has %d transitions, want at least 2", len(*want))
+	}
+
+	// 在第一次变化所在的K线、变化之后的下一根K线和状态保持中间各重启一次
+	first := 200
+	for all[first-1].OpenTime != (*want)[0].OpenTime.UnixMilli() {
+		first++
+	}
+	for _, restartAt := range []int{first, first + 1, (first + len(all)) / 2} {
+		store := NewMemoryStore(0)
+		before := NewTrendAnalyzer(store)
+		beforeEvents := eventRecorder(before)
+		analyze(before, 200, restartAt)
+
+		after := NewTrendAnalyzer(store)
+		afterEvents := eventRecorder(after)
+		analyze(after, restartAt, len(all))
+
+		got := append(*beforeEvents, *afterEvents...)
+		if len(got) != len(*want) {
+			t.Fatalf("restart at %d: %d events, want %d", restartAt, len(got), len(*want))
+		}
+		for i, e := range got {
+			w := (*want)[i]
+			if e.From != w.From || e.To != w.To || !e.Since.Equal(w.Since) || e.Held != w.Held || !e.OpenTime.Equal(w.OpenTime) {
+				t.Errorf("restart at %d: event %d = %v since %v, want %v since %v", restartAt, i, e, e.Since, w, w.Since)
+			}
+		}
+	}
+}
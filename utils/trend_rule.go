@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"crypto_trend_monitor/config"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RuleInput 趋势规则判断所需的行情和指标数据
+type RuleInput struct {
+	Symbol      string
+	Interval    string
+	Klines      []KlineData
+	ClosePrices []float64
+	Price       float64
+	EMA25       float64
+	EMA50       float64
+	EMA120      float64
+	MA60        float64
+	DIF         float64
+	DEA         float64
+	Histogram   float64
+	// Shift 为 live 模式规则的下标偏移。live 模式的规则按最后一根为未收盘K线编写（倒数第 2 根为最近收盘的K线），
+	// 对只有已收盘K线的序列求值时为 1，规则取倒数第 k 根时应取倒数第 k-1 根（最少为最后一根），见 Back
+	Shift int
+}
+
+// Back 按规则的下标偏移取 series 的倒数第 k 根（k=1 为最后一根），series 为空时返回 0
+func (in *RuleInput) Back(series []float64, k int) float64 {
+	k -= in.Shift
+	if k < 1 {
+		k = 1
+	}
+	if k > len(series) {
+		return 0
+	}
+	return series[len(series)-k]
+}
+
+// TrendRule 趋势判断规则接口
+type TrendRule interface {
+	// Name 返回规则名称，用于在配置中绑定
+	Name() string
+	// Evaluate 根据输入数据给出趋势状态
+	Evaluate(in *RuleInput) TrendStatus
+}
+
+// CandleModeRule 声明未配置 rule_candle_modes 时默认K线模式的规则，未实现时为 closed。
+// 两种模式的确认状态都只依据已收盘K线，区别在于规则下标的含义，见 RuleInput.Shift
+type CandleModeRule interface {
+	DefaultCandleMode() string
+}
+
+// TrendRuleFunc 将普通函数包装为 TrendRule
+type TrendRuleFunc struct {
+	RuleName string
+	Fn       func(in *RuleInput) TrendStatus
+	// 默认K线模式，留空为 closed
+	CandleMode string
+}
+
+// Name 返回规则名称
+func (r *TrendRuleFunc) Name() string {
+	return r.RuleName
+}
+
+// Evaluate 调用包装的函数
+func (r *TrendRuleFunc) Evaluate(in *RuleInput) TrendStatus {
+	return r.Fn(in)
+}
+
+// DefaultCandleMode 返回默认K线模式
+func (r *TrendRuleFunc) DefaultCandleMode() string {
+	if r.CandleMode == "" {
+		return config.CandleModeClosed
+	}
+	return r.CandleMode
+}
+
+// ruleCandleMode 返回规则使用的K线模式：rule_candle_modes 优先，其次为规则声明的默认模式
+func ruleCandleMode(cfg *config.Config, rule TrendRule) string {
+	def := config.CandleModeClosed
+	if r, ok := rule.(CandleModeRule); ok {
+		def = r.DefaultCandleMode()
+	}
+	return cfg.CandleMode(rule.Name(), def)
+}
+
+// closedShift 返回规则对已收盘K线序列求值时的下标偏移：live 模式为 1，closed 模式为 0
+func closedShift(cfg *config.Config, rule TrendRule) int {
+	if ruleCandleMode(cfg, rule) == config.CandleModeLive {
+		return 1
+	}
+	return 0
+}
+
+var (
+	ruleMu       sync.RWMutex
+	ruleRegistry = make(map[string]TrendRule)
+)
+
+// RegisterTrendRule 注册趋势规则，同名规则会返回错误
+func RegisterTrendRule(rule TrendRule) error {
+	ruleMu.Lock()
+	defer ruleMu.Unlock()
+
+	name := rule.Name()
+	if name == "" {
+		return fmt.Errorf("规则名称不能为空")
+	}
+	if _, ok := ruleRegistry[name]; ok {
+		return fmt.Errorf("规则已存在: %s", name)
+	}
+	ruleRegistry[name] = rule
+	return nil
+}
+
+// MustRegisterTrendRule 注册趋势规则，失败时 panic，供 init 使用
+func MustRegisterTrendRule(rule TrendRule) {
+	if err := RegisterTrendRule(rule); err != nil {
+		panic(err)
+	}
+}
+
+// GetTrendRule 按名称获取已注册的趋势规则
+func GetTrendRule(name string) (TrendRule, bool) {
+	ruleMu.RLock()
+	defer ruleMu.RUnlock()
+
+	rule, ok := ruleRegistry[name]
+	return rule, ok
+}
+
+// registeredRules 返回当前注册表的副本
+func registeredRules() map[string]TrendRule {
+	ruleMu.RLock()
+	defer ruleMu.RUnlock()
+	rules := make(map[string]TrendRule, len(ruleRegistry))
+	for name, rule := range ruleRegistry {
+		rules[name] = rule
+	}
+	return rules
+}
+
+// TrendRuleNames 返回所有已注册规则的名称（已排序）
+func TrendRuleNames() []string {
+	ruleMu.RLock()
+	defer ruleMu.RUnlock()
+
+	names := make([]string, 0, len(ruleRegistry))
+	for name := range ruleRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveTrendRule 根据当前配置和已注册的规则找到币种和周期对应的规则。
+// 查找顺序：SYMBOL_interval > interval > DefaultRule
+func ResolveTrendRule(symbol, interval string) (TrendRule, error) {
+	name := ruleNameFor(config.Get(), symbol, interval)
+	rule, ok := GetTrendRule(name)
+	if !ok {
+		return nil, fmt.Errorf("未找到趋势规则: %s (%s %s)", name, symbol, interval)
+	}
+	return rule, nil
+}
+
+// ruleNameFor 返回币种和周期绑定的规则名
+func ruleNameFor(cfg *config.Config, symbol, interval string) string {
+	name, ok := cfg.RuleBindings[fmt.Sprintf("%s_%s", symbol, interval)]
+	if !ok {
+		name, ok = cfg.RuleBindings[interval]
+	}
+	if !ok {
+		name = cfg.DefaultRule
+	}
+	return name
+}
+
+// resolveRule 在给定的规则集合中查找币种和周期对应的规则
+func resolveRule(cfg *config.Config, rules map[string]TrendRule, symbol, interval string) (TrendRule, error) {
+	name := ruleNameFor(cfg, symbol, interval)
+	rule, ok := rules[name]
+	if !ok {
+		return nil, fmt.Errorf("未找到趋势规则: %s (%s %s)", name, symbol, interval)
+	}
+	return rule, nil
+}
+
+// ValidateRuleConfig 检查配置中的表达式规则能否编译，以及绑定的规则是否都存在。
+// 按配置生效后的规则集合检查，不修改当前注册表，供启动和热加载前使用。
+func ValidateRuleConfig(cfg *config.Config) error {
+	_, _, err := buildRuleSet(cfg)
+	return err
+}
+
+// buildRuleSet 编译配置中的表达式规则，返回配置生效后的规则集合（已注册的规则去掉之前由配置注册的，
+// 加上本次编译的）和编译出的表达式规则，并检查绑定的规则是否都存在。不修改当前注册表
+func buildRuleSet(cfg *config.Config) (map[string]TrendRule, []*ExprRule, error) {
+	exprRules, err := BuildExprRules(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ruleMu.RLock()
+	available := make(map[string]TrendRule, len(ruleRegistry)+len(exprRules))
+	for name, rule := range ruleRegistry {
+		available[name] = rule
+	}
+	for _, name := range configuredExprRules {
+		delete(available, name)
+	}
+	ruleMu.RUnlock()
+
+	for _, rule := range exprRules {
+		if _, ok := available[rule.Name()]; ok {
+			return nil, nil, fmt.Errorf("表达式规则集与已有规则重名: %s", rule.Name())
+		}
+		available[rule.Name()] = rule
+	}
+
+	var missing []string
+	if _, ok := available[cfg.DefaultRule]; !ok {
+		missing = append(missing, fmt.Sprintf("default_rule=%s", cfg.DefaultRule))
+	}
+	for key, name := range cfg.RuleBindings {
+		if _, ok := available[name]; !ok {
+			missing = append(missing, fmt.Sprintf("%s=%s", key, name))
+		}
+	}
+	for name := range cfg.RuleCandleModes {
+		if _, ok := available[name]; !ok {
+			missing = append(missing, fmt.Sprintf("rule_candle_modes[%s]", name))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		names := make([]string, 0, len(available))
+		for name := range available {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, nil, fmt.Errorf("未注册的趋势规则: %v（可用: %v）", missing, names)
+	}
+	return available, exprRules, nil
+}
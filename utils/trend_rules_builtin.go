@@ -0,0 +1,76 @@
+package utils
+
+import "crypto_trend_monitor/config"
+
+// 内置规则名称
+const (
+	RuleMACDDIF     = "macd_dif"      // 价格站上/跌破 EMA25 与 MA60，并由 DIF 正负确认
+	RuleMACDDIFXMid = "macd_dif_xmid" // 在 macd_dif 基础上，柱子走强/走弱时给出 XBUYMID/XSELLMID
+	RuleMACDXStrong = "macd_xstrong"  // 仅看柱子走强/走弱与 MA60
+)
+
+// 内置规则沿用原来的算法：柱子走强/走弱以最后一根为未收盘K线，取 histogram[len-2] 为最近收盘的K线，
+// 因此默认为 live 模式。确认状态对已收盘K线求值时下标整体后移一根，柱子取最近收盘的K线与前一根
+func init() {
+	MustRegisterTrendRule(&TrendRuleFunc{RuleName: RuleMACDDIF, Fn: evalMACDDIF, CandleMode: config.CandleModeLive})
+	MustRegisterTrendRule(&TrendRuleFunc{RuleName: RuleMACDDIFXMid, Fn: evalMACDDIFXMid, CandleMode: config.CandleModeLive})
+	MustRegisterTrendRule(&TrendRuleFunc{RuleName: RuleMACDXStrong, Fn: evalMACDXStrong, CandleMode: config.CandleModeLive})
+}
+
+// macdLookback MACD 柱子判断所需的最少K线数，与 XSTRONGUP 等一致
+const macdLookback = 13 + 5 + 1
+
+// xstrong 柱子走强（1）、走弱（-1）或都不是（0），对应原 XSTRONGUP/XSTRONGDOWN，
+// 下标按 in.Shift 偏移
+func xstrong(in *RuleInput) int {
+	if len(in.ClosePrices) < macdLookback {
+		return 0
+	}
+	_, _, histogram := CalculateMACD(in.ClosePrices, 6, 13, 5)
+	if len(histogram) < 3 {
+		return 0
+	}
+	d, c := in.Back(histogram, 2), in.Back(histogram, 3)
+	switch {
+	case d > 0 && d > c:
+		return 1
+	case d < 0 && d < c:
+		return -1
+	}
+	return 0
+}
+
+// evalMACDDIF 原 1h/3d 规则
+func evalMACDDIF(in *RuleInput) TrendStatus {
+	DIFUP := IsDIFUP(in.ClosePrices, 6, 13, 5)
+	DIFDOWN := IsDIFDOWN(in.ClosePrices, 6, 13, 5)
+	if in.Price > in.EMA25 && in.Price > in.MA60 && DIFUP {
+		return BUYMACD
+	} else if in.Price < in.EMA25 && in.Price < in.MA60 && DIFDOWN {
+		return SELLMACD
+	}
+	return RANGE
+}
+
+// evalMACDDIFXMid 原 15m/1d 规则
+func evalMACDDIFXMid(in *RuleInput) TrendStatus {
+	x := xstrong(in)
+	if x > 0 && in.Price > in.MA60 {
+		return XBUYMID
+	}
+	if x < 0 && in.Price < in.MA60 {
+		return XSELLMID
+	}
+	return evalMACDDIF(in)
+}
+
+// evalMACDXStrong 原其余周期（5m/4h 等）规则
+func evalMACDXStrong(in *RuleInput) TrendStatus {
+	x := xstrong(in)
+	if x > 0 && in.Price > in.MA60 {
+		return BUYMACD
+	} else if x < 0 && in.Price < in.MA60 {
+		return SELLMACD
+	}
+	return RANGE
+}
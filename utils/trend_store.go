@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"crypto_trend_monitor/config"
+	"crypto_trend_monitor/model"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrStoreUnavailable 存储暂时不可用（如 MySQL 断开处于降级模式）
+var ErrStoreUnavailable = errors.New("存储暂时不可用")
+
+// HistoryQuery 历史趋势查询条件，按K线开盘时间过滤，From/To 为零值时不限制
+type HistoryQuery struct {
+	Symbol   string
+	Interval string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+// TrendStore 趋势结果存储接口
+type TrendStore interface {
+	// SaveTrendResult 保存趋势结果，同一币种同一根K线的结果会被覆盖
+	SaveTrendResult(ctx context.Context, result *TrendResult) error
+	// QueryHistory 按K线时间倒序查询历史结果
+	QueryHistory(q HistoryQuery) ([]*TrendResult, error)
+	// LatestTrendResult 返回最新的结果，没有记录时返回 nil, nil
+	LatestTrendResult(symbol, interval string) (*TrendResult, error)
+	// Close 释放存储资源
+	Close() error
+}
+
+// OpenTrendStore 按配置创建存储，driver 为 none 时返回 nil
+func OpenTrendStore(cfg *config.Config) (TrendStore, error) {
+	switch cfg.Storage.Driver {
+	case "mysql":
+		if !cfg.Database.Enabled {
+			log.Println("数据库已禁用，趋势结果不会持久化")
+			return nil, nil
+		}
+		if err := model.InitDB(cfg.Database); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		return NewMySQLStore(model.GetDB), nil
+	case "sqlite":
+		return NewSQLiteStore(cfg.Storage.SQLitePath)
+	case "memory":
+		return NewMemoryStore(cfg.Storage.MemoryLimit), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("不支持的存储类型: %s", cfg.Storage.Driver)
+}